@@ -2,19 +2,101 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"math/big"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"payment-sim/internal/app"
+	"payment-sim/internal/config"
+	"payment-sim/internal/domain"
 	"payment-sim/internal/service"
 	"payment-sim/internal/store"
 )
 
 func main() {
+	// Load configuration from flags layered over PAYMENT_* environment variables
+	cfg, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+		os.Exit(2)
+	}
+	socketPath := cfg.SocketPath()
+
+	if cfg.Transitions != nil {
+		domain.SetTransitions(cfg.Transitions)
+	}
+
+	if cfg.Threshold != nil {
+		fmt.Fprintf(os.Stderr, "PRE_SETTLEMENT_REVIEW enabled for amounts >= %s\n", domain.FormatRat(cfg.Threshold))
+	}
+
+	// Initialize components; closers are closed in reverse order on exit
+	var closers []io.Closer
+	var repo store.Repository = store.NewMemoryStore()
+	if cfg.StoreFile != "" {
+		fileStore, err := store.NewFileStore(cfg.StoreFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+			os.Exit(1)
+		}
+		repo = fileStore
+		closers = append(closers, fileStore)
+	}
+
+	processor := service.NewProcessor(repo, cfg.Threshold)
+	processor.SetVoidReasons(cfg.VoidReasons)
+	processor.SetRequireVoidReason(cfg.RequireVoidReason)
+	processor.SetCaptureWindow(cfg.CaptureWindow)
+	processor.SetAuthExpiry(cfg.AuthExpiry)
+	processor.SetReviewTTL(cfg.ReviewTTL)
+	var clock domain.Clock = domain.SystemClock{}
+	if cfg.SimClock {
+		clock = domain.NewSimClock(time.Now())
+	}
+	processor.SetClock(clock)
+	processor.SetAmountParser(cfg.AmountParser)
+	processor.SetAmountExpr(cfg.AmountExpr)
+	processor.SetStrictPrecision(cfg.StrictPrecision)
+	processor.SetAllowedCommands(cfg.AllowCommands)
+	processor.SetListAlign(cfg.Align)
+	if cfg.Format == config.FormatText {
+		// JSON results carry full values
+		processor.SetListColumnWidth(cfg.ColWidth)
+	}
+	processor.SetAmountBands(cfg.AmountBands, cfg.StrictBands)
+	processor.SetWarningOutput(os.Stderr)
+	processor.SetNoIdempotent(cfg.NoIdempotent)
+	processor.SetMaxBatchSize(cfg.MaxBatchSize)
+	processor.SetMaxOpenAuth(cfg.MaxOpenAuth)
+	processor.SetAutoIDSeed(cfg.AutoIDSeed)
+	if cfg.StoreFile != "" {
+		processor.SetBenchStore(newScratchFileStore)
+	}
+	if cfg.Trace {
+		processor.AddTransitionHook(func(e service.TransitionEvent) {
+			fmt.Fprintf(os.Stderr, "%s %s->%s\n", e.PaymentID, e.From, e.To)
+		})
+	}
+
+	if cfg.Webhook != "" {
+		client := service.HTTPWebhookClient{URL: cfg.Webhook, Client: &http.Client{Timeout: 5 * time.Second}}
+		emitter := service.NewWebhookEmitter(client, cfg.WebhookRetries, cfg.WebhookBackoff, os.Stderr)
+		emitter.SetClock(clock)
+		processor.AddTransitionHook(emitter.Emit)
+		closers = append(closers, emitter)
+	}
+
 	// Set up graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -22,45 +104,113 @@ func main() {
 	go func() {
 		<-sigChan
 		fmt.Println("\nShutdown requested, exiting...")
-		os.Exit(0)
+		if socketPath != "" {
+			os.Remove(socketPath)
+		}
+		exit(closers, 0)
 	}()
 
-	// Parse PRE_SETTLEMENT_THRESHOLD from environment
-	var threshold *big.Rat
-	if thresholdStr := os.Getenv("PRE_SETTLEMENT_THRESHOLD"); thresholdStr != "" && thresholdStr != "0" {
-		threshold = new(big.Rat)
-		if _, ok := threshold.SetString(thresholdStr); !ok {
-			fmt.Fprintf(os.Stderr, "ERROR invalid PRE_SETTLEMENT_THRESHOLD: %s\n", thresholdStr)
-			os.Exit(1)
-		}
-		fmt.Fprintf(os.Stderr, "PRE_SETTLEMENT_REVIEW enabled for amounts >= %s\n", thresholdStr)
+	var formatter service.Formatter = service.TextFormatter{}
+	if cfg.Format == config.FormatJSON {
+		formatter = service.JSONFormatter{}
+	} else if cfg.UseColor(socketPath == "" && isTerminal(os.Stdout)) {
+		formatter = service.ColorFormatter{}
+	}
+
+	newRunner := func(input io.Reader, output io.Writer) *app.Runner {
+		runner := app.NewRunner(processor, input, output)
+		runner.SetFormatter(formatter)
+		runner.SetQuietReads(cfg.QuietReads)
+		runner.SetEcho(cfg.Echo)
+		runner.SetLineSeparator(cfg.LineSep)
+		runner.SetTiming(cfg.Timing)
+		runner.SetPrintStats(cfg.Stats)
+		return runner
 	}
 
-	// Determine input source
-	var input io.Reader
-	if len(os.Args) > 1 {
-		// File input mode
-		filename := os.Args[1]
-		file, err := os.Open(filename)
+	// Socket mode: serve connections until shutdown
+	if socketPath != "" {
+		listener, err := net.Listen("unix", socketPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR cannot open file: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "ERROR cannot listen: %v\n", err)
+			exit(closers, 1)
+		}
+		fmt.Fprintf(os.Stderr, "Listening on unix:%s\n", socketPath)
+		if err := app.Serve(listener, newRunner); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+			exit(closers, 1)
 		}
-		defer file.Close()
-		input = file
-	} else {
-		// Interactive (stdin) mode
-		input = os.Stdin
+		exit(closers, 0)
 	}
 
-	// Initialize components
-	memStore := store.NewMemoryStore()
-	processor := service.NewProcessor(memStore, threshold)
-	runner := app.NewRunner(processor, input, os.Stdout)
+	// FIFO mode: read the named pipe across writers until EXIT or shutdown
+	if cfg.FIFO != "" {
+		fmt.Fprintf(os.Stderr, "Reading commands from %s\n", cfg.FIFO)
+		runner := newRunner(nil, os.Stdout)
+		runner.SetStrict(!cfg.ContinueOnError)
+		if err := runner.RunReopening(app.OpenFIFO(cfg.FIFO)); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+			exit(closers, 1)
+		}
+		exit(closers, 0)
+	}
 
-	// Run the main loop
+	// File input mode: run each file in order against the same store
+	if len(cfg.Files) > 0 {
+		runner := newRunner(nil, os.Stdout)
+		runner.SetStrict(!cfg.ContinueOnError)
+		if err := runner.RunFiles(cfg.Files); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+			exit(closers, 1)
+		}
+		exit(closers, 0)
+	}
+
+	// Interactive (stdin) mode
+	runner := newRunner(os.Stdin, os.Stdout)
+	runner.SetStrict(!cfg.ContinueOnError)
 	if err := runner.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
-		os.Exit(1)
+		exit(closers, 1)
+	}
+	exit(closers, 0)
+}
+
+// exit closes closers in reverse order, which finishes pending webhooks and
+// writes a -store-file a final time, and exits with code. Use it instead of
+// os.Exit, which skips deferred calls.
+func exit(closers []io.Closer, code int) {
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR %v\n", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+	}
+	os.Exit(code)
+}
+
+// newScratchFileStore creates an empty FileStore in a temporary directory,
+// which the returned function removes.
+func newScratchFileStore() (store.Repository, func(), error) {
+	dir, err := os.MkdirTemp("", "payment-sim-bench")
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := store.NewFileStore(filepath.Join(dir, "bench.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return s, func() { os.RemoveAll(dir) }, nil
+}
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
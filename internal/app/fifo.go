@@ -0,0 +1,41 @@
+package app
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// InputOpener opens the next input stream, blocking until one is available.
+type InputOpener func() (io.ReadCloser, error)
+
+// OpenFIFO returns an InputOpener for a named pipe. Opening blocks until a
+// writer connects.
+func OpenFIFO(path string) InputOpener {
+	return func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+}
+
+// RunReopening executes commands from successive inputs, reopening after
+// every EOF instead of exiting, so that writers to a FIFO may come and go.
+// Session state (variables, RETRY, stats) carries over between inputs. It
+// returns when EXIT is received or an input cannot be opened or read.
+func (r *Runner) RunReopening(open InputOpener) error {
+	for {
+		input, err := open()
+		if err != nil {
+			return err
+		}
+		r.line = 0
+		exited, err := r.process(bufio.NewScanner(input))
+		input.Close()
+		if err != nil {
+			return err
+		}
+		if exited {
+			r.finish()
+			return nil
+		}
+	}
+}
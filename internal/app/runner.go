@@ -5,17 +5,78 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"payment-sim/internal/domain"
 	"payment-sim/internal/parser"
 	"payment-sim/internal/service"
 )
 
+// lineContinuation at the end of a line joins it with the next one.
+const lineContinuation = `\`
+
 // Runner handles the main read-parse-execute-output loop.
 type Runner struct {
 	processor *service.Processor
 	reader    *bufio.Scanner
 	writer    io.Writer
+	formatter service.Formatter
+
+	// quietReads suppresses successful output of read-only commands.
+	quietReads bool
+	// echo prints each parsed command before executing it.
+	echo bool
+	// lineSep terminates each result and error line.
+	lineSep string
+
+	// lastCommand is the most recently executed command, re-run by RETRY.
+	lastCommand *parser.Command
+
+	// vars holds the payment IDs captured by "SET <name> = <command>",
+	// substituted for "$name" tokens in later lines.
+	vars map[string]string
+
+	// timing appends each command's duration and prints a summary at the end.
+	timing  bool
+	clock   domain.Clock
+	timings map[string]*commandTiming
+
+	// stats counts command outcomes for STATS; printStats also prints them
+	// at the end of the run.
+	stats      sessionStats
+	printStats bool
+
+	// strict stops the run at the first parse or execution error, which
+	// Run returns instead of printing.
+	strict bool
+
+	// txn is the open BEGIN block, nil outside a transaction.
+	txn *transaction
+
+	// line is the number of the last line read from the top-level input,
+	// used to prefix errors outside INCLUDEd files.
+	line int
+
+	// includes is the stack of script files being run by INCLUDE, innermost
+	// last, used to prefix errors and to detect include cycles.
+	includes []*includeFrame
+}
+
+// includeFrame tracks the position within one INCLUDEd file.
+type includeFrame struct {
+	path string // as given to INCLUDE
+	abs  string // absolute path, for cycle detection
+	line int
+}
+
+// commandTiming accumulates execution time for one command name.
+type commandTiming struct {
+	count int
+	total time.Duration
 }
 
 // NewRunner creates a new application runner.
@@ -24,48 +85,443 @@ func NewRunner(processor *service.Processor, input io.Reader, output io.Writer)
 		processor: processor,
 		reader:    bufio.NewScanner(input),
 		writer:    output,
+		formatter: service.TextFormatter{},
+		clock:     domain.SystemClock{},
+		timings:   make(map[string]*commandTiming),
+		vars:      make(map[string]string),
+		lineSep:   "\n",
 	}
 }
 
+// SetFormatter sets how results and errors are rendered (default: text).
+func (r *Runner) SetFormatter(formatter service.Formatter) {
+	r.formatter = formatter
+}
+
+// SetQuietReads enables or disables suppression of successful output from
+// read-only commands (STATUS, LIST, AUDIT). Errors are always printed.
+func (r *Runner) SetQuietReads(quiet bool) {
+	r.quietReads = quiet
+}
+
+// SetEcho enables printing each parsed command, e.g.
+// "> CREATE [P001 100.00 USD M001]", before its result.
+func (r *Runner) SetEcho(enabled bool) {
+	r.echo = enabled
+}
+
+// SetLineSeparator sets the terminator written after each result and error
+// line (default "\n"), e.g. "\x00" for NUL-delimited output.
+func (r *Runner) SetLineSeparator(sep string) {
+	r.lineSep = sep
+}
+
+// SetTiming enables per-command duration trailers and an end-of-run summary.
+func (r *Runner) SetTiming(enabled bool) {
+	r.timing = enabled
+}
+
+// SetPrintStats enables printing the session stats when the run ends.
+func (r *Runner) SetPrintStats(enabled bool) {
+	r.printStats = enabled
+}
+
+// SetStrict enables stopping at the first parse or execution error; Run
+// then returns that error instead of printing it and continuing.
+func (r *Runner) SetStrict(enabled bool) {
+	r.strict = enabled
+}
+
+// SetClock replaces the clock used for timing. Intended for tests.
+func (r *Runner) SetClock(clock domain.Clock) {
+	r.clock = clock
+}
+
 // Run executes the main loop until EXIT is received or EOF is reached.
 func (r *Runner) Run() error {
-	for r.reader.Scan() {
-		line := strings.TrimSpace(r.reader.Text())
+	r.line = 0
+	if _, err := r.process(r.reader); err != nil {
+		return err
+	}
+	r.finish()
+	return nil
+}
+
+// RunFiles executes the script files at paths in order against the shared
+// processor, as if they were concatenated. An EXIT in any file stops the
+// remaining files. With more than one file, errors are prefixed "file:line:"
+// as for INCLUDE.
+func (r *Runner) RunFiles(paths []string) error {
+	for _, path := range paths {
+		exited, err := r.runFile(path, len(paths) > 1)
+		if err != nil {
+			return err
+		}
+		if exited {
+			break
+		}
+	}
+	r.finish()
+	return nil
+}
+
+// runFile executes the commands in the file at path until EXIT or EOF,
+// locating errors by file name when named is set.
+func (r *Runner) runFile(path string, named bool) (exited bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("cannot open file: %v", err)
+	}
+	defer file.Close()
+
+	r.line = 0
+	if named {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		r.includes = append(r.includes, &includeFrame{path: path, abs: abs})
+		defer func() { r.includes = r.includes[:len(r.includes)-1] }()
+	}
+	return r.process(bufio.NewScanner(file))
+}
+
+// process executes commands from reader until EXIT or EOF. It reports
+// whether EXIT was received.
+func (r *Runner) process(reader *bufio.Scanner) (exited bool, err error) {
+	var continued strings.Builder
+	for reader.Scan() {
+		if frame := r.currentInclude(); frame != nil {
+			frame.line++
+		} else {
+			r.line++
+		}
+		line := strings.TrimSpace(reader.Text())
+
+		// A trailing backslash continues the command on the next line
+		if strings.HasSuffix(line, lineContinuation) {
+			continued.WriteString(strings.TrimSuffix(line, lineContinuation) + " ")
+			continue
+		}
+		if continued.Len() > 0 {
+			line = strings.TrimSpace(continued.String() + line)
+			continued.Reset()
+		}
 
 		// Skip empty lines
 		if line == "" {
 			continue
 		}
 
+		// Substitute variables and split off a SET assignment
+		line, err := substituteVars(line, r.vars)
+		if err != nil {
+			r.stats.record(invalidCommand, err)
+			if r.txn != nil {
+				r.txn.failed = true
+			}
+			if err := r.reportError(err); err != nil {
+				return false, err
+			}
+			continue
+		}
+		varName, command, assign := splitAssignment(line)
+		if assign {
+			line = command
+		}
+
 		// Parse the command
 		cmd, err := parser.Parse(line)
 		if err != nil {
-			fmt.Fprintf(r.writer, "ERROR %s\n", err)
+			r.stats.record(invalidCommand, err)
+			if r.txn != nil {
+				r.txn.failed = true
+			}
+			if err := r.reportError(err); err != nil {
+				return false, err
+			}
 			continue
 		}
 
+		// Show how the line was tokenized
+		if r.echo {
+			r.writeLine(r.formatter.Format(&service.Result{
+				Command: cmd.Name,
+				Outcome: "echo",
+				Message: fmt.Sprintf("> %s %v", cmd.Name, cmd.Args),
+			}))
+		}
+
 		// Handle EXIT command
 		if cmd.Name == "EXIT" {
-			return nil
+			if r.txn != nil {
+				if err := r.reportError(r.abandon()); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
 		}
 
-		// Execute the command
-		result, err := r.processor.Execute(cmd)
-		if err != nil {
-			fmt.Fprintf(r.writer, "ERROR %s\n", err)
+		// BEGIN, COMMIT and ROLLBACK manage a transaction block; other
+		// commands inside an open block are buffered until COMMIT
+		if isTransactionControl(cmd.Name) || r.txn != nil {
+			err := r.transact(cmd, assign)
+			if err != nil || isTransactionControl(cmd.Name) {
+				r.stats.record(cmd.Name, err)
+			}
+			if err != nil {
+				if err := r.reportError(err); err != nil {
+					return false, err
+				}
+			}
 			continue
 		}
 
-		// Print result if non-empty
-		if result != "" {
-			fmt.Fprintln(r.writer, result)
+		// STATS reports the session counters kept by the Runner, followed
+		// by the processor's ledger stats
+		if cmd.Name == "STATS" {
+			r.writeStats()
+			r.writeLedgerStats(cmd)
+			continue
+		}
+
+		// INCLUDE runs another script file against the current store
+		if cmd.Name == "INCLUDE" {
+			exited, err := r.include(cmd.Args[0])
+			r.stats.record(cmd.Name, err)
+			if err != nil {
+				// In strict mode errors from inside the file are already located
+				if r.strict {
+					return false, err
+				}
+				r.writeError(err)
+			}
+			if exited {
+				return true, nil
+			}
+			continue
+		}
+
+		// RETRY re-executes the previous command
+		if cmd.Name == "RETRY" {
+			if r.lastCommand == nil {
+				err := fmt.Errorf("RETRY: no previous command")
+				r.stats.record(cmd.Name, err)
+				if err := r.reportError(err); err != nil {
+					return false, err
+				}
+				continue
+			}
+			cmd = r.lastCommand
+		}
+		r.lastCommand = cmd
+
+		result, err := r.execute(cmd)
+		if err != nil && r.strict {
+			return false, err
+		}
+		if assign && err == nil {
+			if err := r.assign(varName, result); err != nil {
+				if err := r.reportError(err); err != nil {
+					return false, err
+				}
+			}
 		}
 	}
 
 	// Check for scanner errors
-	if err := r.reader.Err(); err != nil {
-		return fmt.Errorf("error reading input: %w", err)
+	if err := reader.Err(); err != nil {
+		return false, fmt.Errorf("error reading input: %w", err)
+	}
+
+	// Input ended in the middle of a continued command
+	if continued.Len() > 0 {
+		err := fmt.Errorf("dangling line continuation at end of input: %s", strings.TrimSpace(continued.String()))
+		r.stats.record(invalidCommand, err)
+		if err := r.reportError(err); err != nil {
+			return false, err
+		}
+	}
+
+	// Input ended inside a BEGIN block opened in this input
+	if r.txn != nil && r.txn.depth == len(r.includes) {
+		if err := r.reportError(r.abandon()); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// finish prints the end-of-run summaries that are enabled.
+func (r *Runner) finish() {
+	r.writeTimingSummary()
+	if r.printStats {
+		r.writeStats()
+	}
+}
+
+// writeStats prints the session stats through the formatter.
+func (r *Runner) writeStats() {
+	result := &service.Result{Command: "STATS", Outcome: "reported", Message: r.stats.String()}
+	r.writeLine(r.formatter.Format(result))
+}
+
+// writeLedgerStats prints the processor's STATS report. It is not counted in
+// the session stats.
+func (r *Runner) writeLedgerStats(cmd *parser.Command) {
+	result, err := r.processor.ExecuteResult(cmd)
+	if err != nil {
+		r.writeError(err)
+		return
+	}
+	r.writeLine(r.formatter.Format(result))
+}
+
+// assign stores the payment ID of a SET command's result in the named variable.
+func (r *Runner) assign(name string, result *service.Result) error {
+	if result.PaymentID == "" {
+		return fmt.Errorf("SET %s: %s did not produce a payment ID", name, result.Command)
+	}
+	r.vars[name] = result.PaymentID
+	return nil
+}
+
+// execute runs a single command, writes its result or error, and returns
+// them.
+func (r *Runner) execute(cmd *parser.Command) (*service.Result, error) {
+	start := r.clock.Now()
+	result, err := r.processor.ExecuteResult(cmd)
+	r.stats.record(cmd.Name, err)
+	var elapsed time.Duration
+	if r.timing {
+		elapsed = r.clock.Now().Sub(start)
+		r.recordTiming(cmd.Name, elapsed)
+	}
+
+	if err != nil {
+		if r.strict {
+			return nil, r.locate(err)
+		}
+		r.writeLine(r.formatter.FormatError(&service.TimedError{Err: r.locate(err), Duration: elapsed}))
+		return nil, err
+	}
+	result.Duration = elapsed
+
+	// Suppress successful read-only output in quiet mode
+	if r.quietReads && parser.IsReadOnly(cmd.Name) {
+		return result, nil
+	}
+
+	// Print result if non-empty
+	if text := r.formatter.Format(result); text != "" {
+		r.writeLine(text)
+	}
+	return result, nil
+}
+
+// include executes the lines of the script at path, reporting errors with
+// the file name and line number. An EXIT in the script ends the session.
+func (r *Runner) include(path string) (exited bool, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("INCLUDE %s: %v", path, err)
+	}
+	for _, frame := range r.includes {
+		if frame.abs == abs {
+			chain := make([]string, 0, len(r.includes)+1)
+			for _, f := range r.includes {
+				chain = append(chain, f.path)
+			}
+			chain = append(chain, path)
+			return false, fmt.Errorf("INCLUDE cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("INCLUDE: cannot open file: %v", err)
+	}
+	defer file.Close()
+
+	r.includes = append(r.includes, &includeFrame{path: path, abs: abs})
+	defer func() { r.includes = r.includes[:len(r.includes)-1] }()
+	return r.process(bufio.NewScanner(file))
+}
+
+// currentInclude returns the innermost INCLUDEd file, or nil at top level.
+func (r *Runner) currentInclude() *includeFrame {
+	if len(r.includes) == 0 {
+		return nil
+	}
+	return r.includes[len(r.includes)-1]
+}
+
+// locate prefixes err with the current INCLUDE file and line, or with the
+// line number of the top-level input.
+func (r *Runner) locate(err error) error {
+	if frame := r.currentInclude(); frame != nil {
+		return fmt.Errorf("%s:%d: %w", frame.path, frame.line, err)
+	}
+	if r.line > 0 {
+		return fmt.Errorf("line %d: %w", r.line, err)
 	}
+	return err
+}
 
+// reportError writes an error line and returns nil, or in strict mode
+// returns the located error without writing it.
+func (r *Runner) reportError(err error) error {
+	if r.strict {
+		return r.locate(err)
+	}
+	r.writeError(err)
 	return nil
 }
+
+// writeError writes an error line, located within any INCLUDEd file.
+func (r *Runner) writeError(err error) {
+	r.writeLine(r.formatter.FormatError(r.locate(err)))
+}
+
+// writeLine writes a result or error line followed by the line separator.
+func (r *Runner) writeLine(text string) {
+	io.WriteString(r.writer, text+r.lineSep)
+}
+
+// recordTiming adds one execution of the named command to the totals.
+func (r *Runner) recordTiming(name string, elapsed time.Duration) {
+	t, ok := r.timings[name]
+	if !ok {
+		t = &commandTiming{}
+		r.timings[name] = t
+	}
+	t.count++
+	t.total += elapsed
+}
+
+// writeTimingSummary prints the total time and per-command averages through
+// the formatter when timing is enabled.
+func (r *Runner) writeTimingSummary() {
+	if !r.timing {
+		return
+	}
+
+	names := make([]string, 0, len(r.timings))
+	count := 0
+	var total time.Duration
+	for name, t := range r.timings {
+		names = append(names, name)
+		count += t.count
+		total += t.total
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Timing: %d commands in %s", count, total))
+	for _, name := range names {
+		t := r.timings[name]
+		sb.WriteString(fmt.Sprintf("\n  %s: count=%d avg=%s", name, t.count, t.total/time.Duration(t.count)))
+	}
+	result := &service.Result{Command: "TIMING", Outcome: "reported", Message: sb.String()}
+	r.writeLine(r.formatter.Format(result))
+}
@@ -2,9 +2,17 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"payment-sim/internal/domain"
 	"payment-sim/internal/service"
 	"payment-sim/internal/store"
 )
@@ -111,6 +119,38 @@ EXIT
 	}
 }
 
+func TestRunner_Strict(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+CAPTURE P001
+STATUS P001
+`)
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	runner.SetStrict(true)
+	err := runner.Run()
+	if err == nil || !strings.Contains(err.Error(), "INITIATED") {
+		t.Fatalf("Run() error = %v, want the CAPTURE error", err)
+	}
+	if strings.Contains(output.String(), "ERROR") || strings.Contains(output.String(), "state=") {
+		t.Errorf("Output = %q, want only the CREATE result", output.String())
+	}
+}
+
+func TestRunner_StrictParseError(t *testing.T) {
+	input := strings.NewReader("BOGUS P001\nCREATE P001 100.00 USD M001\n")
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	runner.SetStrict(true)
+	if err := runner.Run(); err == nil {
+		t.Fatal("Run() error = nil, want the parse error")
+	}
+	if output.Len() != 0 {
+		t.Errorf("Output = %q, want nothing after the parse error", output.String())
+	}
+}
+
 func TestRunner_EOF(t *testing.T) {
 	// No EXIT command, just EOF
 	input := strings.NewReader(`CREATE P001 100.00 USD M001
@@ -172,3 +212,637 @@ func TestRunner_ScannerError(t *testing.T) {
 		t.Errorf("Expected mock read error, got: %v", err)
 	}
 }
+
+func TestRunner_QuietReads(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+AUDIT P001
+AUDIT NONEXISTENT
+AUTHORIZE P001
+EXIT
+`)
+	var output bytes.Buffer
+
+	memStore := store.NewMemoryStore()
+	processor := service.NewProcessor(memStore, nil)
+	runner := NewRunner(processor, input, &output)
+	runner.SetQuietReads(true)
+
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	result := output.String()
+	if strings.Contains(result, "AUDIT RECEIVED") {
+		t.Errorf("AUDIT success should be suppressed: %v", result)
+	}
+	if !strings.Contains(result, "ERROR line 3: payment NONEXISTENT not found") {
+		t.Errorf("AUDIT error should still be printed: %v", result)
+	}
+	if !strings.Contains(result, "created") || !strings.Contains(result, "authorized") {
+		t.Errorf("Mutation output should still be printed: %v", result)
+	}
+}
+
+func TestRunner_QuietReadsKeepsExports(t *testing.T) {
+	input := strings.NewReader("CREATE P001 100.00 USD M001\nEXPORT CSV\nAUDIT-EXPORT P001\n")
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	runner.SetQuietReads(true)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(output.String(), "id,amount,currency") {
+		t.Errorf("EXPORT output should not be suppressed: %v", output.String())
+	}
+	if !strings.Contains(output.String(), "Audit trail for payment P001") {
+		t.Errorf("AUDIT-EXPORT output should not be suppressed: %v", output.String())
+	}
+}
+
+func TestRunner_JSONFormat(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+CAPTURE P001
+EXIT
+`)
+	var output bytes.Buffer
+
+	memStore := store.NewMemoryStore()
+	processor := service.NewProcessor(memStore, nil)
+	runner := NewRunner(processor, input, &output)
+	runner.SetFormatter(service.JSONFormatter{})
+
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Output lines = %d, want 2: %v", len(lines), output.String())
+	}
+	if !strings.Contains(lines[0], `"ok":true`) || !strings.Contains(lines[0], `"payment_id":"P001"`) ||
+		!strings.Contains(lines[0], `"state":"INITIATED"`) {
+		t.Errorf("CREATE line = %v", lines[0])
+	}
+	if !strings.Contains(lines[1], `"ok":false`) || !strings.Contains(lines[1], `"error":"line 2: invalid transition`) {
+		t.Errorf("Error line = %v", lines[1])
+	}
+}
+
+func TestRunner_ColorFormat(t *testing.T) {
+	script := `CREATE P001 100.00 USD M001
+STATUS P001
+CAPTURE P001
+`
+	run := func(formatter service.Formatter) string {
+		var output bytes.Buffer
+		runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), strings.NewReader(script), &output)
+		runner.SetFormatter(formatter)
+		if err := runner.Run(); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return output.String()
+	}
+
+	// -color=always
+	colored := run(service.ColorFormatter{})
+	if !strings.Contains(colored, "\x1b[32mPayment P001 created") {
+		t.Errorf("success line not green: %q", colored)
+	}
+	if !strings.Contains(colored, "state=\x1b[36mINITIATED\x1b[0m") {
+		t.Errorf("state not color-coded: %q", colored)
+	}
+	if !strings.Contains(colored, "\x1b[31mERROR ") {
+		t.Errorf("error line not red: %q", colored)
+	}
+
+	// -color=never
+	if plain := run(service.TextFormatter{}); strings.Contains(plain, "\x1b[") {
+		t.Errorf("plain output contains color codes: %q", plain)
+	}
+}
+
+func TestRunner_Stats(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+AUTHORIZE P001
+AUTHORIZE P001
+BOGUS P001
+CAPTURE P404
+STATS
+EXIT
+`)
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	runner.SetPrintStats(true)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := `Session: 5 commands, 2 succeeded, 3 errored
+  AUTHORIZE: succeeded=1 errored=1
+  CAPTURE: succeeded=0 errored=1
+  CREATE: succeeded=1 errored=0
+  INVALID: succeeded=0 errored=1`
+	result := output.String()
+	if !strings.Contains(result, want) {
+		t.Errorf("Output missing STATS breakdown:\n%v\nwant\n%v", result, want)
+	}
+	ledger := `Stats: 1 payment(s)
+  AUTHORIZED: 1
+  USD: authorized=100.00 captured=0.00 settled=0.00`
+	if !strings.Contains(result, want+"\n"+ledger) {
+		t.Errorf("Output missing ledger stats after session stats:\n%v", result)
+	}
+	// Once for STATS, once more at exit with -stats
+	if n := strings.Count(result, "Session: "); n != 2 {
+		t.Errorf("stats printed %d times, want 2", n)
+	}
+}
+
+func TestRunner_Echo(t *testing.T) {
+	input := strings.NewReader(`  CREATE   P001 100.00 USD M001   # first payment
+STATUS P001
+`)
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	runner.SetEcho(true)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Output lines = %d, want 4: %v", len(lines), output.String())
+	}
+	if lines[0] != "> CREATE [P001 100.00 USD M001]" {
+		t.Errorf("echo = %q, want normalized command without comment", lines[0])
+	}
+	if !strings.Contains(lines[1], "created") || lines[2] != "> STATUS [P001]" {
+		t.Errorf("echo should precede each result: %v", lines)
+	}
+}
+
+func TestRunner_SetVariable(t *testing.T) {
+	// Keywords are case-insensitive, SET included
+	for _, keyword := range []string{"SET", "set"} {
+		input := strings.NewReader(keyword + ` v = CREATE AUTO 100.00 USD M001
+AUTHORIZE $v
+STATUS $v
+`)
+		var output bytes.Buffer
+
+		memStore := store.NewMemoryStore()
+		runner := NewRunner(service.NewProcessor(memStore, nil), input, &output)
+		if err := runner.Run(); err != nil {
+			t.Fatalf("%s: Run() error = %v", keyword, err)
+		}
+
+		payment, err := memStore.Get("PAY-000001")
+		if err != nil {
+			t.Fatalf("%s: AUTO payment not created: %v", keyword, output.String())
+		}
+		if payment.State != domain.StateAuthorized {
+			t.Errorf("%s: state = %s, want AUTHORIZED via $v: %v", keyword, payment.State, output.String())
+		}
+	}
+}
+
+func TestRunner_UndefinedVariable(t *testing.T) {
+	input := strings.NewReader(`AUTHORIZE $missing
+CREATE P001 100.00 USD M001
+`)
+	var output bytes.Buffer
+
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 || lines[0] != "ERROR line 1: undefined variable: $missing" {
+		t.Errorf("Output = %v, want undefined-variable error then CREATE", lines)
+	}
+}
+
+func TestRunner_LineSeparator(t *testing.T) {
+	for _, tt := range []struct {
+		sep  string
+		want string
+	}{
+		{"", "Payment P001 created: 100.0 USD\nERROR line 2: payment P002 not found\n"},
+		{"\x00", "Payment P001 created: 100.0 USD\x00ERROR line 2: payment P002 not found\x00"},
+	} {
+		input := strings.NewReader("CREATE P001 100.00 USD M001\nAUTHORIZE P002\n")
+		var output bytes.Buffer
+
+		runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+		if tt.sep != "" {
+			runner.SetLineSeparator(tt.sep)
+		}
+		if err := runner.Run(); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if output.String() != tt.want {
+			t.Errorf("separator %q: output = %q, want %q", tt.sep, output.String(), tt.want)
+		}
+	}
+}
+
+func TestRunner_RunReopening(t *testing.T) {
+	bursts := make(chan io.ReadCloser, 2)
+	open := func() (io.ReadCloser, error) {
+		return <-bursts, nil
+	}
+
+	var output bytes.Buffer
+	memStore := store.NewMemoryStore()
+	runner := NewRunner(service.NewProcessor(memStore, nil), nil, &output)
+	done := make(chan error)
+	go func() { done <- runner.RunReopening(open) }()
+
+	// Two writers, each sending one burst and then EOF
+	for _, burst := range []string{
+		"CREATE P001 100.00 USD M001\n",
+		"AUTHORIZE P001\nEXIT\n",
+	} {
+		r, w := io.Pipe()
+		bursts <- r
+		go func(burst string) {
+			io.WriteString(w, burst)
+			w.Close()
+		}(burst)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RunReopening() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunReopening() did not return after EXIT")
+	}
+	payment, err := memStore.Get("P001")
+	if err != nil || payment.State != domain.StateAuthorized {
+		t.Errorf("both bursts should be processed: %v", output.String())
+	}
+}
+
+func TestRunner_LineContinuation(t *testing.T) {
+	input := strings.NewReader("CREATE P001 100.00 \\\n   USD M001\nAUTHORIZE P001 \\\n")
+	var output bytes.Buffer
+
+	memStore := store.NewMemoryStore()
+	runner := NewRunner(service.NewProcessor(memStore, nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Output lines = %d, want 2: %v", len(lines), output.String())
+	}
+	if lines[0] != "Payment P001 created: 100.0 USD" {
+		t.Errorf("continued CREATE = %q", lines[0])
+	}
+	if lines[1] != "ERROR line 3: dangling line continuation at end of input: AUTHORIZE P001" {
+		t.Errorf("dangling continuation = %q", lines[1])
+	}
+	if payment, _ := memStore.Get("P001"); payment.State != domain.StateInitiated {
+		t.Errorf("dangling AUTHORIZE should not run, state = %s", payment.State)
+	}
+}
+
+// flakyStore fails the first failures calls to Save, simulating a transient
+// storage error.
+type flakyStore struct {
+	*store.MemoryStore
+	failures int
+}
+
+func (s *flakyStore) Save(payment *domain.Payment) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("storage temporarily unavailable")
+	}
+	return s.MemoryStore.Save(payment)
+}
+
+func TestRunner_Include(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "setup.txt")
+	script := "CREATE P001 100.00 USD M001\nCREATE P002 50.00 USD M001\nAUTHORIZE P999\n"
+	if err := os.WriteFile(sub, []byte(script), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	input := strings.NewReader("INCLUDE " + sub + "\nAUTHORIZE P002\nSTATUS P001\n")
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Output lines = %d, want 5: %v", len(lines), output.String())
+	}
+	if want := "ERROR " + sub + ":3: payment P999 not found"; lines[2] != want {
+		t.Errorf("included error = %q, want %q", lines[2], want)
+	}
+	if lines[3] != "Payment P002 authorized" {
+		t.Errorf("after INCLUDE = %q, want P002 authorized", lines[3])
+	}
+	if !strings.HasPrefix(lines[4], "Payment P001: state=INITIATED") {
+		t.Errorf("STATUS = %q, want P001 created by the included script", lines[4])
+	}
+}
+
+func TestRunner_RunFiles(t *testing.T) {
+	dir := t.TempDir()
+	scripts := []string{
+		"CREATE P001 100.00 USD M001\n",
+		"AUTHORIZE P001\nEXIT\n",
+		"STATUS P001\n",
+	}
+	paths := make([]string, len(scripts))
+	for i, script := range scripts {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("part%d.txt", i+1))
+		if err := os.WriteFile(paths[i], []byte(script), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), nil, &output)
+	if err := runner.RunFiles(paths); err != nil {
+		t.Fatalf("RunFiles() error = %v", err)
+	}
+
+	want := "Payment P001 created: 100.0 USD\nPayment P001 authorized\n"
+	if output.String() != want {
+		t.Errorf("Output = %q, want %q (EXIT skips the third file)", output.String(), want)
+	}
+}
+
+func TestRunner_RunFilesLocatesErrors(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("CREATE P001 100.00 USD M001\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(second, []byte("\nCAPTURE P001\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), nil, &output)
+	if err := runner.RunFiles([]string{first, second}); err != nil {
+		t.Fatalf("RunFiles() error = %v", err)
+	}
+	if want := "ERROR " + second + ":2: invalid transition"; !strings.Contains(output.String(), want) {
+		t.Errorf("Output = %q, want %q", output.String(), want)
+	}
+
+	output.Reset()
+	runner = NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), nil, &output)
+	if err := runner.RunFiles([]string{second}); err != nil {
+		t.Fatalf("RunFiles() error = %v", err)
+	}
+	if want := "ERROR line 2: payment P001 not found"; !strings.Contains(output.String(), want) {
+		t.Errorf("single file output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestRunner_RunFilesMissing(t *testing.T) {
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), nil, &output)
+	err := runner.RunFiles([]string{filepath.Join(t.TempDir(), "missing.txt")})
+	if err == nil || !strings.Contains(err.Error(), "cannot open file") {
+		t.Errorf("RunFiles() error = %v, want cannot open file", err)
+	}
+}
+
+func TestRunner_TransactionCommit(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+BEGIN
+AUTHORIZE P001
+CAPTURE P001
+COMMIT
+STATUS P001
+`)
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	want := []string{
+		"Payment P001 created: 100.0 USD",
+		"Payment P001 authorized",
+		"Payment P001 captured",
+		"COMMIT: 2 command(s) applied",
+	}
+	if len(lines) != 5 || strings.Join(lines[:4], "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Output = %v, want %v then STATUS", lines, want)
+	}
+	if !strings.Contains(lines[4], "state=CAPTURED") {
+		t.Errorf("STATUS = %q, want CAPTURED", lines[4])
+	}
+}
+
+func TestRunner_TransactionRollsBackOnFailure(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+BEGIN
+AUTHORIZE P001
+REFUND P001
+COMMIT
+STATUS P001
+`)
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Output lines = %d, want 3: %v", len(lines), output.String())
+	}
+	if !strings.HasPrefix(lines[1], "ERROR line 5: COMMIT: command 2 (REFUND P001) failed") {
+		t.Errorf("COMMIT = %q, want REFUND failure", lines[1])
+	}
+	if !strings.Contains(lines[2], "state=INITIATED") {
+		t.Errorf("STATUS = %q, want AUTHORIZE rolled back", lines[2])
+	}
+}
+
+func TestRunner_TransactionErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"nested BEGIN", "BEGIN\nBEGIN\nCOMMIT\n", "ERROR line 2: BEGIN: a transaction is already open"},
+		{"COMMIT without BEGIN", "COMMIT\n", "ERROR line 1: COMMIT: no open transaction"},
+		{"ROLLBACK without BEGIN", "ROLLBACK\n", "ERROR line 1: ROLLBACK: no open transaction"},
+		{"parse error in block", "BEGIN\nBOGUS\nCREATE P001 100.00 USD M001\nCOMMIT\n", "ERROR line 4: COMMIT: transaction had errors"},
+		{"INCLUDE in block", "BEGIN\nINCLUDE x.txt\n", "ERROR line 2: INCLUDE is not supported inside a transaction"},
+		{"unterminated block", "BEGIN\nCREATE P001 100.00 USD M001\n", "ERROR line 2: transaction not committed, 1 command(s) discarded"},
+		{"ROLLBACK", "BEGIN\nCREATE P001 100.00 USD M001\nROLLBACK\nSTATUS P001\n", "ROLLBACK: 1 command(s) discarded\nERROR line 4: payment P001 not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), strings.NewReader(tt.input), &output)
+			if err := runner.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !strings.Contains(output.String(), tt.want) {
+				t.Errorf("Output = %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunner_IncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	self := filepath.Join(dir, "self.txt")
+	if err := os.WriteFile(self, []byte("CREATE P001 100.00 USD M001\nINCLUDE "+self+"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	input := strings.NewReader("INCLUDE " + self + "\nLIST\n")
+	var output bytes.Buffer
+	runner := NewRunner(service.NewProcessor(store.NewMemoryStore(), nil), input, &output)
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := "ERROR " + self + ":2: INCLUDE cycle detected: " + self + " -> " + self
+	if !strings.Contains(output.String(), want) {
+		t.Errorf("Output = %q, want %q", output.String(), want)
+	}
+	if !strings.Contains(output.String(), "P001: state=INITIATED") {
+		t.Errorf("Output = %q, want the session to continue after the cycle", output.String())
+	}
+}
+
+func TestRunner_Retry(t *testing.T) {
+	input := strings.NewReader(`RETRY
+CREATE P001 100.00 USD M001
+RETRY
+STATUS P001
+EXIT
+`)
+	var output bytes.Buffer
+
+	flaky := &flakyStore{MemoryStore: store.NewMemoryStore(), failures: 1}
+	processor := service.NewProcessor(flaky, nil)
+	runner := NewRunner(processor, input, &output)
+
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Output lines = %d, want 4: %v", len(lines), output.String())
+	}
+	if !strings.Contains(lines[0], "ERROR line 1: RETRY: no previous command") {
+		t.Errorf("RETRY without history = %q, want error", lines[0])
+	}
+	if !strings.Contains(lines[1], "ERROR line 2: failed to save payment") {
+		t.Errorf("CREATE = %q, want transient save error", lines[1])
+	}
+	if !strings.Contains(lines[2], "Payment P001 created") {
+		t.Errorf("RETRY = %q, want re-executed CREATE", lines[2])
+	}
+	if !flaky.Exists("P001") {
+		t.Error("RETRY did not re-execute CREATE")
+	}
+}
+
+// stepClock advances by a fixed step on every call to Now.
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func TestRunner_Timing(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+CREATE P002 50.00 USD M001
+AUTHORIZE P001
+AUTHORIZE P404
+`)
+	var output bytes.Buffer
+
+	processor := service.NewProcessor(store.NewMemoryStore(), nil)
+	runner := NewRunner(processor, input, &output)
+	runner.SetTiming(true)
+	runner.SetClock(&stepClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), step: 2 * time.Millisecond})
+
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := `Payment P001 created: 100.0 USD (2ms)
+Payment P002 created: 50.0 USD (2ms)
+Payment P001 authorized (2ms)
+ERROR line 4: payment P404 not found (2ms)
+Timing: 4 commands in 8ms
+  AUTHORIZE: count=2 avg=2ms
+  CREATE: count=2 avg=2ms
+`
+	if output.String() != want {
+		t.Errorf("Output =\n%v\nwant\n%v", output.String(), want)
+	}
+}
+
+func TestRunner_JSONTimingEchoLines(t *testing.T) {
+	input := strings.NewReader(`CREATE P001 100.00 USD M001
+AUTHORIZE P404
+`)
+	var output bytes.Buffer
+
+	processor := service.NewProcessor(store.NewMemoryStore(), nil)
+	runner := NewRunner(processor, input, &output)
+	runner.SetFormatter(service.JSONFormatter{})
+	runner.SetTiming(true)
+	runner.SetEcho(true)
+	runner.SetClock(&stepClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), step: 2 * time.Millisecond})
+
+	if err := runner.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want echo+result for each command and a summary:\n%s", len(lines), output.String())
+	}
+	var parsed []map[string]interface{}
+	for _, line := range lines {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		parsed = append(parsed, obj)
+	}
+	if parsed[0]["message"] != "> CREATE [P001 100.00 USD M001]" {
+		t.Errorf("echo = %v", parsed[0])
+	}
+	if parsed[1]["duration_ns"] != float64(2*time.Millisecond) || parsed[3]["duration_ns"] != float64(2*time.Millisecond) {
+		t.Errorf("result and error should carry duration_ns: %v, %v", parsed[1], parsed[3])
+	}
+	if parsed[4]["command"] != "TIMING" {
+		t.Errorf("summary = %v, want TIMING object", parsed[4])
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// RunnerFactory builds a Runner for a single connection's input and output.
+type RunnerFactory func(input io.Reader, output io.Writer) *Runner
+
+// Serve accepts connections on the listener and runs an independent Runner for
+// each one, writing responses back on the same connection. All runners share
+// the processor (and therefore the store) captured by newRunner. EXIT closes
+// only the issuing connection.
+// Serve blocks until the listener is closed and all connections have finished.
+func Serve(listener net.Listener, newRunner RunnerFactory) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+			// Per-connection read errors only end that connection
+			_ = newRunner(conn, conn).Run()
+		}(conn)
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"payment-sim/internal/service"
+	"payment-sim/internal/store"
+)
+
+func TestServe_UnixSocket(t *testing.T) {
+	// Keep the socket path short; unix socket paths are length-limited
+	dir, err := os.MkdirTemp("", "pay")
+	if err != nil {
+		t.Fatalf("MkdirTemp() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sockPath := filepath.Join(dir, "pay.sock")
+
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	processor := service.NewProcessor(store.NewMemoryStore(), nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(listener, func(input io.Reader, output io.Writer) *Runner {
+			return NewRunner(processor, input, output)
+		})
+	}()
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "CREATE P001 100.00 USD M001")
+	fmt.Fprintln(conn, "STATUS P001")
+
+	reader := bufio.NewReader(conn)
+	line1, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read CREATE response: %v", err)
+	}
+	line2, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read STATUS response: %v", err)
+	}
+
+	if !strings.Contains(line1, "Payment P001 created") {
+		t.Errorf("CREATE response = %q", line1)
+	}
+	if !strings.Contains(line2, "state=INITIATED") {
+		t.Errorf("STATUS response = %q", line2)
+	}
+
+	fmt.Fprintln(conn, "EXIT")
+	listener.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+}
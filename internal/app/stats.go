@@ -0,0 +1,60 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// invalidCommand is the stats key for lines that failed to parse.
+const invalidCommand = "INVALID"
+
+// sessionStats counts successful and failed commands for one Runner session.
+type sessionStats struct {
+	byCommand map[string]*commandStats
+}
+
+// commandStats counts the outcomes of one command name.
+type commandStats struct {
+	succeeded int
+	errored   int
+}
+
+// record counts one execution of the named command.
+func (s *sessionStats) record(name string, err error) {
+	if s.byCommand == nil {
+		s.byCommand = make(map[string]*commandStats)
+	}
+	c, ok := s.byCommand[name]
+	if !ok {
+		c = &commandStats{}
+		s.byCommand[name] = c
+	}
+	if err != nil {
+		c.errored++
+	} else {
+		c.succeeded++
+	}
+}
+
+// String renders the session totals followed by a per-command breakdown
+// sorted by command name.
+func (s *sessionStats) String() string {
+	names := make([]string, 0, len(s.byCommand))
+	succeeded, errored := 0, 0
+	for name, c := range s.byCommand {
+		names = append(names, name)
+		succeeded += c.succeeded
+		errored += c.errored
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Session: %d commands, %d succeeded, %d errored",
+		succeeded+errored, succeeded, errored))
+	for _, name := range names {
+		c := s.byCommand[name]
+		sb.WriteString(fmt.Sprintf("\n  %s: succeeded=%d errored=%d", name, c.succeeded, c.errored))
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+
+	"payment-sim/internal/parser"
+	"payment-sim/internal/service"
+)
+
+// transaction buffers the commands of an open BEGIN block until COMMIT.
+type transaction struct {
+	commands []*parser.Command
+	// depth is the INCLUDE depth of the BEGIN; the block must end in the
+	// same input.
+	depth int
+	// failed is set when a line inside the block was rejected; COMMIT then
+	// applies nothing.
+	failed bool
+}
+
+// notInTransaction lists the runner commands that cannot be buffered.
+var notInTransaction = map[string]bool{
+	"INCLUDE": true,
+	"RETRY":   true,
+	"STATS":   true,
+}
+
+// isTransactionControl reports whether name opens or closes a BEGIN block.
+func isTransactionControl(name string) bool {
+	return name == "BEGIN" || name == "COMMIT" || name == "ROLLBACK"
+}
+
+// transact handles BEGIN, COMMIT and ROLLBACK, and buffers any other command
+// while a transaction is open.
+func (r *Runner) transact(cmd *parser.Command, assign bool) error {
+	switch cmd.Name {
+	case "BEGIN":
+		if r.txn != nil {
+			r.txn.failed = true
+			return fmt.Errorf("BEGIN: a transaction is already open (nesting is not supported)")
+		}
+		r.txn = &transaction{depth: len(r.includes)}
+		return nil
+	case "COMMIT":
+		return r.commit()
+	case "ROLLBACK":
+		if r.txn == nil {
+			return fmt.Errorf("ROLLBACK: no open transaction")
+		}
+		n := len(r.txn.commands)
+		r.txn = nil
+		r.writeLine(r.formatter.Format(&service.Result{Command: "ROLLBACK", Outcome: "rolled_back",
+			Message: fmt.Sprintf("ROLLBACK: %d command(s) discarded", n)}))
+		return nil
+	}
+
+	if assign || notInTransaction[cmd.Name] {
+		r.txn.failed = true
+		name := cmd.Name
+		if assign {
+			name = "SET"
+		}
+		return fmt.Errorf("%s is not supported inside a transaction", name)
+	}
+	r.txn.commands = append(r.txn.commands, cmd)
+	return nil
+}
+
+// commit applies the open transaction atomically and prints the result of
+// each command, or nothing if any command fails.
+func (r *Runner) commit() error {
+	if r.txn == nil {
+		return fmt.Errorf("COMMIT: no open transaction")
+	}
+	txn := r.txn
+	r.txn = nil
+	if txn.failed {
+		return fmt.Errorf("COMMIT: transaction had errors, %d command(s) discarded", len(txn.commands))
+	}
+
+	results, err := r.processor.ExecuteBatch(txn.commands)
+	if err != nil {
+		return fmt.Errorf("COMMIT: %w", err)
+	}
+	for i, result := range results {
+		if r.quietReads && parser.IsReadOnly(txn.commands[i].Name) {
+			continue
+		}
+		if text := r.formatter.Format(result); text != "" {
+			r.writeLine(text)
+		}
+	}
+	r.writeLine(r.formatter.Format(&service.Result{Command: "COMMIT", Outcome: "committed",
+		Message: fmt.Sprintf("COMMIT: %d command(s) applied", len(results))}))
+	return nil
+}
+
+// abandon discards a transaction that input ended without committing.
+func (r *Runner) abandon() error {
+	n := len(r.txn.commands)
+	r.txn = nil
+	return fmt.Errorf("transaction not committed, %d command(s) discarded", n)
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// varPrefix marks a token to be replaced by a variable's value.
+const varPrefix = "$"
+
+// varName matches valid variable names.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// splitAssignment recognizes "SET <name> = <command ...>" and returns the
+// variable name and the command text. ok is false for any other line.
+func splitAssignment(line string) (name, command string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.EqualFold(fields[0], "SET") || fields[2] != "=" || !varName.MatchString(fields[1]) {
+		return "", "", false
+	}
+	return fields[1], strings.Join(fields[3:], " "), true
+}
+
+// substituteVars replaces every "$name" token in line with the variable's
+// value. Referencing an undefined variable is an error.
+func substituteVars(line string, vars map[string]string) (string, error) {
+	if !strings.Contains(line, varPrefix) {
+		return line, nil
+	}
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if !strings.HasPrefix(field, varPrefix) {
+			continue
+		}
+		value, ok := vars[strings.TrimPrefix(field, varPrefix)]
+		if !ok {
+			return "", fmt.Errorf("undefined variable: %s", field)
+		}
+		fields[i] = value
+	}
+	return strings.Join(fields, " "), nil
+}
@@ -0,0 +1,282 @@
+// Package config loads the CLI configuration from command-line flags layered
+// over PAYMENT_-prefixed environment variables.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"payment-sim/internal/domain"
+)
+
+// EnvPrefix is prepended to a flag's name to form its environment variable,
+// e.g. -quiet-reads is read from PAYMENT_QUIET_READS.
+const EnvPrefix = "PAYMENT_"
+
+// legacyThresholdEnv is the original environment variable for the review
+// threshold. It is honored when PAYMENT_THRESHOLD is not set.
+const legacyThresholdEnv = "PRE_SETTLEMENT_THRESHOLD"
+
+// outputEnv is an alternative environment variable for the output format,
+// e.g. PAYMENT_OUTPUT=json. PAYMENT_FORMAT takes precedence over it.
+const outputEnv = "PAYMENT_OUTPUT"
+
+// legacyAuthExpiryEnv is an alternative environment variable for the
+// authorization expiry. PAYMENT_AUTH_EXPIRY takes precedence over it.
+const legacyAuthExpiryEnv = "AUTH_EXPIRY"
+
+// transitionsFileEnv is an alternative environment variable for the
+// transitions file. PAYMENT_TRANSITIONS_FILE takes precedence over it.
+const transitionsFileEnv = "TRANSITIONS_FILE"
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Color modes.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// lineSeparators maps the escaped -line-sep values to their terminators.
+var lineSeparators = map[string]string{
+	`\n`:   "\n",
+	`\r\n`: "\r\n",
+	`\0`:   "\x00",
+}
+
+// Config holds the resolved CLI configuration.
+type Config struct {
+	Threshold         *big.Rat // nil disables PRE_SETTLEMENT_REVIEW
+	Format            string
+	QuietReads        bool
+	VoidReasons       []string
+	RequireVoidReason bool
+	CaptureWindow     time.Duration
+	AuthExpiry        time.Duration // authorization TTL before CAPTURE expires it (0 disables)
+	ReviewTTL         time.Duration
+	SimClock          bool
+	AmountExpr        bool
+	AmountParser      domain.AmountParser // parser of the -amount-dialect
+	StrictPrecision   bool
+	AllowCommands     []string // empty allows every command
+	Timing            bool
+	Align             int // LIST amount column width (0 disables)
+	ColWidth          int // LIST ID truncation width (0 disables)
+	Trace             bool
+	Color             string
+	Stats             bool
+	ContinueOnError   bool                         // false stops file, stdin and FIFO runs at the first error
+	AmountBands       map[string]domain.AmountBand // nil disables range checks
+	StrictBands       bool
+	NoIdempotent      bool
+	MaxBatchSize      int
+	MaxOpenAuth       int
+	Echo              bool
+	LineSep           string // resolved result/error line terminator
+	AutoIDSeed        int
+	Webhook           string // URL notified of every state change ("" disables)
+	WebhookRetries    int
+	WebhookBackoff    time.Duration
+	Listen            string
+	FIFO              string              // named pipe read continuously ("" disables)
+	StoreFile         string              // JSON file persisting the store across runs ("" keeps it in memory)
+	Transitions       map[string][]string // state machine loaded from -transitions-file (nil keeps the built-in table)
+	Files             []string            // Positional input files
+}
+
+// EnvName returns the environment variable consulted for a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load parses the command-line args (excluding the program name). Every flag
+// defaults to its PAYMENT_ environment variable when set; explicit flags take
+// precedence over the environment.
+func Load(args []string, getenv func(string) string, usageOutput io.Writer) (*Config, error) {
+	cfg := &Config{}
+	var threshold, voidReasons, allowCommands, amountBands, lineSep, amountDialect, transitionsFile string
+	var jsonOutput bool
+	format := FormatText
+	if value := getenv(outputEnv); value != "" {
+		format = value
+	}
+
+	fs := flag.NewFlagSet("payment-sim", flag.ContinueOnError)
+	fs.SetOutput(usageOutput)
+	fs.StringVar(&threshold, "threshold", getenv(legacyThresholdEnv), "PRE_SETTLEMENT_REVIEW threshold amount (0 or empty disables)")
+	fs.StringVar(&cfg.Format, "format", format, "output format: text or json")
+	fs.BoolVar(&jsonOutput, "json", false, "shorthand for -format=json")
+	fs.BoolVar(&cfg.QuietReads, "quiet-reads", false, "suppress successful output of read-only commands (STATUS, LIST, AUDIT)")
+	fs.StringVar(&voidReasons, "void-reasons", "", "comma-separated allowlist of VOID reason codes (empty allows any)")
+	fs.BoolVar(&cfg.RequireVoidReason, "require-void-reason", false, "reject VOID commands without a reason code")
+	fs.DurationVar(&cfg.CaptureWindow, "capture-window", 0, "maximum time between AUTHORIZE and CAPTURE (e.g. 72h; 0 disables)")
+	authExpiry := time.Duration(0)
+	if value := getenv(legacyAuthExpiryEnv); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", legacyAuthExpiryEnv, err)
+		}
+		authExpiry = d
+	}
+	fs.DurationVar(&cfg.AuthExpiry, "auth-expiry", authExpiry, "authorization lifetime; CAPTURE after it moves the payment to EXPIRED (e.g. 168h; 0 disables)")
+	fs.DurationVar(&cfg.ReviewTTL, "review-ttl", 0, "maximum time in PRE_SETTLEMENT_REVIEW before SWEEP voids a payment (0 disables)")
+	fs.BoolVar(&cfg.SimClock, "sim-clock", false, "use a simulated clock that only moves with TICK")
+	fs.StringVar(&amountDialect, "amount-dialect", domain.DefaultAmountDialect, "amount parser for CREATE and REFUND ("+strings.Join(domain.AmountDialects(), ", ")+")")
+	fs.BoolVar(&cfg.AmountExpr, "amount-expr", false, "allow arithmetic expressions (e.g. 10.00*3) as CREATE amounts")
+	fs.BoolVar(&cfg.StrictPrecision, "strict-precision", false, "reject CREATE amounts finer than the currency's minor units")
+	fs.StringVar(&allowCommands, "allow-commands", "", "comma-separated allowlist of commands (restricted mode; empty allows all)")
+	fs.BoolVar(&cfg.Timing, "timing", false, "append each command's duration and print a timing summary at exit")
+	fs.IntVar(&cfg.Align, "align", 0, "align LIST columns, right-aligning amounts to this width (0 disables)")
+	fs.IntVar(&cfg.ColWidth, "col-width", 0, "truncate long IDs in LIST text output to this width with ... (0 disables)")
+	fs.BoolVar(&cfg.Trace, "trace", false, "write a line to stderr for every payment state change")
+	fs.StringVar(&cfg.Color, "color", ColorAuto, "colorize text output: auto (terminals only), always or never")
+	fs.BoolVar(&cfg.Stats, "stats", false, "print session command stats (as for STATS) at exit")
+	fs.BoolVar(&cfg.ContinueOnError, "continue-on-error", true, "print errors and keep going; false stops at the first parse or execution error and exits non-zero")
+	fs.StringVar(&amountBands, "amount-bands", "", "plausible CREATE amount ranges per currency (e.g. USD:1-10000,JPY:100-1000000)")
+	fs.BoolVar(&cfg.StrictBands, "strict-bands", false, "reject CREATE amounts outside -amount-bands instead of warning")
+	fs.BoolVar(&cfg.NoIdempotent, "no-idempotent", false, "make duplicate CREATE and SETTLE errors instead of idempotent successes")
+	fs.IntVar(&cfg.MaxBatchSize, "max-batch-size", 0, "maximum payments SETTLE may tag with one batch (0 = unlimited)")
+	fs.IntVar(&cfg.MaxOpenAuth, "max-open-auth", 0, "maximum AUTHORIZED/PRE_SETTLEMENT_REVIEW payments per merchant (0 = unlimited)")
+	fs.BoolVar(&cfg.Echo, "echo", false, "print each parsed command (name and args) before its result")
+	fs.StringVar(&lineSep, "line-sep", `\n`, `result/error line terminator: \n, \r\n or \0`)
+	fs.IntVar(&cfg.AutoIDSeed, "auto-id-seed", 1, "first counter value for CREATE AUTO IDs (PAY-000001, ...)")
+	fs.StringVar(&cfg.Webhook, "webhook", "", "URL to POST every payment state change to as JSON (empty disables)")
+	fs.IntVar(&cfg.WebhookRetries, "webhook-retries", 3, "retries for a failed webhook delivery")
+	fs.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", 100*time.Millisecond, "wait before the first webhook retry; doubles on each further retry")
+	fs.StringVar(&transitionsFile, "transitions-file", getenv(transitionsFileEnv), "load the state machine transition table from this JSON file (empty uses the built-in table)")
+	fs.StringVar(&cfg.StoreFile, "store-file", "", "load payments from and save them to this JSON file (empty keeps the store in memory)")
+	fs.StringVar(&cfg.FIFO, "fifo", "", "read commands from this named pipe, reopening it at EOF, until EXIT or a signal")
+	fs.StringVar(&cfg.Listen, "listen", "", "serve commands over a socket instead of stdin/file (e.g. unix:/tmp/pay.sock)")
+
+	// Layer environment variables under the flags
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		if value := getenv(name); value != "" && envErr == nil {
+			if err := fs.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("invalid %s: %v", name, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Files = fs.Args()
+
+	if threshold != "" && threshold != "0" {
+		cfg.Threshold = new(big.Rat)
+		if _, ok := cfg.Threshold.SetString(threshold); !ok {
+			return nil, fmt.Errorf("invalid threshold: %s", threshold)
+		}
+	}
+
+	if jsonOutput {
+		cfg.Format = FormatJSON
+	}
+	if cfg.Format != FormatText && cfg.Format != FormatJSON {
+		return nil, fmt.Errorf("invalid format %q (expected %s or %s)", cfg.Format, FormatText, FormatJSON)
+	}
+
+	if cfg.ColWidth < 0 {
+		return nil, fmt.Errorf("invalid col-width %d (must be >= 0)", cfg.ColWidth)
+	}
+
+	if cfg.MaxOpenAuth < 0 {
+		return nil, fmt.Errorf("invalid max-open-auth %d (must be >= 0)", cfg.MaxOpenAuth)
+	}
+
+	if cfg.WebhookRetries < 0 {
+		return nil, fmt.Errorf("invalid webhook-retries %d (must be >= 0)", cfg.WebhookRetries)
+	}
+
+	if cfg.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("invalid max-batch-size %d (must be >= 0)", cfg.MaxBatchSize)
+	}
+
+	sep, ok := lineSeparators[lineSep]
+	if !ok {
+		return nil, fmt.Errorf("invalid line-sep %q (expected \\n, \\r\\n or \\0)", lineSep)
+	}
+	cfg.LineSep = sep
+
+	if cfg.Color != ColorAuto && cfg.Color != ColorAlways && cfg.Color != ColorNever {
+		return nil, fmt.Errorf("invalid color mode %q (expected %s, %s or %s)", cfg.Color, ColorAuto, ColorAlways, ColorNever)
+	}
+
+	if voidReasons != "" {
+		for _, reason := range strings.Split(voidReasons, ",") {
+			code, err := domain.ParseVoidReason(reason)
+			if err != nil {
+				return nil, fmt.Errorf("invalid void-reasons: %v", err)
+			}
+			cfg.VoidReasons = append(cfg.VoidReasons, code)
+		}
+	}
+	if amountBands != "" {
+		bands, err := domain.ParseAmountBands(amountBands)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AmountBands = bands
+	}
+	parser, ok := domain.LookupAmountParser(amountDialect)
+	if !ok {
+		return nil, fmt.Errorf("unknown amount-dialect %q (expected one of: %s)", amountDialect, strings.Join(domain.AmountDialects(), ", "))
+	}
+	cfg.AmountParser = parser
+	if allowCommands != "" {
+		cfg.AllowCommands = strings.Split(allowCommands, ",")
+	}
+	if transitionsFile != "" {
+		table, err := domain.LoadTransitions(transitionsFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transitions = table
+	}
+
+	if cfg.FIFO != "" && cfg.Listen != "" {
+		return nil, fmt.Errorf("-fifo and -listen cannot be combined")
+	}
+
+	if cfg.Listen != "" && (!strings.HasPrefix(cfg.Listen, "unix:") || cfg.Listen == "unix:") {
+		return nil, fmt.Errorf("invalid listen address (expected unix:<path>): %s", cfg.Listen)
+	}
+
+	return cfg, nil
+}
+
+// UseColor reports whether output should be colorized, given whether it goes
+// to a terminal. JSON output is never colorized.
+func (c *Config) UseColor(isTerminal bool) bool {
+	if c.Format == FormatJSON {
+		return false
+	}
+	switch c.Color {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		return isTerminal
+	default:
+		return false
+	}
+}
+
+// SocketPath returns the Unix socket path of the listen address, or "" if
+// socket mode is disabled.
+func (c *Config) SocketPath() string {
+	return strings.TrimPrefix(c.Listen, "unix:")
+}
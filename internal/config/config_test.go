@@ -0,0 +1,265 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func envFrom(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Format != FormatText {
+		t.Errorf("Format = %v, want text", cfg.Format)
+	}
+	if cfg.Threshold != nil {
+		t.Errorf("Threshold = %v, want nil", cfg.Threshold)
+	}
+	if cfg.LineSep != "\n" {
+		t.Errorf("LineSep = %q, want newline", cfg.LineSep)
+	}
+}
+
+func TestLoad_LineSep(t *testing.T) {
+	cfg, err := Load([]string{`-line-sep=\0`}, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LineSep != "\x00" {
+		t.Errorf("LineSep = %q, want NUL", cfg.LineSep)
+	}
+}
+
+func TestLoad_AmountDialect(t *testing.T) {
+	cfg, err := Load(nil, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AmountParser == nil {
+		t.Fatal("AmountParser = nil, want the decimal default")
+	}
+	if _, err := Load([]string{"-amount-dialect=klingon"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("expected an error for an unknown amount dialect")
+	}
+}
+
+func TestLoad_JSONShorthand(t *testing.T) {
+	cfg, err := Load([]string{"--json"}, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Format != FormatJSON {
+		t.Errorf("Format = %v, want json from --json", cfg.Format)
+	}
+
+	cfg, err = Load(nil, envFrom(map[string]string{"PAYMENT_OUTPUT": "json"}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Format != FormatJSON {
+		t.Errorf("Format = %v, want json from PAYMENT_OUTPUT", cfg.Format)
+	}
+
+	// PAYMENT_FORMAT wins over PAYMENT_OUTPUT
+	cfg, _ = Load(nil, envFrom(map[string]string{"PAYMENT_OUTPUT": "json", "PAYMENT_FORMAT": "text"}), io.Discard)
+	if cfg.Format != FormatText {
+		t.Errorf("Format = %v, want text from PAYMENT_FORMAT", cfg.Format)
+	}
+}
+
+func TestLoad_AuthExpiry(t *testing.T) {
+	cfg, err := Load(nil, envFrom(map[string]string{"AUTH_EXPIRY": "168h"}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AuthExpiry != 168*time.Hour {
+		t.Errorf("AuthExpiry = %v, want 168h from AUTH_EXPIRY", cfg.AuthExpiry)
+	}
+
+	cfg, _ = Load([]string{"-auth-expiry=1h"}, envFrom(map[string]string{"AUTH_EXPIRY": "168h"}), io.Discard)
+	if cfg.AuthExpiry != time.Hour {
+		t.Errorf("AuthExpiry = %v, want the -auth-expiry flag to win", cfg.AuthExpiry)
+	}
+	if _, err := Load(nil, envFrom(map[string]string{"AUTH_EXPIRY": "soon"}), io.Discard); err == nil {
+		t.Error("expected an error for an invalid AUTH_EXPIRY")
+	}
+}
+
+func TestLoad_EnvSetsFormat(t *testing.T) {
+	cfg, err := Load(nil, envFrom(map[string]string{"PAYMENT_FORMAT": "json"}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Format != FormatJSON {
+		t.Errorf("Format = %v, want json", cfg.Format)
+	}
+}
+
+func TestLoad_FlagOverridesEnv(t *testing.T) {
+	env := envFrom(map[string]string{
+		"PAYMENT_FORMAT":         "json",
+		"PAYMENT_CAPTURE_WINDOW": "1h",
+	})
+	cfg, err := Load([]string{"-format=text", "input.txt"}, env, io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Format != FormatText {
+		t.Errorf("Format = %v, want flag value text", cfg.Format)
+	}
+	if cfg.CaptureWindow != time.Hour {
+		t.Errorf("CaptureWindow = %v, want env value 1h", cfg.CaptureWindow)
+	}
+	if len(cfg.Files) != 1 || cfg.Files[0] != "input.txt" {
+		t.Errorf("Files = %v, want [input.txt]", cfg.Files)
+	}
+}
+
+func TestLoad_ContinueOnError(t *testing.T) {
+	cfg, err := Load(nil, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.ContinueOnError {
+		t.Error("ContinueOnError = false, want true by default")
+	}
+
+	cfg, err = Load(nil, envFrom(map[string]string{"PAYMENT_CONTINUE_ON_ERROR": "false"}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ContinueOnError {
+		t.Error("ContinueOnError = true, want false from PAYMENT_CONTINUE_ON_ERROR")
+	}
+}
+
+func TestLoad_VoidReasons(t *testing.T) {
+	cfg, err := Load([]string{"-void-reasons=fraud,DUPLICATE"}, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.VoidReasons) != 2 || cfg.VoidReasons[0] != "FRAUD" {
+		t.Errorf("VoidReasons = %v, want [FRAUD DUPLICATE]", cfg.VoidReasons)
+	}
+
+	if _, err := Load([]string{"-void-reasons=FRAUD,BORED"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() with an unknown void reason should fail")
+	}
+}
+
+func TestLoad_AllowCommands(t *testing.T) {
+	cfg, err := Load([]string{"-allow-commands=CREATE,STATUS,LIST"}, envFrom(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.AllowCommands) != 3 || cfg.AllowCommands[1] != "STATUS" {
+		t.Errorf("AllowCommands = %v, want [CREATE STATUS LIST]", cfg.AllowCommands)
+	}
+}
+
+func TestConfig_UseColor(t *testing.T) {
+	tests := []struct {
+		args       []string
+		isTerminal bool
+		want       bool
+	}{
+		{nil, true, true},
+		{nil, false, false},
+		{[]string{"-color=always"}, false, true},
+		{[]string{"-color=never"}, true, false},
+		{[]string{"-color=always", "-format=json"}, true, false},
+	}
+	for _, tt := range tests {
+		cfg, err := Load(tt.args, envFrom(nil), io.Discard)
+		if err != nil {
+			t.Fatalf("Load(%v) error = %v", tt.args, err)
+		}
+		if got := cfg.UseColor(tt.isTerminal); got != tt.want {
+			t.Errorf("Load(%v).UseColor(%v) = %v, want %v", tt.args, tt.isTerminal, got, tt.want)
+		}
+	}
+
+	if _, err := Load([]string{"-color=sometimes"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() should reject an unknown color mode")
+	}
+}
+
+func TestLoad_Threshold(t *testing.T) {
+	// Legacy variable is honored, PAYMENT_THRESHOLD takes precedence
+	cfg, err := Load(nil, envFrom(map[string]string{"PRE_SETTLEMENT_THRESHOLD": "1000"}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Threshold == nil || cfg.Threshold.RatString() != "1000" {
+		t.Errorf("Threshold = %v, want 1000", cfg.Threshold)
+	}
+
+	cfg, err = Load(nil, envFrom(map[string]string{
+		"PRE_SETTLEMENT_THRESHOLD": "1000",
+		"PAYMENT_THRESHOLD":        "500",
+	}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Threshold == nil || cfg.Threshold.RatString() != "500" {
+		t.Errorf("Threshold = %v, want 500", cfg.Threshold)
+	}
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	if _, err := Load([]string{"-format=xml"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() expected error for invalid format")
+	}
+	if _, err := Load(nil, envFrom(map[string]string{"PAYMENT_QUIET_READS": "maybe"}), io.Discard); err == nil {
+		t.Error("Load() expected error for invalid boolean env")
+	}
+	if _, err := Load([]string{"-threshold=abc"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() expected error for invalid threshold")
+	}
+	if _, err := Load([]string{"-line-sep=;"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() expected error for invalid line separator")
+	}
+	if _, err := Load([]string{"-fifo=/tmp/pay.fifo", "-listen=unix:/tmp/pay.sock"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() expected error for -fifo with -listen")
+	}
+	if _, err := Load([]string{"-listen=tcp:1234"}, envFrom(nil), io.Discard); err == nil {
+		t.Error("Load() expected error for invalid listen address")
+	}
+}
+
+func TestLoad_TransitionsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transitions.json")
+	if err := os.WriteFile(path, []byte(`{"INITIATED": ["VOIDED"], "VOIDED": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(nil, envFrom(map[string]string{"TRANSITIONS_FILE": path}), io.Discard)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.Transitions) != 2 {
+		t.Errorf("Transitions = %v, want the table from TRANSITIONS_FILE", cfg.Transitions)
+	}
+
+	cfg, _ = Load(nil, envFrom(nil), io.Discard)
+	if cfg.Transitions != nil {
+		t.Errorf("Transitions = %v, want nil without a transitions file", cfg.Transitions)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"INITIATED": ["PENDING"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load([]string{"-transitions-file=" + path}, envFrom(nil), io.Discard); err == nil {
+		t.Error("expected an error for a transitions file with an unknown state")
+	}
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for payment timestamps and time-based rules.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock backed by the wall clock.
+type SystemClock struct{}
+
+// Now returns the current wall-clock time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SimClock is a Clock that only moves when advanced. It lets scripts exercise
+// time-based rules deterministically, without real waiting. It is safe for
+// concurrent use, so background work such as webhook retries can wait on it.
+type SimClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewSimClock creates a simulated clock starting at the given time.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+// Now returns the simulated current time.
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the simulated time forward by d.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// defaultMinorUnits is the precision assumed for currencies not listed in
+// currencyMinorUnits.
+const defaultMinorUnits = 2
+
+// currencyMinorUnits lists currencies whose minor-unit precision differs from
+// the default of two decimal places.
+var currencyMinorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// iso4217Codes are the active ISO 4217 alphabetic currency codes, including
+// fund and precious-metal codes. XTS (testing) and XXX (no currency) are
+// deliberately excluded.
+var iso4217Codes = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true, "AWG": true, "AZN": true,
+	"BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true, "BMD": true, "BND": true, "BOB": true, "BOV": true,
+	"BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHE": true, "CHF": true,
+	"CHW": true, "CLF": true, "CLP": true, "CNY": true, "COP": true, "COU": true, "CRC": true, "CUC": true, "CUP": true, "CVE": true,
+	"CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true,
+	"FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true,
+	"HNL": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true, "JMD": true,
+	"JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true, "KWD": true, "KYD": true,
+	"KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true, "LYD": true, "MAD": true, "MDL": true, "MGA": true,
+	"MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true, "MXV": true,
+	"MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true,
+	"PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true, "RUB": true,
+	"RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true, "SHP": true, "SLE": true, "SLL": true,
+	"SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true,
+	"TND": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "USN": true,
+	"UYI": true, "UYU": true, "UYW": true, "UZS": true, "VED": true, "VES": true, "VND": true, "VUV": true, "WST": true, "XAF": true,
+	"XAG": true, "XAU": true, "XBA": true, "XBB": true, "XBC": true, "XBD": true, "XCD": true, "XCG": true, "XDR": true, "XOF": true,
+	"XPD": true, "XPF": true, "XPT": true, "XSU": true, "XUA": true, "YER": true, "ZAR": true, "ZMW": true, "ZWG": true, "ZWL": true,
+}
+
+// IsValidCurrency reports whether code is an ISO 4217 currency code. The
+// comparison is case-insensitive.
+func IsValidCurrency(code string) bool {
+	return iso4217Codes[NormalizeCurrency(code)]
+}
+
+// MinorUnits returns the number of decimal places used by the currency.
+func MinorUnits(currency string) int {
+	if units, ok := currencyMinorUnits[NormalizeCurrency(currency)]; ok {
+		return units
+	}
+	return defaultMinorUnits
+}
+
+// RoundingMode selects how amounts finer than a currency's minor units are
+// rounded. Modes are symmetric: negative amounts round like their magnitude.
+type RoundingMode string
+
+// Rounding modes.
+const (
+	RoundHalfUp   RoundingMode = "half-up"   // halves away from zero
+	RoundHalfEven RoundingMode = "half-even" // halves to the even neighbour
+	RoundDown     RoundingMode = "down"      // toward zero
+	RoundUp       RoundingMode = "up"        // away from zero
+)
+
+// roundHalfUp rounds r to the given number of decimal places, rounding
+// halves away from zero.
+func roundHalfUp(r *big.Rat, scale int) *big.Rat {
+	return roundRat(r, scale, RoundHalfUp)
+}
+
+// roundRat rounds r to the given number of decimal places using mode.
+func roundRat(r *big.Rat, scale int, mode RoundingMode) *big.Rat {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(factor))
+
+	num := new(big.Int).Abs(scaled.Num())
+	quo, rem := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		half := new(big.Int).Mul(rem, big.NewInt(2)).Cmp(scaled.Denom())
+		switch mode {
+		case RoundUp:
+			quo.Add(quo, big.NewInt(1))
+		case RoundHalfEven:
+			if half > 0 || (half == 0 && quo.Bit(0) == 1) {
+				quo.Add(quo, big.NewInt(1))
+			}
+		case RoundDown:
+		default:
+			if half >= 0 {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+	if scaled.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return new(big.Rat).SetFrac(quo, factor)
+}
+
+// RoundToCurrency rounds amount to the currency's minor units using mode,
+// so computed amounts never carry fractions of the smallest unit.
+func RoundToCurrency(amount *big.Rat, currency string, mode RoundingMode) *big.Rat {
+	return roundRat(amount, MinorUnits(currency), mode)
+}
+
+// SplitAmount divides total into n parts rounded to the currency's minor
+// units. Every part but the last is total/n rounded with mode; the last part
+// takes the remainder, so the parts always sum exactly to total.
+func SplitAmount(total *big.Rat, currency string, n int, mode RoundingMode) []*big.Rat {
+	if n <= 0 {
+		return nil
+	}
+	share := RoundToCurrency(new(big.Rat).Quo(total, big.NewRat(int64(n), 1)), currency, mode)
+	parts := make([]*big.Rat, n)
+	remaining := new(big.Rat).Set(total)
+	for i := 0; i < n-1; i++ {
+		parts[i] = new(big.Rat).Set(share)
+		remaining.Sub(remaining, share)
+	}
+	parts[n-1] = remaining
+	return parts
+}
+
+// CheckPrecision returns an error if amount has more decimal places than the
+// currency's minor units allow, i.e. if it cannot be stored without rounding.
+func CheckPrecision(amount *big.Rat, currency string) error {
+	units := MinorUnits(currency)
+	if roundHalfUp(amount, units).Cmp(amount) != 0 {
+		return NewValidationError("amount", fmt.Sprintf("%s allows at most %d decimal places",
+			NormalizeCurrency(currency), units))
+	}
+	return nil
+}
+
+// FormatMoney formats an exact amount with the currency's minor units,
+// e.g. 100.00 USD or 1000 JPY. Unlike FormatRat it never goes through float64.
+func FormatMoney(amount *big.Rat, currency string) string {
+	if amount == nil {
+		amount = new(big.Rat)
+	}
+	return roundHalfUp(amount, MinorUnits(currency)).FloatString(MinorUnits(currency))
+}
+
+// AmountBand is the plausible amount range for a currency, inclusive.
+type AmountBand struct {
+	Min *big.Rat
+	Max *big.Rat
+}
+
+// Contains reports whether amount lies within the band.
+func (b AmountBand) Contains(amount *big.Rat) bool {
+	return amount.Cmp(b.Min) >= 0 && amount.Cmp(b.Max) <= 0
+}
+
+// String formats the band as "min-max".
+func (b AmountBand) String() string {
+	return b.Min.RatString() + "-" + b.Max.RatString()
+}
+
+// ParseAmountBands parses a comma-separated list of per-currency bands such
+// as "USD:1-10000,JPY:100-1000000".
+func ParseAmountBands(spec string) (map[string]AmountBand, error) {
+	bands := make(map[string]AmountBand)
+	for _, item := range strings.Split(spec, ",") {
+		currency, limits, ok := strings.Cut(item, ":")
+		if !ok || len(currency) != 3 {
+			return nil, fmt.Errorf("invalid amount band %q (expected CUR:min-max)", item)
+		}
+		minStr, maxStr, ok := strings.Cut(limits, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid amount band %q (expected CUR:min-max)", item)
+		}
+		band := AmountBand{Min: new(big.Rat), Max: new(big.Rat)}
+		if _, ok := band.Min.SetString(minStr); !ok {
+			return nil, fmt.Errorf("invalid amount band %q: bad minimum %s", item, minStr)
+		}
+		if _, ok := band.Max.SetString(maxStr); !ok {
+			return nil, fmt.Errorf("invalid amount band %q: bad maximum %s", item, maxStr)
+		}
+		if band.Min.Cmp(band.Max) > 0 {
+			return nil, fmt.Errorf("invalid amount band %q: minimum exceeds maximum", item)
+		}
+		bands[NormalizeCurrency(currency)] = band
+	}
+	return bands, nil
+}
+
+// maxDecimalScale bounds the decimal places FormatDecimal will try before
+// falling back to a fraction.
+const maxDecimalScale = 30
+
+// FormatDecimal formats r exactly as a decimal string with no trailing zeros
+// (e.g. "100", "10.25"). Values without a terminating decimal expansion are
+// formatted as a fraction such as "1/3".
+func FormatDecimal(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	scaled := new(big.Rat).Set(r)
+	ten := big.NewRat(10, 1)
+	for scale := 1; scale <= maxDecimalScale; scale++ {
+		scaled.Mul(scaled, ten)
+		if scaled.IsInt() {
+			return r.FloatString(scale)
+		}
+	}
+	return r.RatString()
+}
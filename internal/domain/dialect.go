@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// DefaultAmountDialect is the name of the strict decimal amount parser.
+const DefaultAmountDialect = "decimal"
+
+// AmountParser parses an amount as written in one input dialect (minor units,
+// currency symbols, digit grouping, ...) into a positive *big.Rat.
+type AmountParser interface {
+	ParseAmount(s string) (*big.Rat, error)
+}
+
+// AmountParserFunc adapts an ordinary function to the AmountParser interface.
+type AmountParserFunc func(s string) (*big.Rat, error)
+
+// ParseAmount calls f(s).
+func (f AmountParserFunc) ParseAmount(s string) (*big.Rat, error) {
+	return f(s)
+}
+
+var (
+	amountParsersMu sync.RWMutex
+	amountParsers   = map[string]AmountParser{
+		DefaultAmountDialect: AmountParserFunc(ParseAmount),
+	}
+)
+
+// RegisterAmountParser makes parser selectable under name. It fails if the
+// name is empty or already registered.
+func RegisterAmountParser(name string, parser AmountParser) error {
+	if name == "" || parser == nil {
+		return fmt.Errorf("amount dialect needs a name and a parser")
+	}
+	amountParsersMu.Lock()
+	defer amountParsersMu.Unlock()
+	if _, exists := amountParsers[name]; exists {
+		return fmt.Errorf("amount dialect %q already registered", name)
+	}
+	amountParsers[name] = parser
+	return nil
+}
+
+// LookupAmountParser returns the parser registered under name.
+func LookupAmountParser(name string) (AmountParser, bool) {
+	amountParsersMu.RLock()
+	defer amountParsersMu.RUnlock()
+	parser, ok := amountParsers[name]
+	return parser, ok
+}
+
+// AmountDialects returns the registered dialect names in sorted order.
+func AmountDialects() []string {
+	amountParsersMu.RLock()
+	defer amountParsersMu.RUnlock()
+	names := make([]string, 0, len(amountParsers))
+	for name := range amountParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
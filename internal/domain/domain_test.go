@@ -1,8 +1,14 @@
 package domain
 
 import (
+	"errors"
 	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseAmount(t *testing.T) {
@@ -66,6 +72,38 @@ func TestParseAmount(t *testing.T) {
 	}
 }
 
+func TestParseSignedAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+		want    string
+	}{
+		{"positive", "10.50", false, "10.5"},
+		{"negative", "-5.25", false, "-5.25"},
+		{"zero - should fail", "0", true, ""},
+		{"invalid format", "abc", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignedAmount(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSignedAmount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && FormatRat(got) != tt.want {
+				t.Errorf("ParseSignedAmount() = %v, want %v", FormatRat(got), tt.want)
+			}
+		})
+	}
+
+	// ParseAmount keeps rejecting what ParseSignedAmount accepts
+	if _, err := ParseAmount("-5.25"); err == nil {
+		t.Error("ParseAmount() should still reject negative amounts")
+	}
+}
+
 func TestCanTransition(t *testing.T) {
 	tests := []struct {
 		name string
@@ -220,6 +258,28 @@ func TestFormatRat_Nil(t *testing.T) {
 	}
 }
 
+func TestFormatRat_Exact(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"99999999999999.99", "99999999999999.99"},
+		{"0.1", "0.1"},
+		{"0.123456789012", "0.123456789012"},
+		{"0.00000000000001", "0.00000000000001"},
+		{"12345678901234567890", "12345678901234567890.0"},
+		{"-2.50", "-2.5"},
+		{"1/3", "0.3333333333"},
+	}
+
+	for _, tt := range tests {
+		r, _ := new(big.Rat).SetString(tt.input)
+		if got := FormatRat(r); got != tt.want {
+			t.Errorf("FormatRat(%s) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestPaymentEquals_DifferentMerchant(t *testing.T) {
 	amount := big.NewRat(100, 1)
 	p1 := NewPayment("P001", amount, "USD", "M001")
@@ -269,3 +329,523 @@ func TestValidationError(t *testing.T) {
 		t.Errorf("Error() = %v, want %v", err.Error(), expected)
 	}
 }
+
+func TestParseVoidReason(t *testing.T) {
+	reason, err := ParseVoidReason("merchant_cancel")
+	if err != nil || reason != VoidReasonMerchantCancel {
+		t.Errorf("ParseVoidReason(merchant_cancel) = %q, %v, want MERCHANT_CANCEL", reason, err)
+	}
+
+	_, err = ParseVoidReason("BORED")
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Field != "reason_code" {
+		t.Errorf("ParseVoidReason(BORED) error = %v, want a reason_code ValidationError", err)
+	}
+}
+
+func TestPaymentEquals_CurrencyCaseInsensitive(t *testing.T) {
+	amount := big.NewRat(100, 1)
+	p1 := NewPayment("P001", amount, "usd", "M001")
+	p2 := NewPayment("P001", amount, "USD", "M001")
+
+	if p1.Currency != "USD" {
+		t.Errorf("Currency = %v, want USD", p1.Currency)
+	}
+	if !p1.Equals(p2) {
+		t.Error("p1 should equal p2 (currency differs only by case)")
+	}
+}
+
+func TestValidateTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   map[string][]string
+		wantErr string
+	}{
+		{"valid", map[string][]string{StateInitiated: {StateVoided}, StateVoided: {}}, ""},
+		{"empty", map[string][]string{}, "empty"},
+		{"unknown source", map[string][]string{"PENDING": {}}, "unknown state: PENDING"},
+		{"unknown target", map[string][]string{StateInitiated: {"PENDING"}}, "unknown state: PENDING (target of INITIATED)"},
+		{"undefined target", map[string][]string{StateInitiated: {StateVoided}}, "state VOIDED (target of INITIATED) is not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTransitions(tt.table)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateTransitions() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateTransitions() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTransitions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transitions.json")
+	data := `{"INITIATED": ["AUTHORIZED", "VOIDED"], "AUTHORIZED": ["CAPTURED"], "CAPTURED": [], "VOIDED": []}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadTransitions(path)
+	if err != nil {
+		t.Fatalf("LoadTransitions() error = %v", err)
+	}
+	SetTransitions(table)
+	t.Cleanup(func() { SetTransitions(nil) })
+
+	if !CanTransition(StateAuthorized, StateCaptured) {
+		t.Error("CanTransition(AUTHORIZED, CAPTURED) = false with the loaded table")
+	}
+	if err := ValidateTransition(StateCaptured, StateSettled); err == nil {
+		t.Error("ValidateTransition(CAPTURED, SETTLED) should fail with the loaded table")
+	}
+	if IsValidState(StateSettled) {
+		t.Error("IsValidState(SETTLED) = true, want false when the table omits it")
+	}
+
+	SetTransitions(nil)
+	if !CanTransition(StateCaptured, StateSettled) {
+		t.Error("SetTransitions(nil) should restore the built-in table")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"INITIATED": ["SETTLED"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTransitions(path); err == nil {
+		t.Error("LoadTransitions() should reject a table with an undefined target")
+	}
+	if _, err := LoadTransitions(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadTransitions() should fail for a missing file")
+	}
+}
+
+func TestPredecessors(t *testing.T) {
+	got, err := Predecessors(StateCaptured)
+	if err != nil {
+		t.Fatalf("Predecessors() error = %v", err)
+	}
+	if len(got) != 3 || got[0] != StateAuthorized || got[1] != StateDisputed || got[2] != StatePreSettlementReview {
+		t.Errorf("Predecessors(CAPTURED) = %v, want [AUTHORIZED DISPUTED PRE_SETTLEMENT_REVIEW]", got)
+	}
+
+	got, err = Predecessors(StateInitiated)
+	if err != nil {
+		t.Fatalf("Predecessors() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Predecessors(INITIATED) = %v, want none", got)
+	}
+
+	if _, err := Predecessors("UNKNOWN_STATE"); err == nil {
+		t.Error("Predecessors() expected error for unknown state")
+	}
+}
+
+// FixedClock is a Clock that always returns the same instant.
+type FixedClock struct {
+	now time.Time
+}
+
+func (c FixedClock) Now() time.Time {
+	return c.now
+}
+
+func TestNewPaymentWithClock(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := NewPaymentWithClock("P001", big.NewRat(100, 1), "USD", "M001", FixedClock{now: at})
+
+	if !p.CreatedAt.Equal(at) || !p.History[0].Timestamp.Equal(at) {
+		t.Errorf("CreatedAt = %v, History[0] = %v, want %v", p.CreatedAt, p.History[0].Timestamp, at)
+	}
+
+	later := at.Add(time.Hour)
+	p.SetClock(FixedClock{now: later})
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "Payment authorized")
+
+	entry, ok := p.LastEntry("AUTHORIZE")
+	if !ok || !entry.Timestamp.Equal(later) {
+		t.Errorf("LastEntry(AUTHORIZE) = %v, %v, want timestamp %v", entry, ok, later)
+	}
+	if _, ok := p.LastEntry("CAPTURE"); ok {
+		t.Error("LastEntry(CAPTURE) should not be found")
+	}
+}
+
+func TestHistoryTimestamps_FixedClock(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := NewPaymentWithClock("P001", big.NewRat(100, 1), "USD", "M001", FixedClock{now: at})
+	p.SetClock(FixedClock{now: at.Add(time.Minute)})
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	p.SetClock(FixedClock{now: at.Add(2 * time.Minute)})
+	p.SetFailed("issuer timeout")
+
+	want := []time.Time{at, at.Add(time.Minute), at.Add(2 * time.Minute)}
+	if len(p.History) != len(want) {
+		t.Fatalf("history length = %d, want %d", len(p.History), len(want))
+	}
+	for i, ts := range want {
+		if !p.History[i].Timestamp.Equal(ts) {
+			t.Errorf("History[%d].Timestamp = %v, want %v", i, p.History[i].Timestamp, ts)
+		}
+	}
+	if !p.UpdatedAt.Equal(want[2]) {
+		t.Errorf("UpdatedAt = %v, want %v", p.UpdatedAt, want[2])
+	}
+}
+
+func TestMoneyViolations(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	p.TransitionTo(StateCaptured, "CAPTURE", "")
+	p.RecordCapture(p.Amount, "USD")
+
+	if v := p.MoneyViolations(); len(v) != 0 {
+		t.Errorf("MoneyViolations() = %v, want none", v)
+	}
+	if p.NetAmount().Cmp(big.NewRat(100, 1)) != 0 {
+		t.Errorf("NetAmount() = %v, want 100", p.NetAmount())
+	}
+
+	p.RefundedAmount = big.NewRat(150, 1)
+	if v := p.MoneyViolations(); len(v) != 1 {
+		t.Errorf("MoneyViolations() = %v, want over-refund violation", v)
+	}
+}
+
+func TestUnsettle(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	if err := p.Unsettle(); err == nil {
+		t.Error("Unsettle() expected error for non-settled payment")
+	}
+
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	p.TransitionTo(StateCaptured, "CAPTURE", "")
+	p.TransitionTo(StateSettled, "SETTLE", "")
+	p.AssignBatch("BATCH001")
+
+	if err := p.Unsettle(); err != nil {
+		t.Fatalf("Unsettle() error = %v", err)
+	}
+	if p.State != StateCaptured || p.BatchID != "" {
+		t.Errorf("State = %v, BatchID = %q, want CAPTURED and cleared", p.State, p.BatchID)
+	}
+	if CanTransition(StateSettled, StateCaptured) {
+		t.Error("SETTLED -> CAPTURED must not be a regular transition")
+	}
+}
+
+func TestExpireReview(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	if err := p.ExpireReview(); err == nil {
+		t.Error("ExpireReview() expected error for payment not in review")
+	}
+
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	p.TransitionTo(StatePreSettlementReview, "REVIEW", "")
+	if err := p.ExpireReview(); err != nil {
+		t.Fatalf("ExpireReview() error = %v", err)
+	}
+	if p.State != StateVoided || p.VoidReason != ReviewExpiredReason {
+		t.Errorf("State = %v, VoidReason = %q, want VOIDED and REVIEW_EXPIRED", p.State, p.VoidReason)
+	}
+	if CanTransition(StatePreSettlementReview, StateVoided) {
+		t.Error("PRE_SETTLEMENT_REVIEW -> VOIDED must not be a regular transition")
+	}
+}
+
+func TestReauthorizeReview(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	if err := p.ReauthorizeReview(); err == nil {
+		t.Error("ReauthorizeReview() expected error for payment not in review")
+	}
+
+	p.TransitionTo(StatePreSettlementReview, "REVIEW", "")
+	if err := p.ReauthorizeReview(); err != nil {
+		t.Fatalf("ReauthorizeReview() error = %v", err)
+	}
+	if p.State != StateAuthorized {
+		t.Errorf("State = %v, want AUTHORIZED", p.State)
+	}
+	if v := p.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+	if CanTransition(StatePreSettlementReview, StateAuthorized) {
+		t.Error("PRE_SETTLEMENT_REVIEW -> AUTHORIZED must not be a regular transition")
+	}
+
+	// Other actions may not take the REAUTHORIZE edge
+	p.TransitionTo(StatePreSettlementReview, "REVIEW", "")
+	p.History = append(p.History, HistoryEntry{FromState: StatePreSettlementReview, ToState: StateAuthorized, Action: "AUTHORIZE"})
+	p.State = StateAuthorized
+	v := p.HistoryViolations()
+	if len(v) != 1 || v[0] != "entry #6 AUTHORIZE PRE_SETTLEMENT_REVIEW->AUTHORIZED is not an allowed transition" {
+		t.Errorf("HistoryViolations() = %v, want invalid transition", v)
+	}
+}
+
+func TestUncapturedRemainder(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	if p.UncapturedRemainder().Sign() != 0 {
+		t.Error("UncapturedRemainder() before capture should be zero")
+	}
+	p.RecordCapture(big.NewRat(60, 1), "USD")
+	if got := p.UncapturedRemainder(); got.Cmp(big.NewRat(40, 1)) != 0 {
+		t.Errorf("UncapturedRemainder() = %v, want 40", got)
+	}
+}
+
+func TestDeadEnds(t *testing.T) {
+	if got := DeadEnds(AllowedTransitions); len(got) != 0 {
+		t.Errorf("DeadEnds(AllowedTransitions) = %v, want none", got)
+	}
+
+	custom := map[string][]string{
+		StateInitiated:  {StateAuthorized, "ON_HOLD"},
+		StateAuthorized: {StateCaptured, StateVoided},
+		StateCaptured:   {StateSettled, "DISPUTED"},
+		"ON_HOLD":       {"ON_HOLD"}, // self-loop only
+		StateSettled:    {StateSettled},
+		StateVoided:     {},
+	}
+	want := []string{"DISPUTED", "ON_HOLD"}
+	if got := DeadEnds(custom); !reflect.DeepEqual(got, want) {
+		t.Errorf("DeadEnds(custom) = %v, want %v", got, want)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	for state, want := range map[string]bool{
+		StateInitiated:           false,
+		StateAuthorized:          false,
+		StatePreSettlementReview: false,
+		StateCaptured:            false,
+		StateSettled:             true,
+		StateVoided:              true,
+		StateRefunded:            true,
+		StateFailed:              true,
+		StateReversed:            true,
+	} {
+		if got := IsTerminal(state); got != want {
+			t.Errorf("IsTerminal(%s) = %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestHistoryViolations(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	if v := p.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+
+	// An entry that skips the transition table
+	p.History = append(p.History, HistoryEntry{Seq: 3, FromState: StateAuthorized, ToState: StateSettled, Action: "SETTLE"})
+	p.State = StateSettled
+	v := p.HistoryViolations()
+	if len(v) != 1 || v[0] != "entry #3 SETTLE AUTHORIZED->SETTLED is not an allowed transition" {
+		t.Errorf("HistoryViolations() = %v, want invalid transition", v)
+	}
+}
+
+func TestEvalAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		currency string
+		want     string // exact rational, as RatString
+		wantErr  bool
+	}{
+		{"plain number", "100.00", "USD", "100", false},
+		{"multiplication", "10.00*3", "USD", "30", false},
+		{"addition", "10.25+2.50", "USD", "51/4", false},
+		{"precedence and parentheses", "(100+50)/4*2", "USD", "75", false},
+		{"division rounds to cents", "100.00/3", "USD", "3333/100", false},
+		{"division rounds half up", "0.05/3", "USD", "1/50", false},
+		{"terminating division stays exact", "0.05/2", "USD", "1/40", false},
+		{"plain number is not rounded", "10.005", "USD", "2001/200", false},
+		{"product is not rounded", "3.335*3", "USD", "2001/200", false},
+		{"division rounds to yen", "1000/3", "JPY", "333", false},
+		{"unbalanced parenthesis", "(10+2", "USD", "", true},
+		{"dangling operator", "10*", "USD", "", true},
+		{"division by zero", "10/0", "USD", "", true},
+		{"non-positive result", "10-10", "USD", "", true},
+		{"garbage", "10x", "USD", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvalAmount(tt.expr, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvalAmount(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.RatString() != tt.want {
+				t.Errorf("EvalAmount(%q) = %v, want %v", tt.expr, got.RatString(), tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidCurrency(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"USD", true},
+		{"eur", true},
+		{"Jpy", true},
+		{"XXX", false},
+		{"ZZZ", false},
+		{"EРU", false}, // Cyrillic Er
+		{"US", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidCurrency(tt.code); got != tt.want {
+			t.Errorf("IsValidCurrency(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		amount   *big.Rat
+		currency string
+		want     string
+	}{
+		{big.NewRat(100, 1), "USD", "100.00"},
+		{big.NewRat(1, 3), "USD", "0.33"},
+		{big.NewRat(1000, 1), "JPY", "1000"},
+		{big.NewRat(1125, 1000), "KWD", "1.125"},
+		{nil, "USD", "0.00"},
+	}
+	for _, tt := range tests {
+		if got := FormatMoney(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("FormatMoney(%v, %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmountBands(t *testing.T) {
+	bands, err := ParseAmountBands("usd:1-10000,JPY:100-1000000")
+	if err != nil {
+		t.Fatalf("ParseAmountBands() error = %v", err)
+	}
+	if !bands["USD"].Contains(big.NewRat(10000, 1)) || bands["USD"].Contains(big.NewRat(10001, 1)) {
+		t.Errorf("USD band = %v, want inclusive 1-10000", bands["USD"])
+	}
+
+	for _, spec := range []string{"USD", "USD:1", "USD:x-5", "USD:10-1", "DOLLAR:1-5"} {
+		if _, err := ParseAmountBands(spec); err == nil {
+			t.Errorf("ParseAmountBands(%q) expected error", spec)
+		}
+	}
+}
+
+func TestRoundToCurrency(t *testing.T) {
+	tests := []struct {
+		amount   *big.Rat
+		currency string
+		mode     RoundingMode
+		want     string
+	}{
+		{big.NewRat(1005, 1000), "USD", RoundHalfUp, "1.01"},
+		{big.NewRat(1005, 1000), "USD", RoundHalfEven, "1.00"},
+		{big.NewRat(1015, 1000), "USD", RoundHalfEven, "1.02"},
+		{big.NewRat(1009, 1000), "USD", RoundDown, "1.00"},
+		{big.NewRat(1001, 1000), "USD", RoundUp, "1.01"},
+		{big.NewRat(-1005, 1000), "USD", RoundHalfUp, "-1.01"},
+		{big.NewRat(1005, 10), "JPY", RoundHalfUp, "101"},
+	}
+	for _, tt := range tests {
+		got := RoundToCurrency(tt.amount, tt.currency, tt.mode).FloatString(MinorUnits(tt.currency))
+		if got != tt.want {
+			t.Errorf("RoundToCurrency(%v, %s, %s) = %s, want %s", tt.amount, tt.currency, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAmount(t *testing.T) {
+	total := big.NewRat(10, 1)
+	parts := SplitAmount(total, "USD", 3, RoundHalfUp)
+
+	want := []string{"3.33", "3.33", "3.34"}
+	sum := new(big.Rat)
+	for i, part := range parts {
+		if got := part.FloatString(2); got != want[i] {
+			t.Errorf("part %d = %s, want %s", i, got, want[i])
+		}
+		sum.Add(sum, part)
+	}
+	if sum.Cmp(total) != 0 {
+		t.Errorf("parts sum to %s, want 10.00", sum.FloatString(2))
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		amount *big.Rat
+		want   string
+	}{
+		{big.NewRat(100, 1), "100"},
+		{big.NewRat(1025, 100), "10.25"},
+		{big.NewRat(1, 8), "0.125"},
+		{big.NewRat(1, 3), "1/3"},
+	}
+	for _, tt := range tests {
+		if got := FormatDecimal(tt.amount); got != tt.want {
+			t.Errorf("FormatDecimal(%v) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPrecision(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		wantErr  bool
+	}{
+		{"10.25", "USD", false},
+		{"10.001", "USD", true},
+		{"1000", "JPY", false},
+		{"0.5", "JPY", true},
+		{"1.125", "KWD", false},
+		{"1.1255", "KWD", true},
+	}
+
+	for _, tt := range tests {
+		amount, _ := new(big.Rat).SetString(tt.amount)
+		err := CheckPrecision(amount, tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckPrecision(%s %s) error = %v, wantErr %v", tt.amount, tt.currency, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCurrencyDrift(t *testing.T) {
+	p := NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	p.TransitionTo(StateAuthorized, "AUTHORIZE", "")
+	p.TransitionTo(StateCaptured, "CAPTURE", "")
+
+	if err := p.RecordCapture(p.Amount, "EUR"); err == nil {
+		t.Error("RecordCapture() expected error for mismatched currency")
+	}
+	if err := p.RecordCapture(p.Amount, "usd"); err != nil {
+		t.Fatalf("RecordCapture() error = %v", err)
+	}
+	if v := p.MoneyViolations(); len(v) != 0 {
+		t.Errorf("MoneyViolations() = %v, want none", v)
+	}
+
+	// Deliberately mix units behind the mutation-time check
+	p.Movements = append(p.Movements, Movement{Kind: MovementRefund, Amount: big.NewRat(10, 1), Currency: "EUR"})
+	v := p.MoneyViolations()
+	if len(v) != 1 || v[0] != "REFUND of 10.0 recorded in EUR, payment currency is USD" {
+		t.Errorf("MoneyViolations() = %v, want currency drift", v)
+	}
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// EvalAmount evaluates a simple arithmetic amount expression such as
+// "10.00*3" or "(100+50)/4" using exact rational arithmetic. Supported
+// operators are + - * / and parentheses. Only a division whose quotient has
+// no finite decimal expansion (e.g. 100/3) is rounded half-up to the
+// currency's minor units; every other result stays exact, so a later
+// precision check still sees e.g. 10.005 USD. The result must be positive.
+func EvalAmount(expr, currency string) (*big.Rat, error) {
+	e := &exprParser{input: strings.ReplaceAll(expr, " ", ""), scale: MinorUnits(currency)}
+	if e.input == "" {
+		return nil, fmt.Errorf("invalid amount expression: empty")
+	}
+
+	r, err := e.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount expression %s: %v", expr, err)
+	}
+	if e.pos < len(e.input) {
+		return nil, fmt.Errorf("invalid amount expression %s: unexpected %q at position %d", expr, e.input[e.pos], e.pos+1)
+	}
+
+	if r.Sign() <= 0 {
+		return nil, fmt.Errorf("amount must be positive: %s", expr)
+	}
+	return r, nil
+}
+
+// exprParser is a recursive-descent parser over big.Rat:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | "(" expr ")"
+type exprParser struct {
+	input string
+	pos   int
+	// scale is the number of decimal places non-terminating quotients are
+	// rounded to.
+	scale int
+}
+
+func (e *exprParser) peek() byte {
+	if e.pos < len(e.input) {
+		return e.input[e.pos]
+	}
+	return 0
+}
+
+func (e *exprParser) parseExpr() (*big.Rat, error) {
+	left, err := e.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for op := e.peek(); op == '+' || op == '-'; op = e.peek() {
+		e.pos++
+		right, err := e.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		if op == '+' {
+			left = new(big.Rat).Add(left, right)
+		} else {
+			left = new(big.Rat).Sub(left, right)
+		}
+	}
+	return left, nil
+}
+
+func (e *exprParser) parseTerm() (*big.Rat, error) {
+	left, err := e.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for op := e.peek(); op == '*' || op == '/'; op = e.peek() {
+		e.pos++
+		right, err := e.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if op == '*' {
+			left = new(big.Rat).Mul(left, right)
+		} else {
+			if right.Sign() == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			left = new(big.Rat).Quo(left, right)
+			if _, ok := decimalPlaces(left); !ok {
+				left = roundHalfUp(left, e.scale)
+			}
+		}
+	}
+	return left, nil
+}
+
+func (e *exprParser) parseFactor() (*big.Rat, error) {
+	if e.peek() == '(' {
+		e.pos++
+		r, err := e.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if e.peek() != ')' {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		e.pos++
+		return r, nil
+	}
+
+	start := e.pos
+	for c := e.peek(); (c >= '0' && c <= '9') || c == '.'; c = e.peek() {
+		e.pos++
+	}
+	if start == e.pos {
+		if e.pos >= len(e.input) {
+			return nil, fmt.Errorf("unexpected end of expression")
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", e.input[e.pos], e.pos+1)
+	}
+
+	r, ok := new(big.Rat).SetString(e.input[start:e.pos])
+	if !ok {
+		return nil, fmt.Errorf("invalid number %s", e.input[start:e.pos])
+	}
+	return r, nil
+}
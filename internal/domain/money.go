@@ -0,0 +1,229 @@
+package domain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// capturedStates are the states in which funds have been captured.
+var capturedStates = map[string]bool{
+	StateCaptured:    true,
+	StateSettled:     true,
+	StateRefunded:    true,
+	StateDisputed:    true,
+	StateChargedBack: true,
+}
+
+// Movement kinds.
+const (
+	MovementCapture = "CAPTURE"
+	MovementRefund  = "REFUND"
+	MovementRelease = "RELEASE"
+)
+
+// Movement is a monetary sub-amount (capture, refund, release) recorded against a
+// payment, together with the currency it was recorded in.
+type Movement struct {
+	Kind     string
+	Amount   *big.Rat
+	Currency string
+	// Reason is the optional reason code of a refund.
+	Reason string
+}
+
+// Adjustment is a signed correction recorded against a settled payment.
+// Negative amounts are credits to the cardholder.
+type Adjustment struct {
+	Amount    *big.Rat
+	Reason    string
+	Timestamp time.Time
+}
+
+// recordMovement appends a movement after checking that its currency matches
+// the payment currency.
+func (p *Payment) recordMovement(kind string, amount *big.Rat, currency, reason string) error {
+	if NormalizeCurrency(currency) != p.Currency {
+		return fmt.Errorf("currency mismatch for payment %s: %s recorded in %s, payment currency is %s",
+			p.ID, kind, NormalizeCurrency(currency), p.Currency)
+	}
+	p.Movements = append(p.Movements, Movement{
+		Kind:     kind,
+		Amount:   new(big.Rat).Set(amount),
+		Currency: NormalizeCurrency(currency),
+		Reason:   reason,
+	})
+	return nil
+}
+
+// RecordCapture records the amount captured for the payment.
+// The currency must match the payment currency.
+func (p *Payment) RecordCapture(amount *big.Rat, currency string) error {
+	if err := p.recordMovement(MovementCapture, amount, currency, ""); err != nil {
+		return err
+	}
+	p.CapturedAmount = new(big.Rat).Set(amount)
+	return nil
+}
+
+// RecordRefund adds the amount to the payment's refunded total.
+// The currency must match the payment currency.
+func (p *Payment) RecordRefund(amount *big.Rat, currency string) error {
+	return p.RecordRefundWithReason(amount, currency, "")
+}
+
+// RecordRefundWithReason is RecordRefund with a refund reason code.
+func (p *Payment) RecordRefundWithReason(amount *big.Rat, currency, reason string) error {
+	if err := p.recordMovement(MovementRefund, amount, currency, reason); err != nil {
+		return err
+	}
+	if p.RefundedAmount == nil {
+		p.RefundedAmount = new(big.Rat)
+	}
+	p.RefundedAmount = new(big.Rat).Add(p.RefundedAmount, amount)
+	return nil
+}
+
+// RecordPartialRefund refunds part of a CAPTURED payment without changing
+// its state, so that further refunds remain possible, and records a
+// CAPTURED->CAPTURED REFUND history entry. The amount must be less than what
+// is still refundable; refunding the rest is a full REFUND.
+func (p *Payment) RecordPartialRefund(amount *big.Rat, reason string) error {
+	remaining, why := p.Refundable()
+	if why != "" {
+		return fmt.Errorf("cannot refund payment %s: %s", p.ID, why)
+	}
+	if amount.Cmp(remaining) >= 0 {
+		return fmt.Errorf("partial refund of %s must be less than the refundable %s", FormatRat(amount), FormatRat(remaining))
+	}
+	if err := p.RecordRefundWithReason(amount, p.Currency, reason); err != nil {
+		return err
+	}
+	left := new(big.Rat).Sub(remaining, amount)
+	details := fmt.Sprintf("Partially refunded %s %s, %s %s still refundable",
+		FormatMoney(amount, p.Currency), p.Currency, FormatMoney(left, p.Currency), p.Currency)
+	if reason != "" {
+		details += " (reason " + reason + ")"
+	}
+	p.UpdatedAt = p.now()
+	p.addHistory(StateCaptured, StateCaptured, "REFUND", details)
+	return nil
+}
+
+// UncapturedRemainder returns the authorized amount that was not captured,
+// or zero if nothing was captured or the capture was for the full amount.
+func (p *Payment) UncapturedRemainder() *big.Rat {
+	if p.CapturedAmount == nil || p.CapturedAmount.Cmp(p.Amount) >= 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Sub(p.Amount, p.CapturedAmount)
+}
+
+// RecordRelease records that an uncaptured part of the authorization was
+// released back to the cardholder.
+func (p *Payment) RecordRelease(amount *big.Rat, currency string) error {
+	return p.recordMovement(MovementRelease, amount, currency, "")
+}
+
+// RecordAdjustment adds a signed adjustment to a SETTLED payment without
+// changing its state. Adjustments are additive.
+func (p *Payment) RecordAdjustment(amount *big.Rat, reason string) error {
+	if p.State != StateSettled {
+		return fmt.Errorf("cannot adjust payment %s in state %s (must be SETTLED)", p.ID, p.State)
+	}
+	now := p.now()
+	p.Adjustments = append(p.Adjustments, Adjustment{
+		Amount:    new(big.Rat).Set(amount),
+		Reason:    reason,
+		Timestamp: now,
+	})
+	p.UpdatedAt = now
+	return nil
+}
+
+// Adjusted returns the sum of the payment's adjustments (zero if none).
+func (p *Payment) Adjusted() *big.Rat {
+	total := new(big.Rat)
+	for _, a := range p.Adjustments {
+		total.Add(total, a.Amount)
+	}
+	return total
+}
+
+// Captured returns the captured amount, or zero if nothing was captured.
+func (p *Payment) Captured() *big.Rat {
+	if p.CapturedAmount == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(p.CapturedAmount)
+}
+
+// Refunded returns the refunded amount, or zero if nothing was refunded.
+func (p *Payment) Refunded() *big.Rat {
+	if p.RefundedAmount == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(p.RefundedAmount)
+}
+
+// NetAmount returns the amount that would settle: captured minus refunded,
+// plus any adjustments.
+func (p *Payment) NetAmount() *big.Rat {
+	net := new(big.Rat).Sub(p.Captured(), p.Refunded())
+	return net.Add(net, p.Adjusted())
+}
+
+// MoneyViolations checks the consistency of the payment's money flow and
+// returns a description of every violation found (nil if consistent):
+// no amount is negative, captured never exceeds the authorized amount,
+// refunded never exceeds captured, captured funds match the state, and every
+// recorded movement is in the payment currency.
+func (p *Payment) MoneyViolations() []string {
+	var violations []string
+	captured := p.Captured()
+	refunded := p.Refunded()
+
+	if captured.Sign() < 0 {
+		violations = append(violations, fmt.Sprintf("captured amount %s is negative", FormatRat(captured)))
+	}
+	if refunded.Sign() < 0 {
+		violations = append(violations, fmt.Sprintf("refunded amount %s is negative", FormatRat(refunded)))
+	}
+	if captured.Cmp(p.Amount) > 0 {
+		violations = append(violations, fmt.Sprintf("captured %s exceeds authorized %s", FormatRat(captured), FormatRat(p.Amount)))
+	}
+	if refunded.Cmp(captured) > 0 {
+		violations = append(violations, fmt.Sprintf("refunded %s exceeds captured %s", FormatRat(refunded), FormatRat(captured)))
+	}
+	if capturedStates[p.State] && p.CapturedAmount == nil {
+		violations = append(violations, fmt.Sprintf("state %s has no captured amount", p.State))
+	}
+	if !capturedStates[p.State] && (captured.Sign() != 0 || refunded.Sign() != 0) {
+		violations = append(violations, fmt.Sprintf("state %s must not have captured or refunded funds", p.State))
+	}
+	for _, m := range p.Movements {
+		if m.Currency != p.Currency {
+			violations = append(violations, fmt.Sprintf("%s of %s recorded in %s, payment currency is %s",
+				m.Kind, FormatRat(m.Amount), m.Currency, p.Currency))
+		}
+	}
+	return violations
+}
+
+// Refundable returns the amount that can still be refunded: captured minus
+// refunded for a CAPTURED payment, and zero otherwise. When the amount is
+// zero, the second return value explains why.
+func (p *Payment) Refundable() (*big.Rat, string) {
+	switch p.State {
+	case StateCaptured:
+		remaining := new(big.Rat).Sub(p.Captured(), p.Refunded())
+		if remaining.Sign() <= 0 {
+			return new(big.Rat), "captured amount fully refunded"
+		}
+		return remaining, ""
+	case StateRefunded:
+		return new(big.Rat), "payment already refunded"
+	default:
+		return new(big.Rat), fmt.Sprintf("payment is %s; only CAPTURED payments can be refunded", p.State)
+	}
+}
@@ -4,6 +4,8 @@ package domain
 import (
 	"fmt"
 	"math/big"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -17,10 +19,62 @@ const (
 	StateVoided              = "VOIDED"
 	StateRefunded            = "REFUNDED"
 	StateFailed              = "FAILED"
+	StateReversed            = "REVERSED"
+	StateDeclined            = "DECLINED"
+	StateExpired             = "EXPIRED"
+	StateDisputed            = "DISPUTED"
+	StateChargedBack         = "CHARGED_BACK"
 )
 
+// IssuerDeclinedReason is the decline reason used when none is given.
+const IssuerDeclinedReason = "ISSUER_DECLINED"
+
+// ReviewExpiredReason is the void reason recorded by ExpireReview.
+const ReviewExpiredReason = "REVIEW_EXPIRED"
+
+// VOID reason codes accepted from operators.
+const (
+	VoidReasonCustomerRequest = "CUSTOMER_REQUEST"
+	VoidReasonFraud           = "FRAUD"
+	VoidReasonDuplicate       = "DUPLICATE"
+	VoidReasonMerchantCancel  = "MERCHANT_CANCEL"
+	VoidReasonExpired         = "EXPIRED"
+)
+
+// voidReasons is the set of valid VOID reason codes.
+var voidReasons = map[string]bool{
+	VoidReasonCustomerRequest: true,
+	VoidReasonFraud:           true,
+	VoidReasonDuplicate:       true,
+	VoidReasonMerchantCancel:  true,
+	VoidReasonExpired:         true,
+}
+
+// VoidReasons returns the valid VOID reason codes, sorted.
+func VoidReasons() []string {
+	reasons := make([]string, 0, len(voidReasons))
+	for r := range voidReasons {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// ParseVoidReason returns the canonical (uppercase) form of a VOID reason
+// code, compared case-insensitively, or a ValidationError if it is unknown.
+func ParseVoidReason(code string) (string, error) {
+	reason := strings.ToUpper(code)
+	if !voidReasons[reason] {
+		return "", NewValidationError("reason_code",
+			fmt.Sprintf("unknown reason code %s (valid: %s)", code, strings.Join(VoidReasons(), ", ")))
+	}
+	return reason, nil
+}
+
 // HistoryEntry represents a single state change in the payment lifecycle.
 type HistoryEntry struct {
+	// Seq is the 1-based position of the entry in the payment's history.
+	Seq       int
 	Timestamp time.Time
 	FromState string
 	ToState   string
@@ -36,32 +90,71 @@ type Payment struct {
 	MerchantID string
 	State      string
 	VoidReason string
-	History    []HistoryEntry
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	// DeclineReason is why the issuer declined the authorization ("" if not declined).
+	DeclineReason string
+	// DisputeReason is the reason code of the latest chargeback dispute ("" if never disputed).
+	DisputeReason string
+	// CapturedAmount is the amount captured (nil until CAPTURE).
+	CapturedAmount *big.Rat
+	// RefundedAmount is the total amount refunded so far (nil if none).
+	RefundedAmount *big.Rat
+	// Movements records each captured/refunded sub-amount with its currency.
+	Movements []Movement
+	// Adjustments are signed post-settlement corrections (credit notes).
+	Adjustments []Adjustment
+	// BatchID is the settlement batch the payment belongs to ("" if none).
+	BatchID   string
+	History   []HistoryEntry
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// clock stamps history entries and timestamps; nil means SystemClock.
+	clock Clock
 }
 
 // NewPayment creates a new payment in the INITIATED state.
+// The currency code is normalized to uppercase.
 func NewPayment(id string, amount *big.Rat, currency, merchantID string) *Payment {
-	now := time.Now()
+	return NewPaymentWithClock(id, amount, currency, merchantID, SystemClock{})
+}
+
+// NewPaymentWithClock creates a new payment in the INITIATED state whose
+// timestamps are taken from the given clock.
+func NewPaymentWithClock(id string, amount *big.Rat, currency, merchantID string, clock Clock) *Payment {
+	now := clock.Now()
 	p := &Payment{
 		ID:         id,
 		Amount:     amount,
-		Currency:   currency,
+		Currency:   NormalizeCurrency(currency),
 		MerchantID: merchantID,
 		State:      StateInitiated,
 		History:    make([]HistoryEntry, 0),
 		CreatedAt:  now,
 		UpdatedAt:  now,
+		clock:      clock,
 	}
 	p.addHistory("", StateInitiated, "CREATE", "Payment created")
 	return p
 }
 
+// SetClock sets the clock used for subsequent timestamps.
+func (p *Payment) SetClock(clock Clock) {
+	p.clock = clock
+}
+
+// now returns the current time according to the payment's clock.
+func (p *Payment) now() time.Time {
+	if p.clock == nil {
+		return time.Now()
+	}
+	return p.clock.Now()
+}
+
 // addHistory adds a new entry to the payment's history.
 func (p *Payment) addHistory(from, to, action, details string) {
 	p.History = append(p.History, HistoryEntry{
-		Timestamp: time.Now(),
+		Seq:       len(p.History) + 1,
+		Timestamp: p.now(),
 		FromState: from,
 		ToState:   to,
 		Action:    action,
@@ -76,7 +169,7 @@ func (p *Payment) TransitionTo(newState, action, details string) error {
 	}
 	oldState := p.State
 	p.State = newState
-	p.UpdatedAt = time.Now()
+	p.UpdatedAt = p.now()
 	p.addHistory(oldState, newState, action, details)
 	return nil
 }
@@ -85,13 +178,141 @@ func (p *Payment) TransitionTo(newState, action, details string) error {
 func (p *Payment) SetFailed(reason string) {
 	oldState := p.State
 	p.State = StateFailed
-	p.UpdatedAt = time.Now()
+	p.UpdatedAt = p.now()
 	p.addHistory(oldState, StateFailed, "FAIL", reason)
 }
 
-// SetVoidReason sets the void reason for the payment.
+// AssignBatch stamps the payment with a settlement batch ID.
+func (p *Payment) AssignBatch(batchID string) {
+	p.BatchID = batchID
+	p.UpdatedAt = p.now()
+}
+
+// Unsettle reverses a settlement, moving the payment from SETTLED back to
+// CAPTURED and clearing its batch ID. This reverse edge is deliberately not
+// part of AllowedTransitions; it is reserved for batch rollback.
+func (p *Payment) Unsettle() error {
+	if p.State != StateSettled {
+		return NewInvalidTransitionError(p.State, StateCaptured)
+	}
+	batchID := p.BatchID
+	p.State = StateCaptured
+	p.BatchID = ""
+	p.UpdatedAt = p.now()
+	p.addHistory(StateSettled, StateCaptured, "UNSETTLE", fmt.Sprintf(unsettleDetails, batchID))
+	return nil
+}
+
+// unsettleDetails is the history detail recorded by Unsettle.
+const unsettleDetails = "Removed from batch %s"
+
+// UnsettledFromBatch reports whether Unsettle removed the payment from the
+// given batch at some point.
+func (p *Payment) UnsettledFromBatch(batchID string) bool {
+	details := fmt.Sprintf(unsettleDetails, batchID)
+	for _, entry := range p.History {
+		if entry.Action == "UNSETTLE" && entry.Details == details {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpireReview voids a payment whose PRE_SETTLEMENT_REVIEW has outlived its
+// time limit. Like Unsettle, this edge is not part of AllowedTransitions: an
+// operator cannot VOID a payment under review.
+func (p *Payment) ExpireReview() error {
+	if p.State != StatePreSettlementReview {
+		return NewInvalidTransitionError(p.State, StateVoided)
+	}
+	p.State = StateVoided
+	p.VoidReason = ReviewExpiredReason
+	p.UpdatedAt = p.now()
+	p.addHistory(StatePreSettlementReview, StateVoided, "EXPIRE", "Review time limit elapsed")
+	return nil
+}
+
+// ReauthorizeReview refreshes the authorization of a payment under
+// PRE_SETTLEMENT_REVIEW, moving it back to AUTHORIZED so the review
+// threshold can be re-applied. Like ExpireReview, this edge is not part of
+// AllowedTransitions and is reserved for REAUTHORIZE.
+func (p *Payment) ReauthorizeReview() error {
+	if p.State != StatePreSettlementReview {
+		return NewInvalidTransitionError(p.State, StateAuthorized)
+	}
+	p.State = StateAuthorized
+	p.UpdatedAt = p.now()
+	p.addHistory(StatePreSettlementReview, StateAuthorized, "REAUTHORIZE", "Authorization refreshed")
+	return nil
+}
+
+// RestoreState sets the payment's state directly, bypassing the transition
+// table, and records a synthetic IMPORT history entry. It is used when loading
+// payments exported from another session.
+func (p *Payment) RestoreState(state string) error {
+	if !IsValidState(state) {
+		return fmt.Errorf("unknown state: %s", state)
+	}
+	oldState := p.State
+	p.State = state
+	p.UpdatedAt = p.now()
+	p.addHistory(oldState, state, "IMPORT", fmt.Sprintf("Imported in state %s", state))
+	return nil
+}
+
+// SetVoidReason sets the void reason for the payment, normalized to uppercase.
 func (p *Payment) SetVoidReason(reason string) {
-	p.VoidReason = reason
+	p.VoidReason = strings.ToUpper(reason)
+}
+
+// SetDeclineReason sets the issuer decline reason for the payment.
+func (p *Payment) SetDeclineReason(reason string) {
+	p.DeclineReason = reason
+}
+
+// SetDisputeReason sets the chargeback dispute reason for the payment.
+func (p *Payment) SetDisputeReason(reason string) {
+	p.DisputeReason = reason
+}
+
+// LastEntry returns the most recent history entry recorded for the given
+// action, and false if there is none.
+func (p *Payment) LastEntry(action string) (HistoryEntry, bool) {
+	for i := len(p.History) - 1; i >= 0; i-- {
+		if p.History[i].Action == action {
+			return p.History[i], true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// Clone returns a deep copy of the payment. Amounts and history are copied so
+// that mutations of the clone never affect the original.
+func (p *Payment) Clone() *Payment {
+	c := *p
+	c.Amount = cloneRat(p.Amount)
+	c.CapturedAmount = cloneRat(p.CapturedAmount)
+	c.RefundedAmount = cloneRat(p.RefundedAmount)
+	c.History = append([]HistoryEntry(nil), p.History...)
+	c.Movements = nil
+	for _, m := range p.Movements {
+		m.Amount = cloneRat(m.Amount)
+		c.Movements = append(c.Movements, m)
+	}
+	c.Adjustments = nil
+	for _, a := range p.Adjustments {
+		a.Amount = cloneRat(a.Amount)
+		c.Adjustments = append(c.Adjustments, a)
+	}
+	return &c
+}
+
+// cloneRat returns a copy of r, preserving nil.
+func cloneRat(r *big.Rat) *big.Rat {
+	if r == nil {
+		return nil
+	}
+	return new(big.Rat).Set(r)
 }
 
 // FormatAmount returns the amount as a formatted string.
@@ -107,7 +328,7 @@ func (p *Payment) Equals(other *Payment) bool {
 	if p.Amount.Cmp(other.Amount) != 0 {
 		return false
 	}
-	if p.Currency != other.Currency {
+	if NormalizeCurrency(p.Currency) != NormalizeCurrency(other.Currency) {
 		return false
 	}
 	if p.MerchantID != other.MerchantID {
@@ -116,6 +337,36 @@ func (p *Payment) Equals(other *Payment) bool {
 	return true
 }
 
+// NormalizeCurrency returns the canonical (uppercase) form of a currency code.
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(currency)
+}
+
+// decimalPlaces returns the number of decimal places needed to write r
+// exactly, and false if its decimal expansion does not terminate (the
+// denominator has a prime factor other than 2 and 5).
+func decimalPlaces(r *big.Rat) (int, bool) {
+	denom := new(big.Int).Set(r.Denom())
+	rem := new(big.Int)
+	count := func(factor int64) int {
+		n := 0
+		f := big.NewInt(factor)
+		for {
+			q, m := new(big.Int).QuoRem(denom, f, rem)
+			if m.Sign() != 0 {
+				return n
+			}
+			denom = q
+			n++
+		}
+	}
+	twos, fives := count(2), count(5)
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	return max(twos, fives), true
+}
+
 // ParseAmount parses a string amount into a *big.Rat.
 func ParseAmount(s string) (*big.Rat, error) {
 	r := new(big.Rat)
@@ -129,15 +380,35 @@ func ParseAmount(s string) (*big.Rat, error) {
 	return r, nil
 }
 
-// FormatRat formats a *big.Rat as a decimal string.
+// ParseSignedAmount parses a string amount that may be negative, such as a
+// credit adjustment. Zero is still rejected.
+func ParseSignedAmount(s string) (*big.Rat, error) {
+	r := new(big.Rat)
+	if _, ok := r.SetString(s); !ok {
+		return nil, fmt.Errorf("invalid amount format: %s", s)
+	}
+	if r.Sign() == 0 {
+		return nil, fmt.Errorf("amount must be non-zero: %s", s)
+	}
+	return r, nil
+}
+
+// formatRatPrecision is the number of decimal places FormatRat rounds to
+// when a rational has no finite decimal expansion (e.g. 1/3).
+const formatRatPrecision = 10
+
+// FormatRat formats a *big.Rat as a decimal string. It formats the exact
+// rational, so large or high-precision amounts are never rounded through a
+// float64; only non-terminating fractions are rounded.
 func FormatRat(r *big.Rat) string {
 	if r == nil {
 		return "0"
 	}
-	// Get the float representation and format it
-	f, _ := r.Float64()
-	// Format with appropriate precision
-	s := fmt.Sprintf("%.10f", f)
+	precision := formatRatPrecision
+	if places, ok := decimalPlaces(r); ok && places > precision {
+		precision = places
+	}
+	s := r.FloatString(precision)
 	// Trim trailing zeros after decimal point
 	for len(s) > 1 && s[len(s)-1] == '0' && s[len(s)-2] != '.' {
 		s = s[:len(s)-1]
@@ -1,31 +1,114 @@
 package domain
 
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
 // AllowedTransitions defines the valid state transitions.
 // The key is the current state, and the value is a slice of valid target states.
-var AllowedTransitions = map[string][]string{
+// It holds the built-in table unless SetTransitions replaced it.
+var AllowedTransitions = defaultTransitions
+
+// defaultTransitions is the built-in transition table.
+var defaultTransitions = map[string][]string{
 	StateInitiated: {
 		StateAuthorized,
 		StateVoided,
 		StateFailed,
+		StateDeclined,
 	},
 	StateAuthorized: {
+		StateAuthorized, // REAUTHORIZE
 		StatePreSettlementReview,
 		StateCaptured,
 		StateVoided,
+		StateReversed,
+		StateExpired,
 	},
 	StatePreSettlementReview: {
 		StateCaptured,
+		StateReversed,
+		StateExpired,
 	},
 	StateCaptured: {
 		StateSettled,
 		StateRefunded,
+		StateDisputed,
 	},
 	StateSettled: {
 		StateSettled, // Idempotent
+		StateDisputed,
 	},
-	StateVoided:   {}, // Terminal state
-	StateRefunded: {}, // Terminal state
-	StateFailed:   {}, // Terminal state
+	StateDisputed: {
+		StateCaptured, // DISPUTE_WON, back to the state the dispute was raised from
+		StateSettled,  // DISPUTE_WON
+		StateChargedBack,
+	},
+	StateVoided:      {}, // Terminal state
+	StateRefunded:    {}, // Terminal state
+	StateFailed:      {}, // Terminal state
+	StateReversed:    {}, // Terminal state
+	StateDeclined:    {}, // Terminal state
+	StateExpired:     {}, // Terminal state
+	StateChargedBack: {}, // Terminal state
+}
+
+// LoadTransitions reads a transition table from a JSON file mapping each state
+// to its allowed target states, e.g. {"INITIATED": ["AUTHORIZED", "VOIDED"]},
+// and validates it with ValidateTransitions.
+func LoadTransitions(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read transitions file: %w", err)
+	}
+	var table map[string][]string
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("invalid transitions file %s: %w", path, err)
+	}
+	if err := ValidateTransitions(table); err != nil {
+		return nil, fmt.Errorf("invalid transitions file %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// ValidateTransitions checks that a transition table only uses built-in state
+// names and defines an entry for every state it references as a target.
+func ValidateTransitions(table map[string][]string) error {
+	if len(table) == 0 {
+		return fmt.Errorf("transition table is empty")
+	}
+	from := make([]string, 0, len(table))
+	for state := range table {
+		from = append(from, state)
+	}
+	sort.Strings(from)
+	for _, state := range from {
+		if _, known := defaultTransitions[state]; !known {
+			return fmt.Errorf("unknown state: %s", state)
+		}
+		for _, to := range table[state] {
+			if _, known := defaultTransitions[to]; !known {
+				return fmt.Errorf("unknown state: %s (target of %s)", to, state)
+			}
+			if _, defined := table[to]; !defined {
+				return fmt.Errorf("state %s (target of %s) is not defined", to, state)
+			}
+		}
+	}
+	return nil
+}
+
+// SetTransitions replaces the transition table consulted by CanTransition,
+// ValidateTransition and the other state machine queries. A nil table restores
+// the built-in one. The table is not validated; see ValidateTransitions.
+func SetTransitions(table map[string][]string) {
+	if table == nil {
+		table = defaultTransitions
+	}
+	AllowedTransitions = table
 }
 
 // CanTransition checks if a transition from one state to another is allowed.
@@ -49,3 +132,155 @@ func ValidateTransition(from, to string) error {
 	}
 	return nil
 }
+
+// Predecessors returns the sorted list of states that can transition directly
+// into the target state. Self-loops (e.g. idempotent SETTLED) are excluded.
+func Predecessors(target string) ([]string, error) {
+	if _, exists := AllowedTransitions[target]; !exists {
+		return nil, fmt.Errorf("unknown state: %s", target)
+	}
+	var result []string
+	for from, allowed := range AllowedTransitions {
+		if from == target {
+			continue
+		}
+		for _, s := range allowed {
+			if s == target {
+				result = append(result, from)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// terminalStates are the states in which a payment's lifecycle is complete.
+// SETTLED is terminal even though it allows an idempotent self-transition and
+// can be reopened by a chargeback dispute.
+var terminalStates = map[string]bool{
+	StateSettled:     true,
+	StateVoided:      true,
+	StateRefunded:    true,
+	StateFailed:      true,
+	StateReversed:    true,
+	StateDeclined:    true,
+	StateExpired:     true,
+	StateChargedBack: true,
+}
+
+// IsTerminal reports whether the state ends the payment lifecycle.
+func IsTerminal(state string) bool {
+	return terminalStates[state]
+}
+
+// TerminalStates returns the sorted states that end the payment lifecycle by
+// design.
+func TerminalStates() []string {
+	states := make([]string, 0, len(terminalStates))
+	for state := range terminalStates {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// DeadEnds returns the sorted states of a transition table that have no way
+// out (no edge to another state) yet are not intended terminal states.
+// States that appear only as transition targets count as dead ends too.
+func DeadEnds(table map[string][]string) []string {
+	states := make(map[string]bool)
+	for from, targets := range table {
+		states[from] = true
+		for _, to := range targets {
+			states[to] = true
+		}
+	}
+
+	var deadEnds []string
+	for state := range states {
+		if IsTerminal(state) {
+			continue
+		}
+		hasExit := false
+		for _, to := range table[state] {
+			if to != state {
+				hasExit = true
+				break
+			}
+		}
+		if !hasExit {
+			deadEnds = append(deadEnds, state)
+		}
+	}
+	sort.Strings(deadEnds)
+	return deadEnds
+}
+
+// IsValidState reports whether state is one of the known payment states.
+func IsValidState(state string) bool {
+	_, exists := AllowedTransitions[state]
+	return exists
+}
+
+// bypassActions are history actions that change state outside the regular
+// transition table (see SetFailed, Unsettle, ExpireReview and RestoreState).
+var bypassActions = map[string]bool{
+	"FAIL":     true,
+	"UNSETTLE": true,
+	"EXPIRE":   true,
+	"IMPORT":   true,
+}
+
+// bypassEdges are single edges outside the regular transition table that
+// only the named action may take (see ReauthorizeReview and
+// RecordPartialRefund).
+var bypassEdges = map[string][2]string{
+	"REAUTHORIZE": {StatePreSettlementReview, StateAuthorized},
+	"REFUND":      {StateCaptured, StateCaptured},
+}
+
+// isBypass reports whether a history entry changed state outside the
+// regular transition table.
+func isBypass(entry HistoryEntry) bool {
+	if bypassActions[entry.Action] {
+		return true
+	}
+	edge, ok := bypassEdges[entry.Action]
+	return ok && entry.FromState == edge[0] && entry.ToState == edge[1]
+}
+
+// HistoryViolations checks that the payment's history forms a valid chain of
+// transitions ending in its current state, and returns a description of each
+// problem found.
+func (p *Payment) HistoryViolations() []string {
+	if len(p.History) == 0 {
+		return []string{"history is empty"}
+	}
+
+	var violations []string
+	for i, entry := range p.History {
+		if i == 0 {
+			if entry.FromState != "" || entry.ToState != StateInitiated {
+				violations = append(violations, fmt.Sprintf("entry #1 %s %s->%s does not create the payment",
+					entry.Action, entry.FromState, entry.ToState))
+			}
+			continue
+		}
+		prev := p.History[i-1]
+		if entry.FromState != prev.ToState {
+			violations = append(violations, fmt.Sprintf("entry #%d %s starts from %s, previous entry ended in %s",
+				i+1, entry.Action, entry.FromState, prev.ToState))
+		}
+		if !isBypass(entry) && !CanTransition(entry.FromState, entry.ToState) {
+			violations = append(violations, fmt.Sprintf("entry #%d %s %s->%s is not an allowed transition",
+				i+1, entry.Action, entry.FromState, entry.ToState))
+		}
+	}
+
+	last := p.History[len(p.History)-1]
+	if p.State != last.ToState {
+		violations = append(violations, fmt.Sprintf("state %s does not match last history entry %s", p.State, last.ToState))
+	}
+	return violations
+}
@@ -15,17 +15,95 @@ type Command struct {
 // commandArgCounts defines the number of REQUIRED arguments for each command.
 // Optional arguments are not counted here.
 var commandArgCounts = map[string]int{
-	"CREATE":     4, // <payment_id> <amount> <currency> <merchant_id>
-	"AUTHORIZE":  1, // <payment_id>
-	"CAPTURE":    1, // <payment_id>
-	"VOID":       1, // <payment_id> [reason_code] - 1 required
-	"REFUND":     1, // <payment_id> [amount] - 1 required
-	"SETTLE":     1, // <payment_id>
-	"SETTLEMENT": 1, // <batch_id>
-	"STATUS":     1, // <payment_id>
-	"LIST":       0,
-	"AUDIT":      1, // <payment_id>
-	"EXIT":       0,
+	"CREATE":              4, // <payment_id> <amount> <currency> <merchant_id>
+	"AUTHORIZE":           1, // <payment_id> [DECLINE [reason]]
+	"REAUTHORIZE":         1, // <payment_id>
+	"DISPUTE":             2, // <payment_id> <reason_code>
+	"DISPUTE_WON":         1, // <payment_id>
+	"DISPUTE_LOST":        1, // <payment_id>
+	"CAPTURE":             1, // <payment_id> [amount] - 1 required
+	"VOID":                1, // <payment_id> [reason_code] - 1 required
+	"DELETE":              1, // <payment_id>
+	"REFUND":              1, // <payment_id> [amount] [reason_code] - 1 required
+	"SETTLE":              1, // <payment_id> [batch_id] - 1 required
+	"SETTLEMENT":          1, // <batch_id>
+	"UNSETTLE":            1, // <batch_id>
+	"STATUS":              1, // <payment_id>
+	"LIST":                0,
+	"AUDIT":               1, // <payment_id>
+	"PATHS-TO":            1, // <state>
+	"GENERATE":            2, // <count> <prefix>
+	"AUDIT-MONEY":         1, // <payment_id>
+	"CHECKPOINT":          1, // <name>
+	"SNAPSHOT":            1, // <name> - alias of CHECKPOINT
+	"RESTORE":             1, // <name>
+	"VERIFY":              0,
+	"STATEMENT":           1, // <merchant_id>
+	"RETRY":               0,
+	"INCLUDE":             1, // <file>
+	"ASSERT-ALL-TERMINAL": 0,
+	"EXPORT":              1, // <format>
+	"IMPORT":              1, // <file>
+	"AUDIT-EXPORT":        1, // <payment_id> [path]
+	"SUMMARY":             0,
+	"RENAME-BATCH":        2, // <old_id> <new_id>
+	"HISTORY":             1, // <payment_id> [limit]
+	"VERIFY-HISTORY":      0,
+	"STATS":               0,
+	"REFUND-REASONS":      0,
+	"TRANSITION-STATS":    0,
+	"REFUNDABLE":          1, // <payment_id>
+	"REVERSE":             1, // <payment_id>
+	"TICK":                1, // <duration>
+	"SWEEP":               0,
+	"OLDEST-OPEN":         0,
+	"CHECK-CURRENCY":      1, // <merchant_id>
+	"CAPTURE-RATE":        1, // <merchant_id>
+	"WHY-REVIEW":          1, // <payment_id>
+	"VOID-STATS":          0,
+	"CHANGED-SINCE":       1, // <rfc3339>
+	"BENCH":               2, // <op> <count>
+	"ADJUST":              3, // <payment_id> <signed_amount> <reason>
+	"DEADENDS":            0,
+	"VALIDATE-BATCH":      1, // <batch_id>
+	"SETTLE-LAG":          0,
+	"POSITION":            0,
+	"BEGIN":               0,
+	"COMMIT":              0,
+	"ROLLBACK":            0,
+	"EXIT":                0,
+}
+
+// readOnlyCommands lists the commands that never mutate the store and whose
+// output is a query result. Commands whose output is the data itself, such
+// as EXPORT and AUDIT-EXPORT (which may also write a file), are left out so
+// -quiet-reads never hides it.
+var readOnlyCommands = map[string]bool{
+	"STATUS":              true,
+	"LIST":                true,
+	"AUDIT":               true,
+	"PATHS-TO":            true,
+	"AUDIT-MONEY":         true,
+	"VERIFY":              true,
+	"STATEMENT":           true,
+	"ASSERT-ALL-TERMINAL": true,
+	"SUMMARY":             true,
+	"HISTORY":             true,
+	"VERIFY-HISTORY":      true,
+	"STATS":               true,
+	"REFUND-REASONS":      true,
+	"TRANSITION-STATS":    true,
+	"REFUNDABLE":          true,
+	"OLDEST-OPEN":         true,
+	"CHECK-CURRENCY":      true,
+	"CAPTURE-RATE":        true,
+	"WHY-REVIEW":          true,
+	"VOID-STATS":          true,
+	"CHANGED-SINCE":       true,
+	"DEADENDS":            true,
+	"VALIDATE-BATCH":      true,
+	"SETTLE-LAG":          true,
+	"POSITION":            true,
 }
 
 // Parse parses a command line into a Command struct.
@@ -44,8 +122,9 @@ func Parse(line string) (*Command, error) {
 		return nil, fmt.Errorf("empty input")
 	}
 
-	// First token is the command name
-	cmdName := tokens[0]
+	// First token is the command name, matched case-insensitively;
+	// arguments keep their case
+	cmdName := strings.ToUpper(tokens[0])
 
 	// Check if command is known
 	requiredArgs, known := commandArgCounts[cmdName]
@@ -117,14 +196,29 @@ func extractArgs(tokens []string, requiredCount int, cmdName string) ([]string,
 	return args, nil
 }
 
-// IsValidCommand checks if a command name is valid.
+// KeyValue splits a "key=value" filter token. It reports false for tokens
+// without '=' or with an empty key, which are positional arguments.
+func KeyValue(token string) (key, value string, ok bool) {
+	key, value, found := strings.Cut(token, "=")
+	if !found || key == "" {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+// IsValidCommand checks if a command name is valid, ignoring case.
 func IsValidCommand(name string) bool {
-	_, ok := commandArgCounts[name]
+	_, ok := commandArgCounts[strings.ToUpper(name)]
 	return ok
 }
 
 // GetRequiredArgCount returns the number of required arguments for a command.
 func GetRequiredArgCount(name string) (int, bool) {
-	count, ok := commandArgCounts[name]
+	count, ok := commandArgCounts[strings.ToUpper(name)]
 	return count, ok
 }
+
+// IsReadOnly reports whether a command only reads state and never mutates it.
+func IsReadOnly(name string) bool {
+	return readOnlyCommands[name]
+}
@@ -53,6 +53,18 @@ func TestParse_ValidCommands(t *testing.T) {
 			wantName: "VOID",
 			wantArgs: []string{"P1001", "FRAUD"},
 		},
+		{
+			name:     "lowercase command keeps argument case",
+			input:    "create p1 10 usd m1",
+			wantName: "CREATE",
+			wantArgs: []string{"p1", "10", "usd", "m1"},
+		},
+		{
+			name:     "mixed-case command",
+			input:    "Void P1001 fraud",
+			wantName: "VOID",
+			wantArgs: []string{"P1001", "fraud"},
+		},
 		{
 			name:     "REFUND without amount",
 			input:    "REFUND P1001",
@@ -247,14 +259,14 @@ func TestParse_EdgeCases(t *testing.T) {
 }
 
 func TestIsValidCommand(t *testing.T) {
-	validCommands := []string{"CREATE", "AUTHORIZE", "CAPTURE", "VOID", "REFUND", "SETTLE", "SETTLEMENT", "STATUS", "LIST", "AUDIT", "EXIT"}
+	validCommands := []string{"CREATE", "AUTHORIZE", "CAPTURE", "VOID", "DELETE", "REFUND", "SETTLE", "SETTLEMENT", "STATUS", "LIST", "AUDIT", "EXIT", "create", "Authorize"}
 	for _, cmd := range validCommands {
 		if !IsValidCommand(cmd) {
 			t.Errorf("IsValidCommand(%s) = false, want true", cmd)
 		}
 	}
 
-	invalidCommands := []string{"create", "INVALID", "DELETE", ""}
+	invalidCommands := []string{"INVALID", "REMOVE", "remove", ""}
 	for _, cmd := range invalidCommands {
 		if IsValidCommand(cmd) {
 			t.Errorf("IsValidCommand(%s) = true, want false", cmd)
@@ -282,3 +294,37 @@ func TestGetRequiredArgCount(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyValue(t *testing.T) {
+	tests := []struct {
+		token      string
+		key, value string
+		ok         bool
+	}{
+		{"state=CAPTURED", "state", "CAPTURED", true},
+		{"merchant=", "merchant", "", true},
+		{"note=a=b", "note", "a=b", true},
+		{"=USD", "", "", false},
+		{"COLUMNS", "", "", false},
+	}
+
+	for _, tt := range tests {
+		key, value, ok := KeyValue(tt.token)
+		if key != tt.key || value != tt.value || ok != tt.ok {
+			t.Errorf("KeyValue(%q) = (%q, %q, %v), want (%q, %q, %v)", tt.token, key, value, ok, tt.key, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	for _, cmd := range []string{"STATUS", "LIST", "AUDIT"} {
+		if !IsReadOnly(cmd) {
+			t.Errorf("IsReadOnly(%s) = false, want true", cmd)
+		}
+	}
+	for _, cmd := range []string{"CREATE", "AUTHORIZE", "SETTLEMENT", "EXPORT", "AUDIT-EXPORT", "UNKNOWN"} {
+		if IsReadOnly(cmd) {
+			t.Errorf("IsReadOnly(%s) = true, want false", cmd)
+		}
+	}
+}
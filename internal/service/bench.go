@@ -0,0 +1,102 @@
+package service
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"payment-sim/internal/domain"
+	"payment-sim/internal/store"
+)
+
+// benchSeedSize is how many payments a scratch store holds before a GET or
+// LIST benchmark starts.
+const benchSeedSize = 100
+
+// maxBenchCount is the most operations one BENCH may time. BENCH holds the
+// processor lock throughout, and a FileStore rewrites its file on every
+// CREATE.
+const maxBenchCount = 500
+
+// ScratchStoreFunc creates an empty store of the configured backend for
+// BENCH, along with a function that disposes of it.
+type ScratchStoreFunc func() (store.Repository, func(), error)
+
+// SetBenchStore sets how BENCH creates its scratch store, so it measures
+// the same backend as the main store (default: a MemoryStore).
+func (p *Processor) SetBenchStore(newStore ScratchStoreFunc) {
+	p.benchStore = newStore
+}
+
+// benchOps are the store operations BENCH can time. Each runs operation i
+// against the scratch store.
+var benchOps = map[string]func(s store.Repository, i int) error{
+	"CREATE": func(s store.Repository, i int) error {
+		return s.Save(domain.NewPayment(fmt.Sprintf("BENCH%d", i), big.NewRat(10, 1), "USD", "BENCH"))
+	},
+	"GET": func(s store.Repository, i int) error {
+		_, err := s.Get(fmt.Sprintf("SEED%d", i%benchSeedSize))
+		return err
+	},
+	"LIST": func(s store.Repository, i int) error {
+		_, err := s.List()
+		return err
+	},
+}
+
+// handleBench handles the BENCH command.
+// It times count store operations against a scratch store of the configured
+// backend, leaving the main store untouched, and reports the throughput. Wall-clock time is
+// used even when the processor clock is simulated.
+//
+//	BENCH <create|get|list> <count>
+func (p *Processor) handleBench(args []string) (*Result, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("BENCH requires op and count")
+	}
+
+	name := strings.ToUpper(args[0])
+	op, ok := benchOps[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown BENCH op: %s (expected create, get or list)", args[0])
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("BENCH count must be a positive integer: %s", args[1])
+	}
+	if count > maxBenchCount {
+		return nil, fmt.Errorf("BENCH count must be at most %d: %s", maxBenchCount, args[1])
+	}
+
+	var scratch store.Repository = store.NewMemoryStore()
+	if p.benchStore != nil {
+		s, cleanup, err := p.benchStore()
+		if err != nil {
+			return nil, fmt.Errorf("BENCH cannot create scratch store: %v", err)
+		}
+		defer cleanup()
+		scratch = s
+	}
+	for i := 0; i < benchSeedSize; i++ {
+		if err := scratch.Save(domain.NewPayment(fmt.Sprintf("SEED%d", i), big.NewRat(10, 1), "USD", "BENCH")); err != nil {
+			return nil, fmt.Errorf("BENCH cannot seed scratch store: %v", err)
+		}
+	}
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if err := op(scratch, i); err != nil {
+			return nil, fmt.Errorf("BENCH %s failed at op %d: %v", name, i+1, err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+
+	opsPerSec := float64(count) / elapsed.Seconds()
+	return newReportResult("BENCH", "measured",
+		fmt.Sprintf("BENCH %s: %d ops in %s (%.0f ops/sec)", name, count, elapsed, opsPerSec)), nil
+}
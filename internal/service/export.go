@@ -0,0 +1,438 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"payment-sim/internal/domain"
+	"payment-sim/internal/store"
+)
+
+// csvHeader is the column layout written by EXPORT CSV and read by IMPORT.
+var csvHeader = []string{"id", "amount", "currency", "merchant_id", "state", "captured", "refunded", "batch_id", "void_reason"}
+
+// jsonStore is the document written by EXPORT JSON.
+type jsonStore struct {
+	Payments []jsonPayment `json:"payments"`
+	BatchIDs []string      `json:"batch_ids"`
+}
+
+// jsonPayment is the EXPORT JSON form of a payment. Amounts are decimal
+// strings with exactly the currency's minor units.
+type jsonPayment struct {
+	ID             string             `json:"id"`
+	Amount         string             `json:"amount"`
+	Currency       string             `json:"currency"`
+	MerchantID     string             `json:"merchant_id"`
+	State          string             `json:"state"`
+	VoidReason     string             `json:"void_reason,omitempty"`
+	DeclineReason  string             `json:"decline_reason,omitempty"`
+	DisputeReason  string             `json:"dispute_reason,omitempty"`
+	CapturedAmount string             `json:"captured_amount,omitempty"`
+	RefundedAmount string             `json:"refunded_amount,omitempty"`
+	BatchID        string             `json:"batch_id,omitempty"`
+	Movements      []jsonMovement     `json:"movements,omitempty"`
+	Adjustments    []jsonAdjustment   `json:"adjustments,omitempty"`
+	History        []jsonHistoryEntry `json:"history"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// jsonMovement is the EXPORT JSON form of a money movement.
+type jsonMovement struct {
+	Kind     string `json:"kind"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// jsonAdjustment is the EXPORT JSON form of an adjustment.
+type jsonAdjustment struct {
+	Amount    string    `json:"amount"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// jsonHistoryEntry is the EXPORT JSON form of a history entry.
+type jsonHistoryEntry struct {
+	Seq       int       `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	FromState string    `json:"from_state"`
+	ToState   string    `json:"to_state"`
+	Action    string    `json:"action"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// jsonEvent is one line of EXPORT EVENTS: a history entry attributed to its
+// payment.
+type jsonEvent struct {
+	PaymentID string `json:"payment_id"`
+	jsonHistoryEntry
+}
+
+// newJSONHistoryEntry converts a history entry to its EXPORT JSON form.
+func newJSONHistoryEntry(entry domain.HistoryEntry) jsonHistoryEntry {
+	return jsonHistoryEntry{
+		Seq:       entry.Seq,
+		Timestamp: entry.Timestamp,
+		FromState: entry.FromState,
+		ToState:   entry.ToState,
+		Action:    entry.Action,
+		Details:   entry.Details,
+	}
+}
+
+// newJSONPayment converts a payment to its EXPORT JSON form.
+func newJSONPayment(payment *domain.Payment) jsonPayment {
+	jp := jsonPayment{
+		ID:            payment.ID,
+		Amount:        domain.FormatMoney(payment.Amount, payment.Currency),
+		Currency:      payment.Currency,
+		MerchantID:    payment.MerchantID,
+		State:         payment.State,
+		VoidReason:    payment.VoidReason,
+		DeclineReason: payment.DeclineReason,
+		DisputeReason: payment.DisputeReason,
+		BatchID:       payment.BatchID,
+		History:       make([]jsonHistoryEntry, len(payment.History)),
+		CreatedAt:     payment.CreatedAt,
+		UpdatedAt:     payment.UpdatedAt,
+	}
+	jp.CapturedAmount = formatOptionalMoney(payment.CapturedAmount, payment.Currency)
+	jp.RefundedAmount = formatOptionalMoney(payment.RefundedAmount, payment.Currency)
+	for _, m := range payment.Movements {
+		jp.Movements = append(jp.Movements, jsonMovement{
+			Kind:     m.Kind,
+			Amount:   domain.FormatMoney(m.Amount, m.Currency),
+			Currency: m.Currency,
+			Reason:   m.Reason,
+		})
+	}
+	for _, a := range payment.Adjustments {
+		jp.Adjustments = append(jp.Adjustments, jsonAdjustment{
+			Amount:    domain.FormatMoney(a.Amount, payment.Currency),
+			Reason:    a.Reason,
+			Timestamp: a.Timestamp,
+		})
+	}
+	for i, entry := range payment.History {
+		jp.History[i] = newJSONHistoryEntry(entry)
+	}
+	return jp
+}
+
+// handleExport handles the EXPORT command.
+//
+//	EXPORT CSV
+//	EXPORT JSON
+//	EXPORT EVENTS
+func (p *Processor) handleExport(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("EXPORT requires a format")
+	}
+
+	switch args[0] {
+	case "CSV":
+		return p.exportCSV()
+	case "JSON":
+		return p.exportJSON()
+	case "EVENTS":
+		return p.exportEvents()
+	default:
+		return nil, fmt.Errorf("unknown EXPORT format: %s", args[0])
+	}
+}
+
+// exportCSV renders every payment as a CSV row that IMPORT can read back.
+func (p *Processor) exportCSV() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	for _, payment := range payments {
+		w.Write([]string{
+			payment.ID,
+			domain.FormatMoney(payment.Amount, payment.Currency),
+			payment.Currency,
+			payment.MerchantID,
+			payment.State,
+			formatOptionalMoney(payment.CapturedAmount, payment.Currency),
+			formatOptionalMoney(payment.RefundedAmount, payment.Currency),
+			payment.BatchID,
+			payment.VoidReason,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %v", err)
+	}
+
+	return newReportResult("EXPORT", "exported", strings.TrimSuffix(buf.String(), "\n")), nil
+}
+
+// exportJSON renders the whole store, including history and batch IDs, as a
+// single JSON document.
+func (p *Processor) exportJSON() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	doc := jsonStore{
+		Payments: make([]jsonPayment, len(payments)),
+		BatchIDs: p.reads.GetBatchIDs(),
+	}
+	for i, payment := range payments {
+		doc.Payments[i] = newJSONPayment(payment)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %v", err)
+	}
+	return newReportResult("EXPORT", "exported", string(data)), nil
+}
+
+// exportEvents renders every history entry of every payment as one JSON
+// object per line, ordered by timestamp (ties by payment ID, then Seq).
+func (p *Processor) exportEvents() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var events []jsonEvent
+	for _, payment := range payments {
+		for _, entry := range payment.History {
+			events = append(events, jsonEvent{PaymentID: payment.ID, jsonHistoryEntry: newJSONHistoryEntry(entry)})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.PaymentID != b.PaymentID {
+			return a.PaymentID < b.PaymentID
+		}
+		return a.Seq < b.Seq
+	})
+
+	lines := make([]string, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event: %v", err)
+		}
+		lines[i] = string(data)
+	}
+	return newReportResult("EXPORT", "exported", strings.Join(lines, "\n")), nil
+}
+
+// formatOptionalMoney formats r with the currency's minor units, rendering
+// nil as an empty field. Exports use it instead of the display FormatRat so
+// that accounting tools see e.g. "100.50" rather than "100.5".
+func formatOptionalMoney(r *big.Rat, currency string) string {
+	if r == nil {
+		return ""
+	}
+	return domain.FormatMoney(r, currency)
+}
+
+// handleImport handles the IMPORT command.
+// It recreates payments from an EXPORT CSV file, setting each payment's state
+// directly. The import is all-or-nothing: any row error rejects the file.
+func (p *Processor) handleImport(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("IMPORT requires a file path")
+	}
+
+	path := args[0]
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open import file: %v", err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV in %s: %v", path, err)
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		return nil, fmt.Errorf("invalid CSV in %s: expected header %s", path, strings.Join(csvHeader, ","))
+	}
+
+	var payments []*domain.Payment
+	var rowErrors []string
+	seen := make(map[string]bool)
+	for i, row := range rows[1:] {
+		payment, err := p.importRow(row)
+		if err == nil && (seen[payment.ID] || p.store.Exists(payment.ID)) {
+			err = fmt.Errorf("payment %s already exists", payment.ID)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("row %d: %v", i+2, err))
+			continue
+		}
+		seen[payment.ID] = true
+		payments = append(payments, payment)
+	}
+	if len(rowErrors) > 0 {
+		return nil, fmt.Errorf("IMPORT %s failed, nothing imported:\n  %s", path, strings.Join(rowErrors, "\n  "))
+	}
+
+	// A store error midway rolls back the rows already saved
+	snapshotter, ok := p.store.(store.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("store does not support transactions")
+	}
+	snapshot, err := snapshotter.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := p.saveImported(payments); err != nil {
+		if rerr := snapshotter.Restore(snapshot); rerr != nil {
+			return nil, fmt.Errorf("failed to roll back transaction: %v", rerr)
+		}
+		return nil, fmt.Errorf("IMPORT %s failed, nothing imported: %v", path, err)
+	}
+	for _, payment := range payments {
+		p.emitTransition(payment)
+	}
+
+	return newReportResult("IMPORT", "imported",
+		fmt.Sprintf("IMPORT %s: %d payment(s) imported", path, len(payments))), nil
+}
+
+// saveImported saves the imported payments and records their batch IDs,
+// stopping at the first store error.
+func (p *Processor) saveImported(payments []*domain.Payment) error {
+	for _, payment := range payments {
+		if payment.BatchID != "" {
+			if err := p.store.RecordBatchID(payment.BatchID); err != nil {
+				return fmt.Errorf("failed to record batch %s: %v", payment.BatchID, err)
+			}
+		}
+		if err := p.save(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importRow builds a payment from one EXPORT CSV row.
+func (p *Processor) importRow(row []string) (*domain.Payment, error) {
+	if len(row) != len(csvHeader) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(csvHeader), len(row))
+	}
+	id, amountStr, currency, merchantID, state := row[0], row[1], row[2], row[3], row[4]
+	captured, refunded, batchID, voidReason := row[5], row[6], row[7], row[8]
+
+	if id == "" {
+		return nil, fmt.Errorf("payment_id cannot be empty")
+	}
+	if !domain.IsValidCurrency(currency) {
+		return nil, domain.NewValidationError("currency", fmt.Sprintf("unknown ISO 4217 currency code: %s", currency))
+	}
+	if merchantID == "" {
+		return nil, fmt.Errorf("merchant_id cannot be empty")
+	}
+	amount, err := domain.ParseAmount(amountStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount: %v", err)
+	}
+
+	payment := domain.NewPaymentWithClock(id, amount, currency, merchantID, p.clock)
+	if err := payment.RestoreState(state); err != nil {
+		return nil, err
+	}
+	if captured != "" {
+		capturedAmount, err := domain.ParseAmount(captured)
+		if err != nil {
+			return nil, fmt.Errorf("invalid captured amount: %v", err)
+		}
+		if err := payment.RecordCapture(capturedAmount, currency); err != nil {
+			return nil, err
+		}
+	}
+	if refunded != "" {
+		refundedAmount, err := domain.ParseAmount(refunded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refunded amount: %v", err)
+		}
+		if err := payment.RecordRefund(refundedAmount, currency); err != nil {
+			return nil, err
+		}
+	}
+	if batchID != "" {
+		payment.AssignBatch(batchID)
+	}
+	payment.SetVoidReason(voidReason)
+	return payment, nil
+}
+
+// handleAuditExport handles the AUDIT-EXPORT command.
+// It renders a payment's identifiers and numbered history for compliance
+// case files, optionally writing the report to a file.
+//
+//	AUDIT-EXPORT <payment_id> [path]
+func (p *Processor) handleAuditExport(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("AUDIT-EXPORT requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	report := formatAuditTrail(payment)
+	if len(args) < 2 {
+		return newPaymentResult("AUDIT-EXPORT", "exported", payment, report), nil
+	}
+
+	path := args[1]
+	if err := os.WriteFile(path, []byte(report+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("cannot write audit export: %v", err)
+	}
+	return newPaymentResult("AUDIT-EXPORT", "written", payment,
+		fmt.Sprintf("AUDIT-EXPORT %s written to %s (%d events)", paymentID, path, len(payment.History))), nil
+}
+
+// formatAuditTrail renders the compliance report for a payment.
+func formatAuditTrail(payment *domain.Payment) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Audit trail for payment %s\n", payment.ID))
+	sb.WriteString(fmt.Sprintf("  Merchant: %s\n", payment.MerchantID))
+	sb.WriteString(fmt.Sprintf("  Amount:   %s %s\n", payment.FormatAmount(), payment.Currency))
+	sb.WriteString(fmt.Sprintf("  State:    %s\n", payment.State))
+	if payment.BatchID != "" {
+		sb.WriteString(fmt.Sprintf("  Batch:    %s\n", payment.BatchID))
+	}
+	if payment.VoidReason != "" {
+		sb.WriteString(fmt.Sprintf("  Void reason: %s\n", payment.VoidReason))
+	}
+	if payment.DeclineReason != "" {
+		sb.WriteString(fmt.Sprintf("  Decline reason: %s\n", payment.DeclineReason))
+	}
+	if payment.DisputeReason != "" {
+		sb.WriteString(fmt.Sprintf("  Dispute reason: %s\n", payment.DisputeReason))
+	}
+	sb.WriteString("Events:")
+	for i, entry := range payment.History {
+		sb.WriteString(fmt.Sprintf("\n  %d. %s", i+1, formatHistoryEntry(entry)))
+	}
+	return sb.String()
+}
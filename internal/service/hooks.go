@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"payment-sim/internal/domain"
+)
+
+// TransitionEvent describes a single payment state change.
+type TransitionEvent struct {
+	PaymentID string
+	From      string
+	To        string
+	Action    string
+}
+
+// TransitionHook is called after every payment state change, including
+// cascaded ones such as the automatic move to PRE_SETTLEMENT_REVIEW. Hooks
+// run while the processor is locked and must not call back into it.
+type TransitionHook func(event TransitionEvent)
+
+// AddTransitionHook registers a hook to be called on every state change.
+func (p *Processor) AddTransitionHook(hook TransitionHook) {
+	p.transitionHooks = append(p.transitionHooks, hook)
+}
+
+// transition moves the payment to a new state and notifies the hooks.
+func (p *Processor) transition(payment *domain.Payment, newState, action, details string) error {
+	if err := payment.TransitionTo(newState, action, details); err != nil {
+		return err
+	}
+	p.emitTransition(payment)
+	return nil
+}
+
+// emitTransition publishes the payment's most recent state change, or
+// holds it back while a batch is in progress.
+func (p *Processor) emitTransition(payment *domain.Payment) {
+	if len(payment.History) == 0 {
+		return
+	}
+	entry := payment.History[len(payment.History)-1]
+	event := TransitionEvent{
+		PaymentID: payment.ID,
+		From:      entry.FromState,
+		To:        entry.ToState,
+		Action:    entry.Action,
+	}
+	if p.pendingEvents != nil {
+		*p.pendingEvents = append(*p.pendingEvents, event)
+		return
+	}
+	p.publish(event)
+}
+
+// publish tallies a state change and notifies the hooks.
+func (p *Processor) publish(event TransitionEvent) {
+	p.edgeCounts[event.From+"->"+event.To]++
+	for _, hook := range p.transitionHooks {
+		hook(event)
+	}
+}
+
+// handleTransitionStats handles the TRANSITION-STATS command.
+// It reports how often each transition edge was taken this session.
+func (p *Processor) handleTransitionStats() (*Result, error) {
+	if len(p.edgeCounts) == 0 {
+		return newReportResult("TRANSITION-STATS", "empty", "No transitions recorded"), nil
+	}
+
+	edges := make([]string, 0, len(p.edgeCounts))
+	for edge := range p.edgeCounts {
+		edges = append(edges, edge)
+	}
+	sort.Strings(edges)
+
+	var sb strings.Builder
+	sb.WriteString("Transition counts:")
+	for _, edge := range edges {
+		sb.WriteString(fmt.Sprintf("\n  %s: %d", edge, p.edgeCounts[edge]))
+	}
+	return newReportResult("TRANSITION-STATS", "reported", sb.String()), nil
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"payment-sim/internal/domain"
+	"payment-sim/internal/parser"
+)
+
+// listColumns maps the column names accepted by LIST COLUMNS to accessors.
+var listColumns = map[string]func(p *domain.Payment) string{
+	"id":       func(p *domain.Payment) string { return p.ID },
+	"state":    func(p *domain.Payment) string { return p.State },
+	"amount":   func(p *domain.Payment) string { return p.FormatAmount() },
+	"currency": func(p *domain.Payment) string { return p.Currency },
+	"merchant": func(p *domain.Payment) string { return p.MerchantID },
+	"batch":    func(p *domain.Payment) string { return p.BatchID },
+}
+
+// listSortKeys maps the keys accepted by LIST SORT to comparisons. Ties are
+// broken by ID in sortPayments.
+var listSortKeys = map[string]func(a, b *domain.Payment) int{
+	"id":       func(a, b *domain.Payment) int { return strings.Compare(a.ID, b.ID) },
+	"amount":   func(a, b *domain.Payment) int { return a.Amount.Cmp(b.Amount) },
+	"created":  func(a, b *domain.Payment) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"updated":  func(a, b *domain.Payment) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+	"state":    func(a, b *domain.Payment) int { return strings.Compare(a.State, b.State) },
+	"merchant": func(a, b *domain.Payment) int { return strings.Compare(a.MerchantID, b.MerchantID) },
+}
+
+// sortPayments sorts payments by cmp, breaking ties by ID so that the order
+// never depends on how the payments were stored or listed.
+func sortPayments(payments []*domain.Payment, cmp func(a, b *domain.Payment) int) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		if c := cmp(payments[i], payments[j]); c != 0 {
+			return c < 0
+		}
+		return payments[i].ID < payments[j].ID
+	})
+}
+
+// listFilterKeys are the keys accepted as LIST key=value filters.
+var listFilterKeys = []string{"currency", "merchant", "state"}
+
+// listFilter selects the payments shown by LIST. Empty fields match every
+// payment; set fields must all match.
+type listFilter struct {
+	state    string
+	merchant string
+	currency string
+}
+
+// set records a key=value filter, normalizing states and currencies.
+func (f *listFilter) set(key, value string) error {
+	switch key {
+	case "state":
+		state := strings.ToUpper(value)
+		if !domain.IsValidState(state) {
+			return fmt.Errorf("unknown state in LIST filter: %s", value)
+		}
+		f.state = state
+	case "merchant":
+		f.merchant = value
+	case "currency":
+		f.currency = domain.NormalizeCurrency(value)
+	default:
+		return fmt.Errorf("unknown LIST filter: %s (valid: %s)", key, strings.Join(listFilterKeys, ", "))
+	}
+	return nil
+}
+
+// matches reports whether the payment satisfies every set filter.
+func (f listFilter) matches(payment *domain.Payment) bool {
+	return (f.state == "" || payment.State == f.state) &&
+		(f.merchant == "" || payment.MerchantID == f.merchant) &&
+		(f.currency == "" || payment.Currency == f.currency)
+}
+
+// listOptions holds the parsed optional arguments of LIST.
+type listOptions struct {
+	columns []string // nil selects the default format
+	sortKey string   // "" keeps the store's ID order
+	filter  listFilter
+	limit   int  // 0 shows every remaining payment
+	offset  int  // number of payments skipped
+	paged   bool // limit or offset was given
+}
+
+// parsePageArg parses a LIST limit or offset, which must be a non-negative
+// integer.
+func parsePageArg(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, domain.NewValidationError(key, fmt.Sprintf("must be a non-negative integer: %s", value))
+	}
+	return n, nil
+}
+
+// parseListOptions parses the optional LIST arguments:
+//
+//	LIST [COLUMNS <col,col,...>] [SORT <key>] [key=value ...]
+//
+// where key is a filter (state, merchant or currency) or limit/offset.
+func parseListOptions(args []string) (*listOptions, error) {
+	opts := &listOptions{}
+	for i := 0; i < len(args); i++ {
+		if key, value, ok := parser.KeyValue(args[i]); ok {
+			var err error
+			switch key {
+			case "limit":
+				opts.limit, err = parsePageArg(key, value)
+				opts.paged = true
+			case "offset":
+				opts.offset, err = parsePageArg(key, value)
+				opts.paged = true
+			default:
+				err = opts.filter.set(key, value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch args[i] {
+		case "COLUMNS":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("LIST COLUMNS requires a column list")
+			}
+			i++
+			columns, err := parseColumns(args[i])
+			if err != nil {
+				return nil, err
+			}
+			opts.columns = columns
+		case "SORT":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("LIST SORT requires a sort key")
+			}
+			i++
+			if _, ok := listSortKeys[args[i]]; !ok {
+				return nil, fmt.Errorf("unknown LIST sort key: %s (valid: %s)", args[i], strings.Join(sortKeyNames(), ", "))
+			}
+			opts.sortKey = args[i]
+		default:
+			return nil, fmt.Errorf("unknown LIST option: %s", args[i])
+		}
+	}
+	return opts, nil
+}
+
+// parseColumns validates a comma-separated column spec.
+func parseColumns(spec string) ([]string, error) {
+	columns := strings.Split(spec, ",")
+	for _, c := range columns {
+		if _, ok := listColumns[c]; !ok {
+			return nil, fmt.Errorf("unknown LIST column: %s (valid: %s)", c, strings.Join(columnNames(), ", "))
+		}
+	}
+	return columns, nil
+}
+
+// columnNames returns the valid column names in sorted order.
+func columnNames() []string {
+	names := make([]string, 0, len(listColumns))
+	for name := range listColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortKeyNames returns the valid LIST SORT keys in sorted order.
+func sortKeyNames() []string {
+	names := make([]string, 0, len(listSortKeys))
+	for name := range listSortKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleList handles the LIST command.
+func (p *Processor) handleList(args []string) (*Result, error) {
+	opts, err := parseListOptions(args)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	filtered := payments[:0]
+	for _, payment := range payments {
+		if opts.filter.matches(payment) {
+			filtered = append(filtered, payment)
+		}
+	}
+	payments = filtered
+
+	if len(payments) == 0 {
+		return newReportResult("LIST", "empty", "No payments found"), nil
+	}
+
+	if opts.sortKey != "" {
+		sortPayments(payments, listSortKeys[opts.sortKey])
+	}
+
+	total := len(payments)
+	if opts.paged {
+		payments = pagePayments(payments, opts.offset, opts.limit)
+	}
+
+	// Truncate long identifiers on copies so stored payments are untouched
+	if p.listColWidth > 0 {
+		truncated := make([]*domain.Payment, len(payments))
+		for i, payment := range payments {
+			truncated[i] = truncatePayment(payment, p.listColWidth)
+		}
+		payments = truncated
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Payments:\n")
+	if opts.columns != nil {
+		for _, payment := range payments {
+			sb.WriteString("  " + formatColumns(payment, opts.columns) + "\n")
+		}
+	} else if p.listAlign > 0 {
+		writeAlignedRows(&sb, payments, p.listAlign)
+	} else {
+		for _, payment := range payments {
+			sb.WriteString(fmt.Sprintf("  %s: state=%s amount=%s %s merchant=%s\n",
+				payment.ID, payment.State, payment.FormatAmount(), payment.Currency, payment.MerchantID))
+		}
+	}
+
+	if opts.paged {
+		sb.WriteString(pageFooter(opts.offset, len(payments), total) + "\n")
+	}
+
+	return newReportResult("LIST", "listed", strings.TrimSuffix(sb.String(), "\n")), nil
+}
+
+// pagePayments returns the payments after skipping offset of them, keeping at
+// most limit (all if limit is 0).
+func pagePayments(payments []*domain.Payment, offset, limit int) []*domain.Payment {
+	if offset >= len(payments) {
+		return nil
+	}
+	payments = payments[offset:]
+	if limit > 0 && limit < len(payments) {
+		payments = payments[:limit]
+	}
+	return payments
+}
+
+// pageFooter describes which 1-based range of the total a LIST page shows.
+func pageFooter(offset, shown, total int) string {
+	if shown == 0 {
+		return fmt.Sprintf("Showing 0 of %d", total)
+	}
+	return fmt.Sprintf("Showing %d-%d of %d", offset+1, offset+shown, total)
+}
+
+// listEllipsis marks a value truncated by -col-width.
+const listEllipsis = "..."
+
+// truncatePayment returns a copy of the payment whose ID, merchant and batch
+// are cut to at most width characters for display.
+func truncatePayment(payment *domain.Payment, width int) *domain.Payment {
+	c := *payment
+	c.ID = truncateField(c.ID, width)
+	c.MerchantID = truncateField(c.MerchantID, width)
+	c.BatchID = truncateField(c.BatchID, width)
+	return &c
+}
+
+// truncateField cuts s to at most width characters, ending it with an
+// ellipsis when there is room for one.
+func truncateField(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width <= len(listEllipsis) {
+		return string(runes[:width])
+	}
+	return string(runes[:width-len(listEllipsis)]) + listEllipsis
+}
+
+// writeAlignedRows writes the default LIST rows padded into columns: IDs and
+// states are left-aligned and amounts right-aligned to at least amountWidth.
+func writeAlignedRows(sb *strings.Builder, payments []*domain.Payment, amountWidth int) {
+	idWidth, stateWidth := 0, 0
+	for _, payment := range payments {
+		idWidth = max(idWidth, len(payment.ID)+1) // include the colon
+		stateWidth = max(stateWidth, len(payment.State))
+		amountWidth = max(amountWidth, len(payment.FormatAmount()))
+	}
+	for _, payment := range payments {
+		sb.WriteString(fmt.Sprintf("  %-*s state=%-*s amount=%*s %s merchant=%s\n",
+			idWidth, payment.ID+":", stateWidth, payment.State, amountWidth, payment.FormatAmount(),
+			payment.Currency, payment.MerchantID))
+	}
+}
+
+// formatColumns renders the selected columns of a payment as key=value pairs.
+func formatColumns(payment *domain.Payment, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		parts[i] = c + "=" + listColumns[c](payment)
+	}
+	return strings.Join(parts, " ")
+}
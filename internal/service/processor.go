@@ -3,8 +3,13 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"math/big"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"payment-sim/internal/domain"
 	"payment-sim/internal/parser"
@@ -12,55 +17,453 @@ import (
 )
 
 // Processor handles command execution.
+// Execute is safe for concurrent use; commands are applied one at a time.
 type Processor struct {
-	store                  store.Repository
+	mu    sync.Mutex
+	store store.Repository
+	// reads is a read-only view of store used by report commands, so they
+	// cannot mutate payments even by accident.
+	reads                  store.Repository
 	preSettlementThreshold *big.Rat
+
+	// voidReasons is an optional allowlist of VOID reason codes (nil allows any).
+	voidReasons map[string]bool
+	// requireVoidReason rejects VOID commands without a reason code.
+	requireVoidReason bool
+
+	// clock is the source of time for new payments and time-based rules.
+	clock domain.Clock
+	// captureWindow is the maximum time allowed between AUTHORIZE and
+	// CAPTURE (zero disables the check).
+	captureWindow time.Duration
+	// authExpiry is how long an authorization stays capturable; CAPTURE of
+	// an older one moves the payment to EXPIRED (zero disables expiry).
+	authExpiry time.Duration
+	// reviewTTL is how long a payment may stay in PRE_SETTLEMENT_REVIEW
+	// before SWEEP voids it (zero disables expiry).
+	reviewTTL time.Duration
+	// amountParser parses CREATE and REFUND amounts in the active dialect.
+	amountParser domain.AmountParser
+	// amountExpr lets CREATE evaluate arithmetic amount expressions.
+	amountExpr bool
+	// strictPrecision rejects CREATE amounts finer than the currency's
+	// minor units.
+	strictPrecision bool
+
+	// amountBands are plausible CREATE amount ranges per currency. Amounts
+	// outside a band produce a warning, or an error when strictBands is set.
+	amountBands map[string]domain.AmountBand
+	strictBands bool
+	// warnings receives non-fatal warnings (nil discards them).
+	warnings io.Writer
+
+	// nextAutoID is the counter behind CREATE AUTO's generated IDs.
+	nextAutoID int
+
+	// maxOpenAuth caps how many payments per merchant may be AUTHORIZED or
+	// in PRE_SETTLEMENT_REVIEW at once (zero means unlimited).
+	maxOpenAuth int
+
+	// maxBatchSize caps how many payments SETTLE may tag with one batch
+	// (zero means unlimited).
+	maxBatchSize int
+
+	// noIdempotent turns duplicate CREATE and SETTLE into errors.
+	noIdempotent bool
+
+	// listAlign is the minimum width amounts are right-aligned to in LIST
+	// (zero disables alignment).
+	listAlign int
+	// listColWidth truncates long IDs in LIST text output (zero disables).
+	listColWidth int
+
+	// transitionHooks are notified of every payment state change.
+	transitionHooks []TransitionHook
+	// edgeCounts tallies each "FROM->TO" transition taken this session.
+	edgeCounts map[string]int
+	// pendingEvents collects the state changes of a running batch, which are
+	// published only once it commits (nil outside a batch).
+	pendingEvents *[]TransitionEvent
+
+	// allowedCommands restricts which commands may run (nil allows all).
+	allowedCommands map[string]bool
+
+	// checkpoints holds named store snapshots taken by CHECKPOINT.
+	checkpoints map[string]store.Snapshot
+
+	// benchStore creates BENCH's scratch store (nil uses a MemoryStore).
+	benchStore ScratchStoreFunc
 }
 
 // NewProcessor creates a new command processor.
 // threshold can be nil to disable PRE_SETTLEMENT_REVIEW.
-func NewProcessor(store store.Repository, threshold *big.Rat) *Processor {
+func NewProcessor(repo store.Repository, threshold *big.Rat) *Processor {
 	return &Processor{
-		store:                  store,
+		store:                  repo,
+		reads:                  store.NewReadOnlyStore(repo),
 		preSettlementThreshold: threshold,
+		clock:                  domain.SystemClock{},
+		amountParser:           domain.AmountParserFunc(domain.ParseAmount),
+		checkpoints:            make(map[string]store.Snapshot),
+		edgeCounts:             make(map[string]int),
+		nextAutoID:             1,
+	}
+}
+
+// SetClock replaces the processor's clock. Intended for deterministic tests.
+func (p *Processor) SetClock(clock domain.Clock) {
+	p.clock = clock
+}
+
+// SetCaptureWindow requires CAPTURE to happen within the given duration of
+// the payment's AUTHORIZE. Zero disables the check.
+func (p *Processor) SetCaptureWindow(window time.Duration) {
+	p.captureWindow = window
+}
+
+// SetAuthExpiry sets how long an authorization stays capturable. A CAPTURE
+// after that moves the payment to EXPIRED instead. Zero disables expiry.
+func (p *Processor) SetAuthExpiry(ttl time.Duration) {
+	p.authExpiry = ttl
+}
+
+// SetReviewTTL sets how long a payment may stay in PRE_SETTLEMENT_REVIEW
+// before SWEEP voids it. Zero disables expiry.
+func (p *Processor) SetReviewTTL(ttl time.Duration) {
+	p.reviewTTL = ttl
+}
+
+// SetVoidReasons further restricts VOID reason codes to the given subset of
+// domain.VoidReasons, compared case-insensitively. An empty list allows every
+// valid reason code.
+func (p *Processor) SetVoidReasons(reasons []string) {
+	if len(reasons) == 0 {
+		p.voidReasons = nil
+		return
+	}
+	p.voidReasons = make(map[string]bool, len(reasons))
+	for _, r := range reasons {
+		p.voidReasons[strings.ToUpper(r)] = true
+	}
+}
+
+// SetRequireVoidReason makes the reason code mandatory for VOID.
+func (p *Processor) SetRequireVoidReason(required bool) {
+	p.requireVoidReason = required
+}
+
+// SetAmountParser replaces the parser used for CREATE and REFUND amounts.
+// A nil parser restores the strict decimal default.
+func (p *Processor) SetAmountParser(parser domain.AmountParser) {
+	if parser == nil {
+		parser = domain.AmountParserFunc(domain.ParseAmount)
+	}
+	p.amountParser = parser
+}
+
+// SetAmountExpr enables lenient parsing of CREATE amounts as arithmetic
+// expressions (e.g. 10.00*3), rounded to the currency precision.
+func (p *Processor) SetAmountExpr(enabled bool) {
+	p.amountExpr = enabled
+}
+
+// SetStrictPrecision makes CREATE reject amounts with more decimal places
+// than the currency's minor units (e.g. 10.001 USD).
+func (p *Processor) SetStrictPrecision(enabled bool) {
+	p.strictPrecision = enabled
+}
+
+// SetAmountBands configures plausible CREATE amount ranges per currency.
+// Out-of-band amounts are warned about, or rejected when strict is set.
+func (p *Processor) SetAmountBands(bands map[string]domain.AmountBand, strict bool) {
+	p.amountBands = bands
+	p.strictBands = strict
+}
+
+// SetWarningOutput sets where non-fatal warnings are written.
+func (p *Processor) SetWarningOutput(w io.Writer) {
+	p.warnings = w
+}
+
+// warnf writes a warning line if a warning output is configured.
+func (p *Processor) warnf(format string, args ...interface{}) {
+	if p.warnings != nil {
+		fmt.Fprintf(p.warnings, "WARNING "+format+"\n", args...)
+	}
+}
+
+// autoIDKeyword is the CREATE payment ID that requests a generated ID.
+const autoIDKeyword = "AUTO"
+
+// SetAutoIDSeed sets the counter used for the next CREATE AUTO ID, so
+// generated IDs are deterministic across runs.
+func (p *Processor) SetAutoIDSeed(next int) {
+	p.nextAutoID = next
+}
+
+// generateID returns the next sequential PAY-NNNNNN ID not already in use.
+func (p *Processor) generateID() string {
+	for {
+		id := fmt.Sprintf("PAY-%06d", p.nextAutoID)
+		p.nextAutoID++
+		if !p.store.Exists(id) {
+			return id
+		}
+	}
+}
+
+// SetMaxOpenAuth caps how many payments per merchant may hold an open
+// authorization (AUTHORIZED or PRE_SETTLEMENT_REVIEW). AUTHORIZE fails at the
+// cap until one is captured, voided or reversed. Zero means unlimited.
+func (p *Processor) SetMaxOpenAuth(n int) {
+	p.maxOpenAuth = n
+}
+
+// SetMaxBatchSize caps the number of payments a settlement batch may hold.
+// SETTLE into a full batch fails. Zero means unlimited.
+func (p *Processor) SetMaxBatchSize(n int) {
+	p.maxBatchSize = n
+}
+
+// SetNoIdempotent disables the idempotent success paths, so an identical
+// re-CREATE or a SETTLE of a SETTLED payment returns an error.
+func (p *Processor) SetNoIdempotent(enabled bool) {
+	p.noIdempotent = enabled
+}
+
+// SetListAlign pads LIST rows into aligned columns, right-aligning amounts to
+// at least width characters. Zero disables alignment.
+func (p *Processor) SetListAlign(width int) {
+	p.listAlign = width
+}
+
+// SetListColumnWidth truncates payment, merchant and batch IDs in LIST output
+// to at most width characters, ending them with "...". Zero disables it.
+func (p *Processor) SetListColumnWidth(width int) {
+	p.listColWidth = width
+}
+
+// SetAllowedCommands restricts execution to the given command names
+// (restricted mode). An empty list allows every command.
+func (p *Processor) SetAllowedCommands(names []string) {
+	if len(names) == 0 {
+		p.allowedCommands = nil
+		return
+	}
+	p.allowedCommands = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.allowedCommands[strings.ToUpper(strings.TrimSpace(name))] = true
 	}
 }
 
-// Execute processes a parsed command and returns the result.
+// Execute processes a parsed command and returns the result rendered as text.
 func (p *Processor) Execute(cmd *parser.Command) (string, error) {
+	result, err := p.ExecuteResult(cmd)
+	if err != nil {
+		return "", err
+	}
+	return TextFormatter{}.Format(result), nil
+}
+
+// ExecuteResult processes a parsed command and returns its structured result.
+func (p *Processor) ExecuteResult(cmd *parser.Command) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.execute(cmd)
+}
+
+// ExecuteBatch executes the commands in order as one atomic unit. If any
+// command fails, the store is rolled back to its state before the batch and
+// the error names the failing command; otherwise every result is returned.
+// Transition hooks and counts see the batch's changes only once it commits.
+func (p *Processor) ExecuteBatch(cmds []*parser.Command) ([]*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshotter, ok := p.store.(store.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("store does not support transactions")
+	}
+	snapshot, err := snapshotter.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	nextAutoID := p.nextAutoID
+	var events []TransitionEvent
+	p.pendingEvents = &events
+	defer func() { p.pendingEvents = nil }()
+
+	results := make([]*Result, 0, len(cmds))
+	for i, cmd := range cmds {
+		result, err := p.execute(cmd)
+		if err != nil {
+			if rerr := snapshotter.Restore(snapshot); rerr != nil {
+				return nil, fmt.Errorf("failed to roll back transaction: %v", rerr)
+			}
+			p.nextAutoID = nextAutoID
+			return nil, fmt.Errorf("command %d (%s) failed, %d command(s) rolled back: %w",
+				i+1, strings.Join(append([]string{cmd.Name}, cmd.Args...), " "), len(cmds), err)
+		}
+		results = append(results, result)
+	}
+
+	p.pendingEvents = nil
+	for _, event := range events {
+		p.publish(event)
+	}
+	return results, nil
+}
+
+// execute dispatches a command to its handler. The caller holds p.mu.
+func (p *Processor) execute(cmd *parser.Command) (*Result, error) {
+	if p.allowedCommands != nil && !p.allowedCommands[cmd.Name] {
+		return nil, fmt.Errorf("command %s not permitted in restricted mode", cmd.Name)
+	}
+
 	switch cmd.Name {
 	case "CREATE":
 		return p.handleCreate(cmd.Args)
 	case "AUTHORIZE":
 		return p.handleAuthorize(cmd.Args)
+	case "REAUTHORIZE":
+		return p.handleReauthorize(cmd.Args)
 	case "CAPTURE":
 		return p.handleCapture(cmd.Args)
+	case "REVERSE":
+		return p.handleReverse(cmd.Args)
+	case "DISPUTE":
+		return p.handleDispute(cmd.Args)
+	case "DISPUTE_WON":
+		return p.handleDisputeWon(cmd.Args)
+	case "DISPUTE_LOST":
+		return p.handleDisputeLost(cmd.Args)
+	case "BENCH":
+		return p.handleBench(cmd.Args)
+	case "TICK":
+		return p.handleTick(cmd.Args)
+	case "SWEEP":
+		return p.handleSweep()
+	case "ADJUST":
+		return p.handleAdjust(cmd.Args)
 	case "VOID":
 		return p.handleVoid(cmd.Args)
+	case "DELETE":
+		return p.handleDelete(cmd.Args)
 	case "REFUND":
 		return p.handleRefund(cmd.Args)
 	case "SETTLE":
 		return p.handleSettle(cmd.Args)
 	case "SETTLEMENT":
 		return p.handleSettlement(cmd.Args)
+	case "UNSETTLE":
+		return p.handleUnsettle(cmd.Args)
+	case "VALIDATE-BATCH":
+		return p.handleValidateBatch(cmd.Args)
+	case "RENAME-BATCH":
+		return p.handleRenameBatch(cmd.Args)
+	case "WHY-REVIEW":
+		return p.handleWhyReview(cmd.Args)
+	case "HISTORY":
+		return p.handleHistory(cmd.Args)
+	case "REFUNDABLE":
+		return p.handleRefundable(cmd.Args)
 	case "STATUS":
 		return p.handleStatus(cmd.Args)
 	case "LIST":
-		return p.handleList()
+		return p.handleList(cmd.Args)
 	case "AUDIT":
 		return p.handleAudit(cmd.Args)
+	case "PATHS-TO":
+		return p.handlePathsTo(cmd.Args)
+	case "GENERATE":
+		return p.handleGenerate(cmd.Args)
+	case "AUDIT-MONEY":
+		return p.handleAuditMoney(cmd.Args)
+	case "VERIFY":
+		return p.handleVerify()
+	case "VERIFY-HISTORY":
+		return p.handleVerifyHistory()
+	case "CHANGED-SINCE":
+		return p.handleChangedSince(cmd.Args)
+	case "OLDEST-OPEN":
+		return p.handleOldestOpen()
+	case "SUMMARY":
+		return p.handleSummary()
+	case "STATS":
+		return p.handleStats()
+	case "VOID-STATS":
+		return p.handleVoidStats()
+	case "REFUND-REASONS":
+		return p.handleRefundReasons()
+	case "DEADENDS":
+		return p.handleDeadEnds()
+	case "TRANSITION-STATS":
+		return p.handleTransitionStats()
+	case "CAPTURE-RATE":
+		return p.handleCaptureRate(cmd.Args)
+	case "SETTLE-LAG":
+		return p.handleSettleLag()
+	case "CHECK-CURRENCY":
+		return p.handleCheckCurrency(cmd.Args)
+	case "POSITION":
+		return p.handlePosition()
+	case "STATEMENT":
+		return p.handleStatement(cmd.Args)
+	case "ASSERT-ALL-TERMINAL":
+		return p.handleAssertAllTerminal()
+	case "AUDIT-EXPORT":
+		return p.handleAuditExport(cmd.Args)
+	case "EXPORT":
+		return p.handleExport(cmd.Args)
+	case "IMPORT":
+		return p.handleImport(cmd.Args)
+	case "CHECKPOINT", "SNAPSHOT":
+		return p.handleCheckpoint(cmd.Name, cmd.Args)
+	case "RESTORE":
+		return p.handleRestore(cmd.Args)
 	case "EXIT":
 		// This should be handled by the runner, not here
-		return "", nil
+		return &Result{Command: "EXIT"}, nil
 	default:
-		return "", fmt.Errorf("unknown command: %s", cmd.Name)
+		return nil, fmt.Errorf("unknown command: %s", cmd.Name)
+	}
+}
+
+// getForUpdate fetches a payment that is about to be mutated and gives it the
+// processor's clock, which payments loaded from a -store-file lack.
+func (p *Processor) getForUpdate(id string) (*domain.Payment, error) {
+	payment, err := p.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	payment.SetClock(p.clock)
+	return payment, nil
+}
+
+// listForUpdate is getForUpdate for every payment in the store.
+func (p *Processor) listForUpdate() ([]*domain.Payment, error) {
+	payments, err := p.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range payments {
+		payment.SetClock(p.clock)
+	}
+	return payments, nil
+}
+
+// save stores a payment, wrapping any store error for the user.
+func (p *Processor) save(payment *domain.Payment) error {
+	if err := p.store.Save(payment); err != nil {
+		return fmt.Errorf("failed to save payment: %v", err)
 	}
+	return nil
 }
 
 // handleCreate handles the CREATE command.
-func (p *Processor) handleCreate(args []string) (string, error) {
+func (p *Processor) handleCreate(args []string) (*Result, error) {
 	if len(args) < 4 {
-		return "", fmt.Errorf("CREATE requires 4 arguments: <payment_id> <amount> <currency> <merchant_id>")
+		return nil, fmt.Errorf("CREATE requires 4 arguments: <payment_id> <amount> <currency> <merchant_id>")
 	}
 
 	paymentID := args[0]
@@ -68,265 +471,1935 @@ func (p *Processor) handleCreate(args []string) (string, error) {
 	currency := args[2]
 	merchantID := args[3]
 
+	// Optional idempotency_key=KEY, distinct from the payment ID
+	idempotencyKey := ""
+	for _, arg := range args[4:] {
+		key, value, ok := parser.KeyValue(arg)
+		if !ok || key != "idempotency_key" {
+			return nil, fmt.Errorf("unknown CREATE option: %s", arg)
+		}
+		if value == "" {
+			return nil, domain.NewValidationError("idempotency_key", "must not be empty")
+		}
+		idempotencyKey = value
+	}
+
 	// Validate currency (3 letters)
 	if len(currency) != 3 {
-		return "", fmt.Errorf("currency must be a 3-letter code: %s", currency)
+		return nil, fmt.Errorf("currency must be a 3-letter code: %s", currency)
+	}
+	if !domain.IsValidCurrency(currency) {
+		return nil, domain.NewValidationError("currency", fmt.Sprintf("unknown ISO 4217 currency code: %s", currency))
 	}
 
 	// Validate merchant_id is non-empty
 	if merchantID == "" {
-		return "", fmt.Errorf("merchant_id cannot be empty")
+		return nil, fmt.Errorf("merchant_id cannot be empty")
 	}
 
 	// Parse amount
-	amount, err := domain.ParseAmount(amountStr)
+	var amount *big.Rat
+	var err error
+	if p.amountExpr {
+		amount, err = domain.EvalAmount(amountStr, currency)
+	} else {
+		amount, err = p.amountParser.ParseAmount(amountStr)
+	}
 	if err != nil {
-		return "", fmt.Errorf("invalid amount: %v", err)
+		return nil, fmt.Errorf("invalid amount: %v", err)
+	}
+	if p.strictPrecision {
+		if err := domain.CheckPrecision(amount, currency); err != nil {
+			return nil, fmt.Errorf("invalid amount %s: %v", amountStr, err)
+		}
+	}
+	if band, ok := p.amountBands[domain.NormalizeCurrency(currency)]; ok && !band.Contains(amount) {
+		msg := fmt.Sprintf("amount %s %s is outside the plausible range %s for payment %s",
+			amountStr, domain.NormalizeCurrency(currency), band, paymentID)
+		if p.strictBands {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		p.warnf("%s", msg)
+	}
+
+	// A repeated idempotency key replays the original CREATE
+	if idempotencyKey != "" {
+		if originalID, ok := p.store.LookupIdempotencyKey(idempotencyKey); ok {
+			return p.replayCreate(idempotencyKey, originalID, amount, currency, merchantID)
+		}
+	}
+
+	// Generate an ID once the command is known to be valid
+	if paymentID == autoIDKeyword {
+		paymentID = p.generateID()
 	}
 
 	// Check for existing payment
-	existing, err := p.store.Get(paymentID)
+	existing, err := p.getForUpdate(paymentID)
 	if err == nil {
 		// Payment exists - check if it has progressed beyond INITIATED
 		if existing.State != domain.StateInitiated {
-			return "", fmt.Errorf("payment %s already exists in state %s (cannot recreate progressed payments)", paymentID, existing.State)
+			return nil, fmt.Errorf("payment %s already exists in state %s (cannot recreate progressed payments)", paymentID, existing.State)
 		}
 
 		// Payment still in INITIATED - check for idempotency
-		newPayment := domain.NewPayment(paymentID, amount, currency, merchantID)
+		newPayment := domain.NewPaymentWithClock(paymentID, amount, currency, merchantID, p.clock)
 		if existing.Equals(newPayment) {
+			if p.noIdempotent {
+				return nil, fmt.Errorf("payment %s already exists (idempotent CREATE disabled)", paymentID)
+			}
 			// Idempotent - same attributes, no error
-			return fmt.Sprintf("Payment %s already exists (idempotent)", paymentID), nil
+			return newPaymentResult("CREATE", "idempotent", existing,
+				fmt.Sprintf("Payment %s already exists (idempotent)", paymentID)), nil
 		}
 		// Conflict - mark existing as FAILED and reject
 		existing.SetFailed("create conflict")
-		p.store.Save(existing)
-		return "", domain.NewCreateConflictError(paymentID)
+		p.emitTransition(existing)
+		if err := p.save(existing); err != nil {
+			return nil, err
+		}
+		return nil, domain.NewCreateConflictError(paymentID)
 	}
 
 	// Create new payment
-	payment := domain.NewPayment(paymentID, amount, currency, merchantID)
+	payment := domain.NewPaymentWithClock(paymentID, amount, currency, merchantID, p.clock)
 	if err := p.store.Save(payment); err != nil {
-		return "", fmt.Errorf("failed to save payment: %v", err)
+		return nil, fmt.Errorf("failed to save payment: %v", err)
 	}
+	if idempotencyKey != "" {
+		if err := p.store.RecordIdempotencyKey(idempotencyKey, paymentID); err != nil {
+			return nil, err
+		}
+	}
+
+	return newPaymentResult("CREATE", "created", payment, createdMessage(payment)), nil
+}
 
-	return fmt.Sprintf("Payment %s created: %s %s", paymentID, payment.FormatAmount(), currency), nil
+// createdMessage is the result message of a successful CREATE.
+func createdMessage(payment *domain.Payment) string {
+	return fmt.Sprintf("Payment %s created: %s %s", payment.ID, payment.FormatAmount(), payment.Currency)
+}
+
+// replayCreate handles a CREATE whose idempotency key was already used. With
+// identical attributes it returns the original result without creating a
+// payment; different attributes are an error.
+func (p *Processor) replayCreate(key, originalID string, amount *big.Rat, currency, merchantID string) (*Result, error) {
+	original, err := p.store.Get(originalID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s for idempotency key %s not found", originalID, key)
+	}
+	request := domain.NewPaymentWithClock(originalID, amount, currency, merchantID, p.clock)
+	if !original.Equals(request) {
+		return nil, fmt.Errorf("idempotency key %s already used for payment %s with different attributes", key, originalID)
+	}
+	if p.noIdempotent {
+		return nil, fmt.Errorf("idempotency key %s already used for payment %s (idempotent CREATE disabled)", key, originalID)
+	}
+	return newPaymentResult("CREATE", "idempotent", original, createdMessage(original)), nil
 }
 
 // handleAuthorize handles the AUTHORIZE command.
-func (p *Processor) handleAuthorize(args []string) (string, error) {
+func (p *Processor) handleAuthorize(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("AUTHORIZE requires payment_id")
+		return nil, fmt.Errorf("AUTHORIZE requires payment_id")
 	}
 
 	paymentID := args[0]
-	payment, err := p.store.Get(paymentID)
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
 	}
 
-	// Transition to AUTHORIZED
-	if err := payment.TransitionTo(domain.StateAuthorized, "AUTHORIZE", "Payment authorized"); err != nil {
-		return "", err
+	// AUTHORIZE <id> DECLINE [reason] simulates an issuer decline
+	if len(args) > 1 {
+		if args[1] != "DECLINE" {
+			return nil, fmt.Errorf("unknown AUTHORIZE option %s (expected DECLINE)", args[1])
+		}
+		reason := domain.IssuerDeclinedReason
+		if len(args) > 2 {
+			reason = args[2]
+		}
+		if err := p.transition(payment, domain.StateDeclined, "AUTHORIZE", "Authorization declined: "+reason); err != nil {
+			return nil, err
+		}
+		payment.SetDeclineReason(reason)
+		if err := p.save(payment); err != nil {
+			return nil, err
+		}
+		return newPaymentResult("AUTHORIZE", "declined", payment,
+			fmt.Sprintf("Payment %s declined by issuer (%s)", paymentID, reason)), nil
 	}
 
-	// Check if PRE_SETTLEMENT_REVIEW is needed
-	if p.preSettlementThreshold != nil && payment.Amount.Cmp(p.preSettlementThreshold) >= 0 {
-		if err := payment.TransitionTo(domain.StatePreSettlementReview, "REVIEW", "Amount exceeds threshold"); err != nil {
-			// This shouldn't happen, but handle gracefully
-			return "", fmt.Errorf("failed to move to pre-settlement review: %v", err)
+	// Only INITIATED payments can be authorized; REAUTHORIZE refreshes an
+	// existing authorization
+	if payment.State != domain.StateInitiated {
+		return nil, domain.NewInvalidTransitionError(payment.State, domain.StateAuthorized)
+	}
+
+	// Enforce the per-merchant cap on open authorizations
+	if p.maxOpenAuth > 0 {
+		open, err := p.openAuthorizations(payment.MerchantID)
+		if err != nil {
+			return nil, err
 		}
-		p.store.Save(payment)
-		return fmt.Sprintf("Payment %s authorized and moved to PRE_SETTLEMENT_REVIEW", paymentID), nil
+		if open >= p.maxOpenAuth {
+			return nil, fmt.Errorf("merchant %s already has %d open authorization(s) (max %d)",
+				payment.MerchantID, open, p.maxOpenAuth)
+		}
+	}
+
+	// Transition to AUTHORIZED
+	if err := p.transition(payment, domain.StateAuthorized, "AUTHORIZE", "Payment authorized"); err != nil {
+		return nil, err
 	}
 
-	p.store.Save(payment)
-	return fmt.Sprintf("Payment %s authorized", paymentID), nil
+	// Check if PRE_SETTLEMENT_REVIEW is needed
+	review, err := p.applyReviewThreshold(payment)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	if review {
+		return newPaymentResult("AUTHORIZE", "review", payment,
+			fmt.Sprintf("Payment %s authorized and moved to PRE_SETTLEMENT_REVIEW", paymentID)), nil
+	}
+	return newPaymentResult("AUTHORIZE", "authorized", payment,
+		fmt.Sprintf("Payment %s authorized", paymentID)), nil
 }
 
-// handleCapture handles the CAPTURE command.
-func (p *Processor) handleCapture(args []string) (string, error) {
+// handleReauthorize handles the REAUTHORIZE command.
+// It refreshes the authorization of an AUTHORIZED or PRE_SETTLEMENT_REVIEW
+// payment, restarting the capture window, and re-applies the review
+// threshold as AUTHORIZE does.
+func (p *Processor) handleReauthorize(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("CAPTURE requires payment_id")
+		return nil, fmt.Errorf("REAUTHORIZE requires payment_id")
 	}
 
 	paymentID := args[0]
-	payment, err := p.store.Get(paymentID)
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
 	}
 
-	// Valid from AUTHORIZED or PRE_SETTLEMENT_REVIEW
-	if err := payment.TransitionTo(domain.StateCaptured, "CAPTURE", "Payment captured"); err != nil {
-		return "", err
+	switch payment.State {
+	case domain.StateAuthorized:
+		if err := p.transition(payment, domain.StateAuthorized, "REAUTHORIZE", "Authorization refreshed"); err != nil {
+			return nil, err
+		}
+	case domain.StatePreSettlementReview:
+		// An expired review is left for SWEEP to void
+		if p.reviewExpired(payment, p.clock.Now()) {
+			return nil, fmt.Errorf("cannot reauthorize payment %s: review time limit elapsed", paymentID)
+		}
+		if err := payment.ReauthorizeReview(); err != nil {
+			return nil, err
+		}
+		p.emitTransition(payment)
+	default:
+		return nil, fmt.Errorf("cannot reauthorize payment %s in state %s (must be AUTHORIZED or PRE_SETTLEMENT_REVIEW)",
+			paymentID, payment.State)
+	}
+
+	review, err := p.applyReviewThreshold(payment)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.save(payment); err != nil {
+		return nil, err
 	}
+	if review {
+		return newPaymentResult("REAUTHORIZE", "review", payment,
+			fmt.Sprintf("Payment %s reauthorized and moved to PRE_SETTLEMENT_REVIEW", paymentID)), nil
+	}
+	return newPaymentResult("REAUTHORIZE", "authorized", payment,
+		fmt.Sprintf("Payment %s reauthorized", paymentID)), nil
+}
 
-	p.store.Save(payment)
-	return fmt.Sprintf("Payment %s captured", paymentID), nil
+// applyReviewThreshold moves a freshly authorized payment to
+// PRE_SETTLEMENT_REVIEW when its amount reaches the threshold, and reports
+// whether it did.
+func (p *Processor) applyReviewThreshold(payment *domain.Payment) (bool, error) {
+	if p.preSettlementThreshold == nil || payment.Amount.Cmp(p.preSettlementThreshold) < 0 {
+		return false, nil
+	}
+	reason := fmt.Sprintf("amount %s %s >= threshold %s",
+		domain.FormatMoney(payment.Amount, payment.Currency), payment.Currency, domain.FormatDecimal(p.preSettlementThreshold))
+	if err := p.transition(payment, domain.StatePreSettlementReview, "REVIEW", reason); err != nil {
+		// This shouldn't happen, but handle gracefully
+		return false, fmt.Errorf("failed to move to pre-settlement review: %v", err)
+	}
+	return true, nil
 }
 
-// handleVoid handles the VOID command.
-func (p *Processor) handleVoid(args []string) (string, error) {
+// authorizedAt returns when the payment was last authorized or
+// reauthorized, and false if it never was.
+func authorizedAt(payment *domain.Payment) (time.Time, bool) {
+	for i := len(payment.History) - 1; i >= 0; i-- {
+		if action := payment.History[i].Action; action == "AUTHORIZE" || action == "REAUTHORIZE" {
+			return payment.History[i].Timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// openAuthorizations counts the merchant's payments that are AUTHORIZED or
+// in PRE_SETTLEMENT_REVIEW.
+func (p *Processor) openAuthorizations(merchantID string) (int, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list payments: %v", err)
+	}
+	open := 0
+	for _, payment := range payments {
+		if payment.MerchantID != merchantID {
+			continue
+		}
+		if payment.State == domain.StateAuthorized || payment.State == domain.StatePreSettlementReview {
+			open++
+		}
+	}
+	return open, nil
+}
+
+// handleCapture handles the CAPTURE command.
+func (p *Processor) handleCapture(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("VOID requires payment_id")
+		return nil, fmt.Errorf("CAPTURE requires payment_id")
 	}
 
 	paymentID := args[0]
-	reasonCode := ""
-	if len(args) > 1 {
-		reasonCode = args[1]
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
 	}
 
-	payment, err := p.store.Get(paymentID)
-	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+	// Expire authorizations older than the TTL rather than capturing them
+	if p.authExpiry > 0 && (payment.State == domain.StateAuthorized || payment.State == domain.StatePreSettlementReview) {
+		if auth, ok := authorizedAt(payment); ok && p.clock.Now().Sub(auth) > p.authExpiry {
+			details := fmt.Sprintf("Authorization expired after %s", p.authExpiry)
+			if err := p.transition(payment, domain.StateExpired, "EXPIRE_AUTH", details); err != nil {
+				return nil, err
+			}
+			if err := p.save(payment); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("cannot capture: authorization for payment %s expired (older than %s)", paymentID, p.authExpiry)
+		}
 	}
 
-	// Valid from INITIATED or AUTHORIZED only
-	if err := payment.TransitionTo(domain.StateVoided, "VOID", "Payment voided"); err != nil {
-		return "", err
+	// Enforce the capture window relative to the AUTHORIZE timestamp
+	if p.captureWindow > 0 {
+		if auth, ok := authorizedAt(payment); ok && p.clock.Now().Sub(auth) > p.captureWindow {
+			return nil, fmt.Errorf("capture window elapsed for payment %s (window %s)", paymentID, p.captureWindow)
+		}
 	}
 
-	if reasonCode != "" {
-		payment.SetVoidReason(reasonCode)
+	if payment.State == domain.StateReversed {
+		return nil, fmt.Errorf("cannot capture: authorization was reversed")
+	}
+	if payment.State == domain.StateDisputed {
+		return nil, openDisputeError(paymentID)
 	}
 
-	p.store.Save(payment)
-	if reasonCode != "" {
-		return fmt.Sprintf("Payment %s voided (reason: %s)", paymentID, reasonCode), nil
+	// Optional amount argument for a partial capture; defaults to the full
+	// authorized amount
+	amount := payment.Amount
+	if len(args) > 1 {
+		amount, err = p.amountParser.ParseAmount(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture amount: %v", err)
+		}
+		if amount.Cmp(payment.Amount) > 0 {
+			return nil, fmt.Errorf("capture amount %s exceeds authorized %s %s", args[1],
+				domain.FormatMoney(payment.Amount, payment.Currency), payment.Currency)
+		}
+	}
+	partial := amount.Cmp(payment.Amount) < 0
+	details := "Payment captured"
+	if partial {
+		details = fmt.Sprintf("Payment captured for %s of %s %s", domain.FormatMoney(amount, payment.Currency),
+			domain.FormatMoney(payment.Amount, payment.Currency), payment.Currency)
+	}
+
+	// Valid from AUTHORIZED or PRE_SETTLEMENT_REVIEW
+	if err := p.transition(payment, domain.StateCaptured, "CAPTURE", details); err != nil {
+		return nil, err
+	}
+	if err := payment.RecordCapture(amount, payment.Currency); err != nil {
+		return nil, err
+	}
+
+	if err := p.save(payment); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("Payment %s voided", paymentID), nil
+	if partial {
+		return newPaymentResult("CAPTURE", "captured", payment,
+			fmt.Sprintf("Payment %s captured %s of %s %s", paymentID, domain.FormatMoney(amount, payment.Currency),
+				domain.FormatMoney(payment.Amount, payment.Currency), payment.Currency)), nil
+	}
+	return newPaymentResult("CAPTURE", "captured", payment,
+		fmt.Sprintf("Payment %s captured", paymentID)), nil
 }
 
-// handleRefund handles the REFUND command.
-func (p *Processor) handleRefund(args []string) (string, error) {
+// handleReverse handles the REVERSE command.
+// It releases an authorization that will not be captured.
+func (p *Processor) handleReverse(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("REFUND requires payment_id")
+		return nil, fmt.Errorf("REVERSE requires payment_id")
 	}
 
 	paymentID := args[0]
-	// Optional amount argument - we accept it but for simplicity mark as fully refunded
-	refundAmountStr := ""
-	if len(args) > 1 {
-		refundAmountStr = args[1]
-	}
-
-	payment, err := p.store.Get(paymentID)
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
 	}
 
-	// Valid from CAPTURED only
-	if err := payment.TransitionTo(domain.StateRefunded, "REFUND", "Payment refunded"); err != nil {
-		return "", err
+	// Valid from AUTHORIZED or PRE_SETTLEMENT_REVIEW
+	if err := p.transition(payment, domain.StateReversed, "REVERSE", "Authorization reversed"); err != nil {
+		return nil, err
 	}
 
-	p.store.Save(payment)
-	if refundAmountStr != "" {
-		return fmt.Sprintf("Payment %s refunded (%s)", paymentID, refundAmountStr), nil
+	if err := p.save(payment); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("Payment %s refunded", paymentID), nil
+	return newPaymentResult("REVERSE", "reversed", payment,
+		fmt.Sprintf("Payment %s authorization reversed", paymentID)), nil
 }
 
-// handleSettle handles the SETTLE command.
-func (p *Processor) handleSettle(args []string) (string, error) {
-	if len(args) < 1 {
-		return "", fmt.Errorf("SETTLE requires payment_id")
+// handleDispute handles the DISPUTE command.
+// It opens a chargeback dispute on a CAPTURED or SETTLED payment.
+func (p *Processor) handleDispute(args []string) (*Result, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("DISPUTE requires 2 arguments: <payment_id> <reason_code>")
 	}
 
 	paymentID := args[0]
-	payment, err := p.store.Get(paymentID)
+	reasonCode := args[1]
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
 	}
 
-	// Check for idempotency: SETTLED -> SETTLED is allowed
-	if payment.State == domain.StateSettled {
-		return fmt.Sprintf("Payment %s already settled (idempotent)", paymentID), nil
+	// Valid from CAPTURED or SETTLED
+	if err := p.transition(payment, domain.StateDisputed, "DISPUTE", "Dispute opened: "+reasonCode); err != nil {
+		return nil, err
 	}
+	payment.SetDisputeReason(reasonCode)
 
-	// Valid from CAPTURED only
-	if err := payment.TransitionTo(domain.StateSettled, "SETTLE", "Payment settled"); err != nil {
-		return "", err
+	if err := p.save(payment); err != nil {
+		return nil, err
 	}
-
-	p.store.Save(payment)
-	return fmt.Sprintf("Payment %s settled", paymentID), nil
+	return newPaymentResult("DISPUTE", "disputed", payment,
+		fmt.Sprintf("Payment %s disputed (reason: %s)", paymentID, reasonCode)), nil
 }
 
-// handleSettlement handles the SETTLEMENT command.
-func (p *Processor) handleSettlement(args []string) (string, error) {
+// handleDisputeWon handles the DISPUTE_WON command.
+// It returns a DISPUTED payment to the state the dispute was raised from.
+func (p *Processor) handleDisputeWon(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("SETTLEMENT requires batch_id")
+		return nil, fmt.Errorf("DISPUTE_WON requires payment_id")
 	}
 
-	batchID := args[0]
-
-	// Record the batch ID (no state changes to payments)
-	p.store.RecordBatchID(batchID)
+	paymentID := args[0]
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
 
-	// Get all settled payments for summary
-	payments, _ := p.store.List()
-	settledCount := 0
-	for _, payment := range payments {
-		if payment.State == domain.StateSettled {
-			settledCount++
-		}
+	opened, ok := payment.LastEntry("DISPUTE")
+	if payment.State != domain.StateDisputed || !ok {
+		return nil, fmt.Errorf("payment %s has no open dispute (state %s)", paymentID, payment.State)
+	}
+	if err := p.transition(payment, opened.FromState, "DISPUTE_WON", "Dispute won"); err != nil {
+		return nil, err
 	}
 
-	return fmt.Sprintf("SETTLEMENT %s recorded. Settled payments: %d", batchID, settledCount), nil
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	return newPaymentResult("DISPUTE_WON", "dispute_won", payment,
+		fmt.Sprintf("Payment %s dispute won; returned to %s", paymentID, payment.State)), nil
 }
 
-// handleStatus handles the STATUS command.
-func (p *Processor) handleStatus(args []string) (string, error) {
+// handleDisputeLost handles the DISPUTE_LOST command.
+// It moves a DISPUTED payment to the terminal CHARGED_BACK state.
+func (p *Processor) handleDisputeLost(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("STATUS requires payment_id")
+		return nil, fmt.Errorf("DISPUTE_LOST requires payment_id")
 	}
 
 	paymentID := args[0]
-	payment, err := p.store.Get(paymentID)
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	// Valid from DISPUTED only
+	if err := p.transition(payment, domain.StateChargedBack, "DISPUTE_LOST", "Dispute lost"); err != nil {
+		return nil, err
+	}
+
+	if err := p.save(payment); err != nil {
+		return nil, err
 	}
+	return newPaymentResult("DISPUTE_LOST", "charged_back", payment,
+		fmt.Sprintf("Payment %s dispute lost; charged back", paymentID)), nil
+}
 
-	return fmt.Sprintf("Payment %s: state=%s amount=%s currency=%s merchant=%s",
-		payment.ID, payment.State, payment.FormatAmount(), payment.Currency, payment.MerchantID), nil
+// openDisputeError is returned when a command other than DISPUTE_WON tries to
+// move a DISPUTED payment back to CAPTURED or SETTLED.
+func openDisputeError(paymentID string) error {
+	return fmt.Errorf("payment %s is DISPUTED and must be resolved with DISPUTE_WON or DISPUTE_LOST", paymentID)
 }
 
-// handleList handles the LIST command.
-func (p *Processor) handleList() (string, error) {
-	payments, err := p.store.List()
-	if err != nil {
-		return "", fmt.Errorf("failed to list payments: %v", err)
+// handleAdjust handles the ADJUST command.
+// It records a signed post-settlement correction without changing state.
+//
+//	ADJUST <payment_id> <signed_amount> <reason>
+func (p *Processor) handleAdjust(args []string) (*Result, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("ADJUST requires payment_id, amount and reason")
 	}
 
-	if len(payments) == 0 {
-		return "No payments found", nil
+	paymentID, reason := args[0], args[2]
+	amount, err := domain.ParseSignedAmount(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid adjustment amount: %v", err)
 	}
 
-	var sb strings.Builder
-	sb.WriteString("Payments:\n")
-	for _, payment := range payments {
-		sb.WriteString(fmt.Sprintf("  %s: state=%s amount=%s %s merchant=%s\n",
-			payment.ID, payment.State, payment.FormatAmount(), payment.Currency, payment.MerchantID))
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+	if err := payment.RecordAdjustment(amount, reason); err != nil {
+		return nil, err
 	}
 
-	return strings.TrimSuffix(sb.String(), "\n"), nil
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	return newPaymentResult("ADJUST", "adjusted", payment,
+		fmt.Sprintf("Payment %s adjusted by %s %s (%s); net %s %s", paymentID,
+			domain.FormatMoney(amount, payment.Currency), payment.Currency, reason,
+			domain.FormatMoney(payment.NetAmount(), payment.Currency), payment.Currency)), nil
 }
 
-// handleAudit handles the AUDIT command.
-// AUDIT must have ZERO side effects - it only acknowledges receipt.
-func (p *Processor) handleAudit(args []string) (string, error) {
+// handleVoid handles the VOID command.
+func (p *Processor) handleVoid(args []string) (*Result, error) {
 	if len(args) < 1 {
-		return "", fmt.Errorf("AUDIT requires payment_id")
+		return nil, fmt.Errorf("VOID requires payment_id")
 	}
 
 	paymentID := args[0]
-	// Verify payment exists but do NOT mutate anything
-	_, err := p.store.Get(paymentID)
+	reasonCode := ""
+	if len(args) > 1 {
+		reason, err := domain.ParseVoidReason(args[1])
+		if err != nil {
+			return nil, err
+		}
+		reasonCode = reason
+	}
+
+	// Validate reason code against configured policy
+	if reasonCode == "" && p.requireVoidReason {
+		return nil, domain.NewValidationError("reason_code", "a reason code is required for VOID")
+	}
+	if reasonCode != "" && p.voidReasons != nil && !p.voidReasons[reasonCode] {
+		return nil, domain.NewValidationError("reason_code", fmt.Sprintf("reason code %s is not allowed", reasonCode))
+	}
+
+	payment, err := p.getForUpdate(paymentID)
 	if err != nil {
-		return "", fmt.Errorf("payment %s not found", paymentID)
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	// Valid from INITIATED or AUTHORIZED only
+	if err := p.transition(payment, domain.StateVoided, "VOID", "Payment voided"); err != nil {
+		return nil, err
+	}
+
+	if reasonCode != "" {
+		payment.SetVoidReason(reasonCode)
+	}
+
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	if reasonCode != "" {
+		return newPaymentResult("VOID", "voided", payment,
+			fmt.Sprintf("Payment %s voided (reason: %s)", paymentID, reasonCode)), nil
+	}
+	return newPaymentResult("VOID", "voided", payment,
+		fmt.Sprintf("Payment %s voided", paymentID)), nil
+}
+
+// handleDelete handles the DELETE command. Only payments whose lifecycle is
+// complete can be deleted, so an active payment is never silently dropped.
+func (p *Processor) handleDelete(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("DELETE requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+	if !domain.IsTerminal(payment.State) {
+		return nil, fmt.Errorf("cannot delete payment %s in state %s (must be terminal)", paymentID, payment.State)
+	}
+	if err := p.store.Delete(paymentID); err != nil {
+		return nil, err
+	}
+
+	return newPaymentResult("DELETE", "deleted", payment,
+		fmt.Sprintf("Payment %s deleted", paymentID)), nil
+}
+
+// handleRefund handles the REFUND command.
+func (p *Processor) handleRefund(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("REFUND requires payment_id")
+	}
+
+	paymentID := args[0]
+	// Optional amount argument; less than the refundable amount is a partial
+	// refund that leaves the payment CAPTURED
+	refundAmountStr := ""
+	var refundAmount *big.Rat
+	if len(args) > 1 {
+		refundAmountStr = args[1]
+		amount, err := p.amountParser.ParseAmount(refundAmountStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refund amount: %v", err)
+		}
+		refundAmount = amount
+	}
+	// Optional reason code, recorded on the refund movement
+	reason := ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
+
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	// Valid from CAPTURED only
+	refundable, _ := payment.Refundable()
+	if refundAmount != nil && payment.State == domain.StateCaptured {
+		if refundAmount.Cmp(refundable) > 0 {
+			return nil, fmt.Errorf("refund amount %s exceeds refundable %s %s", refundAmountStr,
+				domain.FormatMoney(refundable, payment.Currency), payment.Currency)
+		}
+		if refundAmount.Cmp(refundable) < 0 {
+			if err := payment.RecordPartialRefund(refundAmount, reason); err != nil {
+				return nil, err
+			}
+			if err := p.save(payment); err != nil {
+				return nil, err
+			}
+			return newPaymentResult("REFUND", "partially_refunded", payment,
+				fmt.Sprintf("Payment %s partially refunded %s %s (refunded %s of %s %s)", paymentID,
+					domain.FormatMoney(refundAmount, payment.Currency), payment.Currency,
+					domain.FormatMoney(payment.Refunded(), payment.Currency),
+					domain.FormatMoney(payment.Captured(), payment.Currency), payment.Currency)), nil
+		}
+	}
+	if err := p.transition(payment, domain.StateRefunded, "REFUND", "Payment refunded"); err != nil {
+		return nil, err
+	}
+	if err := payment.RecordRefundWithReason(refundable, payment.Currency, reason); err != nil {
+		return nil, err
+	}
+
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	if refundAmountStr != "" {
+		return newPaymentResult("REFUND", "refunded", payment,
+			fmt.Sprintf("Payment %s refunded (%s)", paymentID, refundAmountStr)), nil
+	}
+	return newPaymentResult("REFUND", "refunded", payment,
+		fmt.Sprintf("Payment %s refunded", paymentID)), nil
+}
+
+// handleSettle handles the SETTLE command.
+func (p *Processor) handleSettle(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("SETTLE requires payment_id")
+	}
+
+	paymentID := args[0]
+	batchID := ""
+	if len(args) > 1 {
+		batchID = args[1]
+	}
+	payment, err := p.getForUpdate(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	// Check for idempotency: SETTLED -> SETTLED is allowed
+	if payment.State == domain.StateSettled {
+		if batchID != "" && batchID != payment.BatchID {
+			return nil, fmt.Errorf("payment %s already settled outside batch %s", paymentID, batchID)
+		}
+		if p.noIdempotent {
+			return nil, fmt.Errorf("payment %s already settled (idempotent SETTLE disabled)", paymentID)
+		}
+		return newPaymentResult("SETTLE", "idempotent", payment,
+			fmt.Sprintf("Payment %s already settled (idempotent)", paymentID)), nil
+	}
+
+	// Review payments need a CAPTURE first; say so instead of a generic error
+	if payment.State == domain.StatePreSettlementReview {
+		return nil, fmt.Errorf("payment %s is in PRE_SETTLEMENT_REVIEW and must be captured before settlement", paymentID)
+	}
+	if payment.State == domain.StateDisputed {
+		return nil, openDisputeError(paymentID)
+	}
+	if batchID != "" {
+		if err := p.checkBatchOpen(batchID); err != nil {
+			return nil, err
+		}
+	}
+
+	// A partial capture settles for the captured amount; the rest of the
+	// authorization is released rather than left dangling
+	remainder := payment.UncapturedRemainder()
+	details := "Payment settled"
+	if remainder.Sign() > 0 {
+		details = fmt.Sprintf("Payment settled for %s %s; released uncaptured remainder %s %s",
+			domain.FormatMoney(payment.Captured(), payment.Currency), payment.Currency,
+			domain.FormatMoney(remainder, payment.Currency), payment.Currency)
+	}
+
+	// Valid from CAPTURED only
+	if err := p.transition(payment, domain.StateSettled, "SETTLE", details); err != nil {
+		return nil, err
+	}
+	if remainder.Sign() > 0 {
+		if err := payment.RecordRelease(remainder, payment.Currency); err != nil {
+			return nil, err
+		}
+	}
+	if batchID != "" {
+		payment.AssignBatch(batchID)
+	}
+
+	if err := p.save(payment); err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("Payment %s settled", paymentID)
+	if batchID != "" {
+		message += " in batch " + batchID
+	}
+	if remainder.Sign() > 0 {
+		message += fmt.Sprintf(" (released uncaptured remainder %s %s)",
+			domain.FormatMoney(remainder, payment.Currency), payment.Currency)
+	}
+	return newPaymentResult("SETTLE", "settled", payment, message), nil
+}
+
+// checkBatchOpen reports an error if SETTLE may not tag another payment with
+// the batch: it was already recorded by SETTLEMENT, or it is full.
+func (p *Processor) checkBatchOpen(batchID string) error {
+	if p.store.BatchIDExists(batchID) {
+		return fmt.Errorf("batch %s already recorded", batchID)
+	}
+	if p.maxBatchSize == 0 {
+		return nil
+	}
+	payments, _ := p.store.List()
+	members := 0
+	for _, payment := range payments {
+		if payment.BatchID == batchID {
+			members++
+		}
+	}
+	if members >= p.maxBatchSize {
+		return fmt.Errorf("batch %s is full (max %d payments)", batchID, p.maxBatchSize)
+	}
+	return nil
+}
+
+// handleSettlement handles the SETTLEMENT command.
+// It records the batch ID and reports the SETTLED payments that SETTLE tagged
+// with it. Payments settled without a batch are not included.
+func (p *Processor) handleSettlement(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("SETTLEMENT requires batch_id")
+	}
+
+	batchID := args[0]
+	if p.store.BatchIDExists(batchID) {
+		return nil, fmt.Errorf("batch %s already recorded", batchID)
+	}
+
+	// Record the batch ID
+	if err := p.store.RecordBatchID(batchID); err != nil {
+		return nil, fmt.Errorf("failed to record batch %s: %v", batchID, err)
+	}
+
+	payments, _ := p.store.List()
+	settledCount := 0
+	for _, payment := range payments {
+		if payment.State == domain.StateSettled && payment.BatchID == batchID {
+			settledCount++
+		}
+	}
+
+	message := fmt.Sprintf("SETTLEMENT %s recorded. Settled payments: %d", batchID, settledCount)
+	if settledCount > 0 {
+		message += "\n" + batchReport(payments, batchID)
+	}
+	return newReportResult("SETTLEMENT", "recorded", message), nil
+}
+
+// batchReport sums the captured amounts (not the authorized amounts) of a
+// batch's members per currency, e.g.
+// "BATCH001: EUR 60.00 (1 payment), USD 160.00 (2 payments)".
+func batchReport(payments []*domain.Payment, batchID string) string {
+	totals := make(map[string]*big.Rat)
+	counts := make(map[string]int)
+	for _, payment := range payments {
+		if payment.BatchID != batchID {
+			continue
+		}
+		if _, ok := totals[payment.Currency]; !ok {
+			totals[payment.Currency] = new(big.Rat)
+		}
+		totals[payment.Currency].Add(totals[payment.Currency], payment.Captured())
+		counts[payment.Currency]++
+	}
+
+	currencies := make([]string, 0, len(totals))
+	for c := range totals {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	parts := make([]string, len(currencies))
+	for i, c := range currencies {
+		noun := "payments"
+		if counts[c] == 1 {
+			noun = "payment"
+		}
+		parts[i] = fmt.Sprintf("%s %s (%d %s)", c, domain.FormatMoney(totals[c], c), counts[c], noun)
+	}
+	return batchID + ": " + strings.Join(parts, ", ")
+}
+
+// handleUnsettle handles the UNSETTLE command.
+// It returns every SETTLED member of a recorded batch to CAPTURED.
+func (p *Processor) handleUnsettle(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("UNSETTLE requires batch_id")
+	}
+
+	batchID := args[0]
+	if !p.store.BatchIDExists(batchID) {
+		return nil, fmt.Errorf("batch %s not recorded", batchID)
+	}
+
+	payments, _ := p.listForUpdate()
+	count := 0
+	for _, payment := range payments {
+		if payment.BatchID != batchID || payment.State != domain.StateSettled {
+			continue
+		}
+		if err := payment.Unsettle(); err != nil {
+			return nil, err
+		}
+		p.emitTransition(payment)
+		if err := p.save(payment); err != nil {
+			return nil, err
+		}
+		count++
+	}
+
+	return newReportResult("UNSETTLE", "unsettled",
+		fmt.Sprintf("UNSETTLE %s: %d payments returned to CAPTURED", batchID, count)), nil
+}
+
+// handleValidateBatch handles the VALIDATE-BATCH command.
+// It checks that every member of a recorded batch is still SETTLED. Members
+// are payments stamped with the batch ID, plus those UNSETTLE removed from it
+// and that have not joined another batch since.
+func (p *Processor) handleValidateBatch(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("VALIDATE-BATCH requires batch_id")
+	}
+
+	batchID := args[0]
+	if !p.reads.BatchIDExists(batchID) {
+		return nil, fmt.Errorf("batch %s not recorded", batchID)
+	}
+
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	members := 0
+	var drifted []string
+	for _, payment := range payments {
+		if payment.BatchID != batchID && (payment.BatchID != "" || !payment.UnsettledFromBatch(batchID)) {
+			continue
+		}
+		members++
+		if payment.State != domain.StateSettled {
+			drifted = append(drifted, fmt.Sprintf("%s (%s)", payment.ID, payment.State))
+		}
+	}
+
+	if len(drifted) == 0 {
+		return newReportResult("VALIDATE-BATCH", "valid",
+			fmt.Sprintf("VALIDATE-BATCH %s: valid, %d member(s) SETTLED", batchID, members)), nil
+	}
+	return newReportResult("VALIDATE-BATCH", "drifted",
+		fmt.Sprintf("VALIDATE-BATCH %s: %d of %d member(s) drifted: %s",
+			batchID, len(drifted), members, strings.Join(drifted, ", "))), nil
+}
+
+// handleRenameBatch handles the RENAME-BATCH command.
+// It relabels a recorded batch and every payment stamped with it.
+func (p *Processor) handleRenameBatch(args []string) (*Result, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("RENAME-BATCH requires old_id and new_id")
+	}
+
+	oldID, newID := args[0], args[1]
+	if err := p.store.RenameBatch(oldID, newID); err != nil {
+		return nil, fmt.Errorf("cannot rename batch: %v", err)
+	}
+
+	payments, _ := p.store.List()
+	members := 0
+	for _, payment := range payments {
+		if payment.BatchID == newID {
+			members++
+		}
+	}
+	return newReportResult("RENAME-BATCH", "renamed",
+		fmt.Sprintf("Batch %s renamed to %s. Payments re-stamped: %d", oldID, newID, members)), nil
+}
+
+// handleRefundable handles the REFUNDABLE command.
+// It reports how much of the payment can still be refunded.
+func (p *Processor) handleRefundable(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("REFUNDABLE requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	amount, reason := payment.Refundable()
+	message := fmt.Sprintf("REFUNDABLE %s: %s %s", paymentID, domain.FormatMoney(amount, payment.Currency), payment.Currency)
+	if reason != "" {
+		message += " (" + reason + ")"
+	}
+	return newPaymentResult("REFUNDABLE", "reported", payment, message), nil
+}
+
+// handleStatus handles the STATUS command.
+func (p *Processor) handleStatus(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("STATUS requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	msg := fmt.Sprintf("Payment %s: state=%s amount=%s currency=%s merchant=%s",
+		payment.ID, payment.State, domain.FormatMoney(payment.Amount, payment.Currency), payment.Currency, payment.MerchantID)
+	if payment.RefundedAmount != nil {
+		msg += fmt.Sprintf(" refunded=%s", domain.FormatMoney(payment.RefundedAmount, payment.Currency))
+	}
+	if payment.DeclineReason != "" {
+		msg += " decline_reason=" + payment.DeclineReason
+	}
+	if payment.DisputeReason != "" {
+		msg += " dispute_reason=" + payment.DisputeReason
+	}
+	return newPaymentResult("STATUS", "found", payment, msg), nil
+}
+
+// handleWhyReview handles the WHY-REVIEW command.
+// It explains, from the REVIEW history entry, why a payment is in
+// PRE_SETTLEMENT_REVIEW.
+func (p *Processor) handleWhyReview(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("WHY-REVIEW requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	review, ok := payment.LastEntry("REVIEW")
+	if payment.State != domain.StatePreSettlementReview || !ok {
+		return newPaymentResult("WHY-REVIEW", "none", payment,
+			fmt.Sprintf("WHY-REVIEW %s: no review (state %s)", paymentID, payment.State)), nil
+	}
+	return newPaymentResult("WHY-REVIEW", "explained", payment,
+		fmt.Sprintf("WHY-REVIEW %s: %s", paymentID, review.Details)), nil
+}
+
+// handleHistory handles the HISTORY command.
+// An optional limit shows only the most recent entries.
+func (p *Processor) handleHistory(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("HISTORY requires payment_id")
+	}
+
+	paymentID := args[0]
+	limit := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("HISTORY limit must be a positive integer: %s", args[1])
+		}
+		limit = n
+	}
+
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	entries := payment.History
+	var sb strings.Builder
+	if limit > 0 && len(entries) > limit {
+		omitted := len(entries) - limit
+		entries = entries[omitted:]
+		sb.WriteString(fmt.Sprintf("History for %s (last %d of %d, %d earlier entries omitted):",
+			paymentID, limit, len(payment.History), omitted))
+	} else {
+		sb.WriteString(fmt.Sprintf("History for %s (%d entries):", paymentID, len(entries)))
+	}
+	for _, entry := range entries {
+		sb.WriteString(fmt.Sprintf("\n  #%d %s", entry.Seq, formatHistoryEntry(entry)))
+	}
+	return newPaymentResult("HISTORY", "listed", payment, sb.String()), nil
+}
+
+// formatHistoryEntry renders a history entry as
+// "<timestamp> <action> <from> -> <to>[: details]".
+func formatHistoryEntry(entry domain.HistoryEntry) string {
+	from := entry.FromState
+	if from == "" {
+		from = "none"
+	}
+	s := fmt.Sprintf("%s %s %s -> %s", entry.Timestamp.UTC().Format(time.RFC3339), entry.Action, from, entry.ToState)
+	if entry.Details != "" {
+		s += ": " + entry.Details
+	}
+	return s
+}
+
+// handleAudit handles the AUDIT command.
+// AUDIT must have ZERO side effects - it only acknowledges receipt.
+func (p *Processor) handleAudit(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("AUDIT requires payment_id")
+	}
+
+	paymentID := args[0]
+	// Verify payment exists but do NOT mutate anything
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	return newPaymentResult("AUDIT", "received", payment, "AUDIT RECEIVED"), nil
+}
+
+// handlePathsTo handles the PATHS-TO command.
+// It lists the states that can transition directly into the given state.
+func (p *Processor) handlePathsTo(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("PATHS-TO requires state")
+	}
+
+	target := args[0]
+	predecessors, err := domain.Predecessors(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(predecessors) == 0 {
+		return newReportResult("PATHS-TO", "none", fmt.Sprintf("PATHS-TO %s: none", target)), nil
+	}
+	return newReportResult("PATHS-TO", "found",
+		fmt.Sprintf("PATHS-TO %s: %s", target, strings.Join(predecessors, ", "))), nil
+}
+
+// Fixed attributes used by GENERATE for bulk-created payments.
+const (
+	generateAmount   = "10.00"
+	generateCurrency = "USD"
+	generateMerchant = "GEN"
+)
+
+// maxGenerateCount is the most payments one GENERATE may create.
+const maxGenerateCount = 100000
+
+// handleGenerate handles the GENERATE command.
+// It creates <count> INITIATED payments with IDs <prefix>1..<prefix>N.
+// No payment is created if any of the generated IDs already exists.
+func (p *Processor) handleGenerate(args []string) (*Result, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("GENERATE requires 2 arguments: <count> <prefix>")
+	}
+
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count <= 0 {
+		return nil, domain.NewValidationError("count", fmt.Sprintf("must be a positive integer: %s", args[0]))
+	}
+	if count > maxGenerateCount {
+		return nil, domain.NewValidationError("count", fmt.Sprintf("must be at most %d: %s", maxGenerateCount, args[0]))
+	}
+	prefix := args[1]
+
+	// Check for collisions up front so the command is all-or-nothing
+	for i := 1; i <= count; i++ {
+		id := prefix + strconv.Itoa(i)
+		if p.store.Exists(id) {
+			return nil, fmt.Errorf("GENERATE aborted: payment %s already exists", id)
+		}
+	}
+
+	amount, err := domain.ParseAmount(generateAmount)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= count; i++ {
+		payment := domain.NewPaymentWithClock(prefix+strconv.Itoa(i), new(big.Rat).Set(amount), generateCurrency, generateMerchant, p.clock)
+		if err := p.store.Save(payment); err != nil {
+			return nil, fmt.Errorf("failed to save payment: %v", err)
+		}
+	}
+
+	return newReportResult("GENERATE", "generated",
+		fmt.Sprintf("Generated %d payments (%s1..%s%d)", count, prefix, prefix, count)), nil
+}
+
+// handleAuditMoney handles the AUDIT-MONEY command.
+// It checks the consistency of a payment's money flow without side effects.
+func (p *Processor) handleAuditMoney(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("AUDIT-MONEY requires payment_id")
+	}
+
+	paymentID := args[0]
+	payment, err := p.reads.Get(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	breakdown := fmt.Sprintf("authorized=%s captured=%s refunded=%s net=%s %s",
+		payment.FormatAmount(), domain.FormatRat(payment.Captured()),
+		domain.FormatRat(payment.Refunded()), domain.FormatRat(payment.NetAmount()), payment.Currency)
+
+	violations := payment.MoneyViolations()
+	if len(violations) == 0 {
+		return newPaymentResult("AUDIT-MONEY", "consistent", payment,
+			fmt.Sprintf("AUDIT-MONEY %s: consistent (%s)", paymentID, breakdown)), nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("AUDIT-MONEY %s: inconsistent (%s)", paymentID, breakdown))
+	for _, v := range violations {
+		sb.WriteString("\n  " + v)
+	}
+	return newPaymentResult("AUDIT-MONEY", "inconsistent", payment, sb.String()), nil
+}
+
+// handleAssertAllTerminal handles the ASSERT-ALL-TERMINAL command.
+// It fails if any payment is still in a non-terminal state.
+func (p *Processor) handleAssertAllTerminal() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var offenders []string
+	for _, payment := range payments {
+		if !domain.IsTerminal(payment.State) {
+			offenders = append(offenders, fmt.Sprintf("%s (%s)", payment.ID, payment.State))
+		}
+	}
+	if len(offenders) > 0 {
+		return nil, fmt.Errorf("ASSERT-ALL-TERMINAL failed: %d non-terminal payment(s): %s",
+			len(offenders), strings.Join(offenders, ", "))
+	}
+
+	return newReportResult("ASSERT-ALL-TERMINAL", "passed",
+		fmt.Sprintf("ASSERT-ALL-TERMINAL passed: %d payment(s) in terminal states", len(payments))), nil
+}
+
+// handleTick handles the TICK command.
+// It advances the simulated clock; it is only available with -sim-clock.
+func (p *Processor) handleTick(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("TICK requires a duration")
+	}
+
+	clock, ok := p.clock.(*domain.SimClock)
+	if !ok {
+		return nil, fmt.Errorf("TICK requires the simulated clock (-sim-clock)")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid duration: %s", args[0])
+	}
+
+	clock.Advance(d)
+	return newReportResult("TICK", "advanced",
+		fmt.Sprintf("Clock advanced by %s to %s", d, clock.Now().Format(time.RFC3339))), nil
+}
+
+// handleSweep handles the SWEEP command.
+// It voids every payment that has been in PRE_SETTLEMENT_REVIEW for longer
+// than the review TTL.
+func (p *Processor) handleSweep() (*Result, error) {
+	payments, err := p.listForUpdate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var expired []string
+	if p.reviewTTL > 0 {
+		now := p.clock.Now()
+		for _, payment := range payments {
+			if payment.State != domain.StatePreSettlementReview || !p.reviewExpired(payment, now) {
+				continue
+			}
+			if err := payment.ExpireReview(); err != nil {
+				return nil, err
+			}
+			if err := p.save(payment); err != nil {
+				return nil, err
+			}
+			p.emitTransition(payment)
+			expired = append(expired, payment.ID)
+		}
+	}
+
+	if len(expired) == 0 {
+		return newReportResult("SWEEP", "swept", "SWEEP: no expired reviews"), nil
+	}
+	return newReportResult("SWEEP", "swept",
+		fmt.Sprintf("SWEEP: voided %d expired review(s): %s", len(expired), strings.Join(expired, ", "))), nil
+}
+
+// reviewExpired reports whether the payment has been in
+// PRE_SETTLEMENT_REVIEW for longer than the review TTL at now.
+func (p *Processor) reviewExpired(payment *domain.Payment, now time.Time) bool {
+	if p.reviewTTL <= 0 {
+		return false
+	}
+	start, ok := reviewStartedAt(payment)
+	return ok && now.Sub(start) > p.reviewTTL
+}
+
+// reviewStartedAt returns when the payment entered its current
+// PRE_SETTLEMENT_REVIEW, and false if it never did. A REAUTHORIZE out of
+// review and straight back in does not restart the review.
+func reviewStartedAt(payment *domain.Payment) (time.Time, bool) {
+	var start time.Time
+	found := false
+	for i := len(payment.History) - 1; i >= 0; i-- {
+		entry := payment.History[i]
+		switch {
+		case entry.Action == "REVIEW":
+			start, found = entry.Timestamp, true
+		case entry.Action == "REAUTHORIZE" && entry.FromState == domain.StatePreSettlementReview:
+		default:
+			return start, found
+		}
+	}
+	return start, found
+}
+
+// handleChangedSince handles the CHANGED-SINCE command.
+// It lists payments updated at or after the given RFC 3339 time, oldest
+// update first (ties by ID), for polling-based replication.
+func (p *Processor) handleChangedSince(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("CHANGED-SINCE requires a timestamp")
+	}
+
+	since, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp (expected RFC 3339): %s", args[0])
+	}
+
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var changed []*domain.Payment
+	for _, payment := range payments {
+		if !payment.UpdatedAt.Before(since) {
+			changed = append(changed, payment)
+		}
+	}
+	if len(changed) == 0 {
+		return newReportResult("CHANGED-SINCE", "empty",
+			fmt.Sprintf("No payments changed since %s", since.Format(time.RFC3339))), nil
+	}
+	sortPayments(changed, listSortKeys["updated"])
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Payments changed since %s (%d):", since.Format(time.RFC3339), len(changed)))
+	for _, payment := range changed {
+		sb.WriteString(fmt.Sprintf("\n  %s %s updated=%s", payment.ID, payment.State, payment.UpdatedAt.Format(time.RFC3339)))
+	}
+	return newReportResult("CHANGED-SINCE", "listed", sb.String()), nil
+}
+
+// handleDeadEnds handles the DEADENDS command.
+// It reports states of the transition table that cannot be left but are not
+// intended terminal states.
+func (p *Processor) handleDeadEnds() (*Result, error) {
+	terminals := strings.Join(domain.TerminalStates(), ", ")
+	deadEnds := domain.DeadEnds(domain.AllowedTransitions)
+	if len(deadEnds) == 0 {
+		return newReportResult("DEADENDS", "none",
+			fmt.Sprintf("DEADENDS: none (intended terminal states: %s)", terminals)), nil
+	}
+	return newReportResult("DEADENDS", "found",
+		fmt.Sprintf("DEADENDS: %d accidental dead end(s): %s (intended terminal states: %s)",
+			len(deadEnds), strings.Join(deadEnds, ", "), terminals)), nil
+}
+
+// handleOldestOpen handles the OLDEST-OPEN command.
+// It reports the non-terminal payment with the earliest CreatedAt (ties
+// broken by ID) and how long it has been open.
+func (p *Processor) handleOldestOpen() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var oldest *domain.Payment
+	for _, payment := range payments {
+		if domain.IsTerminal(payment.State) {
+			continue
+		}
+		if oldest == nil || payment.CreatedAt.Before(oldest.CreatedAt) ||
+			(payment.CreatedAt.Equal(oldest.CreatedAt) && payment.ID < oldest.ID) {
+			oldest = payment
+		}
+	}
+	if oldest == nil {
+		return newReportResult("OLDEST-OPEN", "none", "No open payments"), nil
+	}
+
+	age := p.clock.Now().Sub(oldest.CreatedAt)
+	return newPaymentResult("OLDEST-OPEN", "found", oldest,
+		fmt.Sprintf("Oldest open payment: %s (%s), open for %s", oldest.ID, oldest.State, age)), nil
+}
+
+// handleSummary handles the SUMMARY command.
+// It totals payment amounts per currency using exact big.Rat arithmetic.
+func (p *Processor) handleSummary() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+	if len(payments) == 0 {
+		return newReportResult("SUMMARY", "empty", "No payments found"), nil
+	}
+
+	totals := make(map[string]*big.Rat)
+	counts := make(map[string]int)
+	for _, payment := range payments {
+		if _, ok := totals[payment.Currency]; !ok {
+			totals[payment.Currency] = new(big.Rat)
+		}
+		totals[payment.Currency].Add(totals[payment.Currency], payment.Amount)
+		counts[payment.Currency]++
+	}
+
+	currencies := make([]string, 0, len(totals))
+	for c := range totals {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Summary: %d payment(s)", len(payments)))
+	for _, c := range currencies {
+		sb.WriteString(fmt.Sprintf("\n  %s: count=%d total=%s", c, counts[c], domain.FormatMoney(totals[c], c)))
+	}
+	return newReportResult("SUMMARY", "summarized", sb.String()), nil
+}
+
+// ledgerTotals are the per-currency money totals reported by STATS.
+type ledgerTotals struct {
+	authorized *big.Rat
+	captured   *big.Rat
+	settled    *big.Rat
+}
+
+// handleStats handles the STATS command.
+// It reports how many payments are in each state and, per currency, the
+// authorized, captured and settled totals. A payment counts as authorized
+// once its history reaches AUTHORIZED; settled totals are the captured
+// amounts of SETTLED payments.
+func (p *Processor) handleStats() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	counts := make(map[string]int)
+	totals := make(map[string]*ledgerTotals)
+	for _, payment := range payments {
+		counts[payment.State]++
+		t, ok := totals[payment.Currency]
+		if !ok {
+			t = &ledgerTotals{authorized: new(big.Rat), captured: new(big.Rat), settled: new(big.Rat)}
+			totals[payment.Currency] = t
+		}
+		if wasAuthorized(payment) {
+			t.authorized.Add(t.authorized, payment.Amount)
+		}
+		t.captured.Add(t.captured, payment.Captured())
+		if payment.State == domain.StateSettled {
+			t.settled.Add(t.settled, payment.Captured())
+		}
+	}
+
+	states := make([]string, 0, len(counts))
+	for s := range counts {
+		states = append(states, s)
+	}
+	sort.Strings(states)
+	currencies := make([]string, 0, len(totals))
+	for c := range totals {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Stats: %d payment(s)", len(payments)))
+	for _, s := range states {
+		sb.WriteString(fmt.Sprintf("\n  %s: %d", s, counts[s]))
+	}
+	for _, c := range currencies {
+		t := totals[c]
+		sb.WriteString(fmt.Sprintf("\n  %s: authorized=%s captured=%s settled=%s", c,
+			domain.FormatMoney(t.authorized, c), domain.FormatMoney(t.captured, c), domain.FormatMoney(t.settled, c)))
+	}
+	return newReportResult("STATS", "reported", sb.String()), nil
+}
+
+// wasAuthorized reports whether the payment's history ever reached AUTHORIZED.
+func wasAuthorized(payment *domain.Payment) bool {
+	for _, entry := range payment.History {
+		if entry.ToState == domain.StateAuthorized {
+			return true
+		}
+	}
+	return false
+}
+
+// unspecifiedReason groups refunds and voids recorded without a reason code.
+const unspecifiedReason = "UNSPECIFIED"
+
+// handleRefundReasons handles the REFUND-REASONS command.
+// It totals refund movements per reason code and currency.
+func (p *Processor) handleRefundReasons() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	type key struct{ reason, currency string }
+	totals := make(map[key]*big.Rat)
+	counts := make(map[key]int)
+	for _, payment := range payments {
+		for _, m := range payment.Movements {
+			if m.Kind != domain.MovementRefund {
+				continue
+			}
+			k := key{reason: m.Reason, currency: m.Currency}
+			if k.reason == "" {
+				k.reason = unspecifiedReason
+			}
+			if _, ok := totals[k]; !ok {
+				totals[k] = new(big.Rat)
+			}
+			totals[k].Add(totals[k], m.Amount)
+			counts[k]++
+		}
+	}
+	if len(totals) == 0 {
+		return newReportResult("REFUND-REASONS", "empty", "No refunds found"), nil
+	}
+
+	keys := make([]key, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].reason != keys[j].reason {
+			return keys[i].reason < keys[j].reason
+		}
+		return keys[i].currency < keys[j].currency
+	})
+
+	var sb strings.Builder
+	sb.WriteString("Refund reasons:")
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("\n  %s: count=%d total=%s %s",
+			k.reason, counts[k], domain.FormatMoney(totals[k], k.currency), k.currency))
+	}
+	return newReportResult("REFUND-REASONS", "reported", sb.String()), nil
+}
+
+// handleVoidStats handles the VOID-STATS command.
+// It reports the share of payments that were voided and the void reason
+// codes ranked by count (ties by reason).
+func (p *Processor) handleVoidStats() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+	if len(payments) == 0 {
+		return newReportResult("VOID-STATS", "empty", "No payments found"), nil
+	}
+
+	counts := make(map[string]int)
+	voided := 0
+	for _, payment := range payments {
+		if payment.State != domain.StateVoided {
+			continue
+		}
+		voided++
+		reason := payment.VoidReason
+		if reason == "" {
+			reason = unspecifiedReason
+		}
+		counts[reason]++
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("VOID-STATS: %d of %d payment(s) voided (%.2f%%)",
+		voided, len(payments), float64(voided)/float64(len(payments))*100))
+	for _, reason := range reasons {
+		sb.WriteString(fmt.Sprintf("\n  %s: %d", reason, counts[reason]))
+	}
+	return newReportResult("VOID-STATS", "reported", sb.String()), nil
+}
+
+// handleStatement handles the STATEMENT command.
+// It totals a merchant's captured funds and refunds per currency. Fees are not
+// tracked by the simulator and are always reported as zero.
+func (p *Processor) handleStatement(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("STATEMENT requires merchant_id")
+	}
+
+	merchantID := args[0]
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	type totals struct {
+		gross, refunds, adjustments *big.Rat
+		count                       int
+	}
+	byCurrency := make(map[string]*totals)
+	for _, payment := range payments {
+		// Only settled (or since refunded) funds count toward the payout
+		if payment.MerchantID != merchantID ||
+			(payment.State != domain.StateSettled && payment.State != domain.StateRefunded) {
+			continue
+		}
+		t, ok := byCurrency[payment.Currency]
+		if !ok {
+			t = &totals{gross: new(big.Rat), refunds: new(big.Rat), adjustments: new(big.Rat)}
+			byCurrency[payment.Currency] = t
+		}
+		t.gross.Add(t.gross, payment.Captured())
+		t.refunds.Add(t.refunds, payment.Refunded())
+		t.adjustments.Add(t.adjustments, payment.Adjusted())
+		t.count++
+	}
+
+	if len(byCurrency) == 0 {
+		return newReportResult("STATEMENT", "empty",
+			fmt.Sprintf("STATEMENT %s: no settled payments", merchantID)), nil
+	}
+
+	currencies := make([]string, 0, len(byCurrency))
+	for c := range byCurrency {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("STATEMENT %s:", merchantID))
+	for _, c := range currencies {
+		t := byCurrency[c]
+		fees := new(big.Rat)
+		net := new(big.Rat).Sub(t.gross, t.refunds)
+		net.Sub(net, fees)
+		net.Add(net, t.adjustments)
+		sb.WriteString(fmt.Sprintf("\n  %s: gross=%s refunds=%s fees=%s adjustments=%s net=%s (payments=%d)", c,
+			domain.FormatMoney(t.gross, c), domain.FormatMoney(t.refunds, c), domain.FormatMoney(fees, c),
+			domain.FormatMoney(t.adjustments, c), domain.FormatMoney(net, c), t.count))
+	}
+	return newReportResult("STATEMENT", "reported", sb.String()), nil
+}
+
+// handlePosition handles the POSITION command.
+// It is the all-merchant aggregate of STATEMENT: per currency, the captured
+// inflows of SETTLED and REFUNDED payments, minus refunds, plus signed
+// adjustments. Payments still CAPTURED have not settled and are excluded.
+func (p *Processor) handlePosition() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	type totals struct {
+		inflows, refunds, adjustments, net *big.Rat
+		count                              int
+	}
+	byCurrency := make(map[string]*totals)
+	for _, payment := range payments {
+		if payment.State != domain.StateSettled && payment.State != domain.StateRefunded {
+			continue
+		}
+		t, ok := byCurrency[payment.Currency]
+		if !ok {
+			t = &totals{inflows: new(big.Rat), refunds: new(big.Rat), adjustments: new(big.Rat), net: new(big.Rat)}
+			byCurrency[payment.Currency] = t
+		}
+		t.inflows.Add(t.inflows, payment.Captured())
+		t.refunds.Add(t.refunds, payment.Refunded())
+		t.adjustments.Add(t.adjustments, payment.Adjusted())
+		t.net.Add(t.net, payment.NetAmount())
+		t.count++
+	}
+
+	if len(byCurrency) == 0 {
+		return newReportResult("POSITION", "empty", "POSITION: no settled payments"), nil
+	}
+
+	currencies := make([]string, 0, len(byCurrency))
+	for c := range byCurrency {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	var sb strings.Builder
+	sb.WriteString("POSITION:")
+	for _, c := range currencies {
+		t := byCurrency[c]
+		sb.WriteString(fmt.Sprintf("\n  %s: inflows=%s refunds=%s adjustments=%s net=%s (payments=%d)", c,
+			domain.FormatMoney(t.inflows, c), domain.FormatMoney(t.refunds, c),
+			domain.FormatMoney(t.adjustments, c), domain.FormatMoney(t.net, c), t.count))
+	}
+	return newReportResult("POSITION", "reported", sb.String()), nil
+}
+
+// handleCaptureRate handles the CAPTURE-RATE command.
+// It reports the fraction of a merchant's payments that were ever authorized
+// and went on to be captured. History is consulted so that payments voided
+// or reversed after authorization still count as authorized.
+func (p *Processor) handleCaptureRate(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("CAPTURE-RATE requires merchant_id")
+	}
+
+	merchantID := args[0]
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	authorized, captured := 0, 0
+	for _, payment := range payments {
+		if payment.MerchantID != merchantID || !reachedState(payment, domain.StateAuthorized) {
+			continue
+		}
+		authorized++
+		if reachedState(payment, domain.StateCaptured) {
+			captured++
+		}
+	}
+
+	if authorized == 0 {
+		return newReportResult("CAPTURE-RATE", "empty",
+			fmt.Sprintf("CAPTURE-RATE %s: no authorized payments", merchantID)), nil
+	}
+	rate := float64(captured) / float64(authorized) * 100
+	return newReportResult("CAPTURE-RATE", "reported",
+		fmt.Sprintf("CAPTURE-RATE %s: %d of %d authorized payment(s) captured (%.2f%%)", merchantID, captured, authorized, rate)), nil
+}
+
+// reachedState reports whether the payment's history ever entered state.
+func reachedState(payment *domain.Payment, state string) bool {
+	for _, entry := range payment.History {
+		if entry.ToState == state {
+			return true
+		}
+	}
+	return false
+}
+
+// settleLag accumulates capture-to-settle durations.
+type settleLag struct {
+	count          int
+	total          time.Duration
+	minLag, maxLag time.Duration
+}
+
+func (l *settleLag) add(d time.Duration) {
+	if l.count == 0 || d < l.minLag {
+		l.minLag = d
+	}
+	if l.count == 0 || d > l.maxLag {
+		l.maxLag = d
+	}
+	l.count++
+	l.total += d
+}
+
+func (l *settleLag) String() string {
+	return fmt.Sprintf("%d payment(s), avg %s, min %s, max %s",
+		l.count, l.total/time.Duration(l.count), l.minLag, l.maxLag)
+}
+
+// handleSettleLag handles the SETTLE-LAG command.
+// It reports the average, minimum and maximum time between the CAPTURE and
+// SETTLE history entries of settled payments, overall and per currency.
+// Settled payments without a CAPTURE entry (e.g. imported) are skipped.
+func (p *Processor) handleSettleLag() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var overall settleLag
+	byCurrency := make(map[string]*settleLag)
+	for _, payment := range payments {
+		if payment.State != domain.StateSettled {
+			continue
+		}
+		captured, ok := payment.LastEntry("CAPTURE")
+		if !ok {
+			continue
+		}
+		settled, ok := payment.LastEntry("SETTLE")
+		if !ok {
+			continue
+		}
+		lag := settled.Timestamp.Sub(captured.Timestamp)
+		overall.add(lag)
+		if byCurrency[payment.Currency] == nil {
+			byCurrency[payment.Currency] = &settleLag{}
+		}
+		byCurrency[payment.Currency].add(lag)
+	}
+
+	if overall.count == 0 {
+		return newReportResult("SETTLE-LAG", "empty", "SETTLE-LAG: no settled payments"), nil
+	}
+
+	currencies := make([]string, 0, len(byCurrency))
+	for c := range byCurrency {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	var sb strings.Builder
+	sb.WriteString("SETTLE-LAG: " + overall.String())
+	for _, c := range currencies {
+		sb.WriteString(fmt.Sprintf("\n  %s: %s", c, byCurrency[c]))
+	}
+	return newReportResult("SETTLE-LAG", "reported", sb.String()), nil
+}
+
+// handleCheckCurrency handles the CHECK-CURRENCY command.
+// It reports whether all of a merchant's payments share one currency, and
+// the per-currency counts when they do not.
+func (p *Processor) handleCheckCurrency(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("CHECK-CURRENCY requires merchant_id")
+	}
+
+	merchantID := args[0]
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	counts := make(map[string]int)
+	total := 0
+	for _, payment := range payments {
+		if payment.MerchantID == merchantID {
+			counts[payment.Currency]++
+			total++
+		}
+	}
+
+	if total == 0 {
+		return newReportResult("CHECK-CURRENCY", "empty",
+			fmt.Sprintf("CHECK-CURRENCY %s: no payments", merchantID)), nil
+	}
+
+	currencies := make([]string, 0, len(counts))
+	for c := range counts {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	if len(currencies) == 1 {
+		return newReportResult("CHECK-CURRENCY", "consistent",
+			fmt.Sprintf("CHECK-CURRENCY %s: consistent, %d payment(s) in %s", merchantID, total, currencies[0])), nil
+	}
+	mix := make([]string, len(currencies))
+	for i, c := range currencies {
+		mix[i] = fmt.Sprintf("%s=%d", c, counts[c])
+	}
+	return newReportResult("CHECK-CURRENCY", "mixed",
+		fmt.Sprintf("CHECK-CURRENCY %s: mixed currencies: %s", merchantID, strings.Join(mix, ", "))), nil
+}
+
+// handleVerify handles the VERIFY command.
+// It checks the money flow of every payment in the store without side effects.
+func (p *Processor) handleVerify() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var sb strings.Builder
+	inconsistent := 0
+	for _, payment := range payments {
+		violations := payment.MoneyViolations()
+		if len(violations) > 0 {
+			inconsistent++
+		}
+		for _, v := range violations {
+			sb.WriteString(fmt.Sprintf("\n  %s: %s", payment.ID, v))
+		}
+	}
+
+	if inconsistent == 0 {
+		return newReportResult("VERIFY", "consistent",
+			fmt.Sprintf("VERIFY: %d payments checked, all consistent", len(payments))), nil
+	}
+	return newReportResult("VERIFY", "inconsistent",
+		fmt.Sprintf("VERIFY: %d of %d payments inconsistent%s", inconsistent, len(payments), sb.String())), nil
+}
+
+// handleVerifyHistory handles the VERIFY-HISTORY command.
+// It checks that every payment's history is a valid transition chain ending
+// in its current state, without side effects.
+func (p *Processor) handleVerifyHistory() (*Result, error) {
+	payments, err := p.reads.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %v", err)
+	}
+
+	var sb strings.Builder
+	inconsistent := 0
+	for _, payment := range payments {
+		violations := payment.HistoryViolations()
+		if len(violations) > 0 {
+			inconsistent++
+		}
+		for _, v := range violations {
+			sb.WriteString(fmt.Sprintf("\n  %s: %s", payment.ID, v))
+		}
+	}
+
+	if inconsistent == 0 {
+		return newReportResult("VERIFY-HISTORY", "consistent",
+			fmt.Sprintf("VERIFY-HISTORY: %d payments checked, all consistent", len(payments))), nil
+	}
+	return newReportResult("VERIFY-HISTORY", "inconsistent",
+		fmt.Sprintf("VERIFY-HISTORY: %d of %d payments inconsistent%s", inconsistent, len(payments), sb.String())), nil
+}
+
+// handleCheckpoint handles the CHECKPOINT command and its SNAPSHOT alias.
+// It saves a named snapshot of the store, replacing any earlier one.
+func (p *Processor) handleCheckpoint(command string, args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s requires name", command)
+	}
+
+	snapshotter, ok := p.store.(store.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("store does not support checkpoints")
+	}
+
+	name := args[0]
+	snapshot, err := snapshotter.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint: %v", err)
+	}
+	p.checkpoints[name] = snapshot
+
+	return newReportResult(command, "saved", fmt.Sprintf("Checkpoint %s saved", name)), nil
+}
+
+// handleRestore handles the RESTORE command.
+// It rolls the store back to a named checkpoint.
+func (p *Processor) handleRestore(args []string) (*Result, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("RESTORE requires name")
+	}
+
+	snapshotter, ok := p.store.(store.Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("store does not support checkpoints")
+	}
+
+	name := args[0]
+	snapshot, exists := p.checkpoints[name]
+	if !exists {
+		return nil, fmt.Errorf("checkpoint %s not found", name)
+	}
+	if err := snapshotter.Restore(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to restore checkpoint: %v", err)
 	}
 
-	return "AUDIT RECEIVED", nil
+	return newReportResult("RESTORE", "restored", fmt.Sprintf("Restored checkpoint %s", name)), nil
 }
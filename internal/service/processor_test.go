@@ -1,10 +1,19 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"payment-sim/internal/domain"
 	"payment-sim/internal/parser"
 	"payment-sim/internal/store"
 )
@@ -287,6 +296,239 @@ func TestPreSettlementReview_BelowThreshold(t *testing.T) {
 	}
 }
 
+func TestPreSettlementReview_SettleRequiresCapture(t *testing.T) {
+	p := newTestProcessorWithThreshold("100")
+
+	p.Execute(parseCmd(t, "CREATE P001 200.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	_, err := p.Execute(parseCmd(t, "SETTLE P001"))
+	want := "payment P001 is in PRE_SETTLEMENT_REVIEW and must be captured before settlement"
+	if err == nil || err.Error() != want {
+		t.Errorf("SETTLE error = %v, want %q", err, want)
+	}
+}
+
+func TestReverse_FromReview(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+	p.Execute(parseCmd(t, "CREATE P001 1500.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	if _, err := p.Execute(parseCmd(t, "REVERSE P001")); err != nil {
+		t.Fatalf("REVERSE failed: %v", err)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateReversed {
+		t.Errorf("state = %s, want REVERSED", payment.State)
+	}
+}
+
+func TestCapture_ReversedAuthorization(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "REVERSE P001"))
+
+	_, err := p.Execute(parseCmd(t, "CAPTURE P001"))
+	want := "cannot capture: authorization was reversed"
+	if err == nil || err.Error() != want {
+		t.Errorf("CAPTURE error = %v, want %q", err, want)
+	}
+}
+
+func TestAuthorize_Decline(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	result, err := p.Execute(parseCmd(t, "AUTHORIZE P001 DECLINE INSUFFICIENT_FUNDS"))
+	if err != nil {
+		t.Fatalf("AUTHORIZE DECLINE failed: %v", err)
+	}
+	if want := "Payment P001 declined by issuer (INSUFFICIENT_FUNDS)"; result != want {
+		t.Errorf("AUTHORIZE = %q, want %q", result, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateDeclined {
+		t.Errorf("state = %s, want DECLINED", payment.State)
+	}
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.HasSuffix(status, "decline_reason=INSUFFICIENT_FUNDS") {
+		t.Errorf("STATUS = %q, want the decline reason", status)
+	}
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err == nil {
+		t.Error("expected AUTHORIZE of a declined payment to fail")
+	}
+
+	// A plain AUTHORIZE is unaffected
+	p.Execute(parseCmd(t, "CREATE P002 100.00 USD M001"))
+	if result, err := p.Execute(parseCmd(t, "AUTHORIZE P002")); err != nil || result != "Payment P002 authorized" {
+		t.Errorf("AUTHORIZE = %q, %v; want authorized", result, err)
+	}
+}
+
+func TestAuthorize_DeclineDefaultReason(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001 DECLINE"))
+
+	payment, _ := p.store.Get("P001")
+	if payment.DeclineReason != domain.IssuerDeclinedReason {
+		t.Errorf("DeclineReason = %q, want %q", payment.DeclineReason, domain.IssuerDeclinedReason)
+	}
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001 MAYBE")); err == nil {
+		t.Error("expected an error for an unknown AUTHORIZE option")
+	}
+}
+
+func TestDispute_WonReturnsToPriorState(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 50.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+	p.Execute(parseCmd(t, "CAPTURE P002"))
+
+	result, err := p.Execute(parseCmd(t, "DISPUTE P001 FRAUD"))
+	if err != nil {
+		t.Fatalf("DISPUTE failed: %v", err)
+	}
+	if want := "Payment P001 disputed (reason: FRAUD)"; result != want {
+		t.Errorf("DISPUTE = %q, want %q", result, want)
+	}
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "state=DISPUTED") || !strings.HasSuffix(status, "dispute_reason=FRAUD") {
+		t.Errorf("STATUS = %q, want DISPUTED with the dispute reason", status)
+	}
+
+	result, err = p.Execute(parseCmd(t, "DISPUTE_WON P001"))
+	if err != nil {
+		t.Fatalf("DISPUTE_WON failed: %v", err)
+	}
+	if want := "Payment P001 dispute won; returned to SETTLED"; result != want {
+		t.Errorf("DISPUTE_WON = %q, want %q", result, want)
+	}
+
+	p.Execute(parseCmd(t, "DISPUTE P002 NOT_RECEIVED"))
+	p.Execute(parseCmd(t, "DISPUTE_WON P002"))
+	if payment, _ := p.store.Get("P002"); payment.State != domain.StateCaptured {
+		t.Errorf("P002 state = %s after DISPUTE_WON, want CAPTURED", payment.State)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "DISPUTE_WON P002")); err == nil {
+		t.Error("DISPUTE_WON without an open dispute should fail")
+	}
+}
+
+func TestDispute_LostChargesBack(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "DISPUTE P001 FRAUD"))
+
+	// Only the dispute resolution commands leave DISPUTED
+	for _, line := range []string{"SETTLE P001", "CAPTURE P001"} {
+		if _, err := p.Execute(parseCmd(t, line)); err == nil || !strings.Contains(err.Error(), "must be resolved") {
+			t.Errorf("%s on a DISPUTED payment error = %v, want open dispute error", line, err)
+		}
+	}
+
+	result, err := p.Execute(parseCmd(t, "DISPUTE_LOST P001"))
+	if err != nil {
+		t.Fatalf("DISPUTE_LOST failed: %v", err)
+	}
+	if want := "Payment P001 dispute lost; charged back"; result != want {
+		t.Errorf("DISPUTE_LOST = %q, want %q", result, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateChargedBack || !domain.IsTerminal(payment.State) {
+		t.Errorf("state = %s, want terminal CHARGED_BACK", payment.State)
+	}
+	if violations := payment.HistoryViolations(); violations != nil {
+		t.Errorf("history violations = %v", violations)
+	}
+	if violations := payment.MoneyViolations(); violations != nil {
+		t.Errorf("money violations = %v", violations)
+	}
+
+	p.Execute(parseCmd(t, "CREATE P002 50.00 USD M001"))
+	if _, err := p.Execute(parseCmd(t, "DISPUTE P002 FRAUD")); err == nil {
+		t.Error("DISPUTE of an INITIATED payment should fail")
+	}
+}
+
+func TestWhyReview(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+	p.Execute(parseCmd(t, "CREATE P001 1500.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CREATE P002 50.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"P001", "WHY-REVIEW P001: amount 1500.00 USD >= threshold 1000"},
+		{"P002", "WHY-REVIEW P002: no review (state AUTHORIZED)"},
+	}
+	for _, tt := range tests {
+		result, err := p.Execute(parseCmd(t, "WHY-REVIEW "+tt.id))
+		if err != nil || result != tt.want {
+			t.Errorf("WHY-REVIEW %s = %q, %v; want %q", tt.id, result, err, tt.want)
+		}
+	}
+}
+
+func TestTransitionHook_RecordsCascadedReview(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+
+	var events []string
+	p.AddTransitionHook(func(e TransitionEvent) {
+		events = append(events, fmt.Sprintf("%s %s->%s %s", e.PaymentID, e.From, e.To, e.Action))
+	})
+
+	p.Execute(parseCmd(t, "CREATE P001 1500.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "SETTLE P001")) // rejected, no event
+
+	want := []string{
+		"P001 INITIATED->AUTHORIZED AUTHORIZE",
+		"P001 AUTHORIZED->PRE_SETTLEMENT_REVIEW REVIEW",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestTransitionStats_TalliesEdges(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+
+	settlePayment(t, p, "P001")
+	settlePayment(t, p, "P002")
+	for _, line := range []string{
+		"CREATE P003 5000.00 USD M001", // above threshold
+		"AUTHORIZE P003",
+		"CAPTURE P003",
+		"CREATE P004 10.00 USD M001",
+		"VOID P004",
+		"SETTLE P004", // rejected, not counted
+	} {
+		p.Execute(parseCmd(t, line))
+	}
+
+	result, err := p.Execute(parseCmd(t, "TRANSITION-STATS"))
+	if err != nil {
+		t.Fatalf("TRANSITION-STATS failed: %v", err)
+	}
+	want := "Transition counts:\n" +
+		"  AUTHORIZED->CAPTURED: 2\n" +
+		"  AUTHORIZED->PRE_SETTLEMENT_REVIEW: 1\n" +
+		"  CAPTURED->SETTLED: 2\n" +
+		"  INITIATED->AUTHORIZED: 3\n" +
+		"  INITIATED->VOIDED: 1\n" +
+		"  PRE_SETTLEMENT_REVIEW->CAPTURED: 1"
+	if result != want {
+		t.Errorf("TRANSITION-STATS =\n%v\nwant\n%v", result, want)
+	}
+}
+
 func TestPreSettlementReview_CaptureFromReview(t *testing.T) {
 	p := newTestProcessorWithThreshold("100")
 
@@ -345,7 +587,7 @@ func TestSettlement_RecordsBatchID(t *testing.T) {
 	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
 	p.Execute(parseCmd(t, "AUTHORIZE P001"))
 	p.Execute(parseCmd(t, "CAPTURE P001"))
-	p.Execute(parseCmd(t, "SETTLE P001"))
+	p.Execute(parseCmd(t, "SETTLE P001 BATCH001"))
 
 	result, err := p.Execute(parseCmd(t, "SETTLEMENT BATCH001"))
 	if err != nil {
@@ -359,6 +601,46 @@ func TestSettlement_RecordsBatchID(t *testing.T) {
 	}
 }
 
+func TestSettle_TagsBatch(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+	p.Execute(parseCmd(t, "CAPTURE P002"))
+
+	result, err := p.Execute(parseCmd(t, "SETTLE P002 BATCH001"))
+	if err != nil {
+		t.Fatalf("SETTLE with batch failed: %v", err)
+	}
+	if result != "Payment P002 settled in batch BATCH001" {
+		t.Errorf("SETTLE result = %q", result)
+	}
+	if payment, _ := p.store.Get("P001"); payment.BatchID != "" {
+		t.Errorf("P001 BatchID = %q, want unassigned", payment.BatchID)
+	}
+
+	// A replay into the same batch is idempotent; another batch is not
+	if _, err := p.Execute(parseCmd(t, "SETTLE P002 BATCH001")); err != nil {
+		t.Errorf("idempotent SETTLE into the same batch failed: %v", err)
+	}
+	if _, err := p.Execute(parseCmd(t, "SETTLE P001 BATCH001")); err == nil {
+		t.Error("SETTLE of a payment settled without a batch should not tag it")
+	}
+
+	result, _ = p.Execute(parseCmd(t, "SETTLEMENT BATCH001"))
+	if result != "SETTLEMENT BATCH001 recorded. Settled payments: 1\nBATCH001: USD 100.00 (1 payment)" {
+		t.Errorf("SETTLEMENT result = %q, want only the tagged payment", result)
+	}
+
+	// A recorded batch is closed to further SETTLEs
+	p.Execute(parseCmd(t, "CREATE P003 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	p.Execute(parseCmd(t, "CAPTURE P003"))
+	if _, err := p.Execute(parseCmd(t, "SETTLE P003 BATCH001")); err == nil || err.Error() != "batch BATCH001 already recorded" {
+		t.Errorf("SETTLE into a recorded batch error = %v", err)
+	}
+}
+
 // Edge Case Tests
 
 func TestPaymentNotFound(t *testing.T) {
@@ -406,6 +688,39 @@ func TestVoidFromInitiated(t *testing.T) {
 	}
 }
 
+func TestDelete(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 50.00 USD M001"))
+	p.Execute(parseCmd(t, "VOID P001"))
+
+	result, err := p.Execute(parseCmd(t, "DELETE P001"))
+	if err != nil {
+		t.Fatalf("DELETE of VOIDED payment failed: %v", err)
+	}
+	if result != "Payment P001 deleted" {
+		t.Errorf("DELETE result = %q, want %q", result, "Payment P001 deleted")
+	}
+	if p.store.Exists("P001") {
+		t.Error("P001 still in store after DELETE")
+	}
+
+	// Active payments cannot be deleted
+	_, err = p.Execute(parseCmd(t, "DELETE P002"))
+	if err == nil || !strings.Contains(err.Error(), "in state INITIATED") {
+		t.Errorf("DELETE of INITIATED payment error = %v, want state error", err)
+	}
+	if !p.store.Exists("P002") {
+		t.Error("P002 removed by rejected DELETE")
+	}
+
+	_, err = p.Execute(parseCmd(t, "DELETE P001"))
+	if err == nil || err.Error() != "payment P001 not found" {
+		t.Errorf("DELETE of missing payment error = %v, want %q", err, "payment P001 not found")
+	}
+}
+
 // Additional tests for 100% coverage
 
 func TestRefundWithAmount(t *testing.T) {
@@ -424,6 +739,85 @@ func TestRefundWithAmount(t *testing.T) {
 	}
 }
 
+func TestRefund_PartialKeepsCaptured(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	result, err := p.Execute(parseCmd(t, "REFUND P001 30.00"))
+	if err != nil {
+		t.Fatalf("partial REFUND failed: %v", err)
+	}
+	if want := "Payment P001 partially refunded 30.00 USD (refunded 30.00 of 100.00 USD)"; result != want {
+		t.Errorf("REFUND = %q, want %q", result, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateCaptured {
+		t.Errorf("state after partial refund = %s, want CAPTURED", payment.State)
+	}
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if want := "Payment P001: state=CAPTURED amount=100.00 currency=USD merchant=M001 refunded=30.00"; status != want {
+		t.Errorf("STATUS = %q, want %q", status, want)
+	}
+	entry, ok := payment.LastEntry("REFUND")
+	if !ok || entry.FromState != domain.StateCaptured || entry.ToState != domain.StateCaptured ||
+		entry.Details != "Partially refunded 30.00 USD, 70.00 USD still refundable" {
+		t.Errorf("partial refund history entry = %+v, want CAPTURED->CAPTURED with amounts", entry)
+	}
+
+	// More than the remaining 70.00 is rejected
+	if _, err := p.Execute(parseCmd(t, "REFUND P001 70.01")); err == nil ||
+		err.Error() != "refund amount 70.01 exceeds refundable 70.00 USD" {
+		t.Errorf("over-refund error = %v", err)
+	}
+
+	// Refunding the remainder completes the refund
+	p.Execute(parseCmd(t, "REFUND P001 45.00"))
+	if _, err := p.Execute(parseCmd(t, "REFUND P001 25.00")); err != nil {
+		t.Fatalf("final REFUND failed: %v", err)
+	}
+	payment, _ = p.store.Get("P001")
+	if payment.State != domain.StateRefunded {
+		t.Errorf("state after full refund = %s, want REFUNDED", payment.State)
+	}
+	if payment.Refunded().Cmp(big.NewRat(100, 1)) != 0 {
+		t.Errorf("refunded = %s, want 100", payment.Refunded().FloatString(2))
+	}
+	if v := payment.MoneyViolations(); len(v) != 0 {
+		t.Errorf("MoneyViolations() = %v, want none", v)
+	}
+	if v := payment.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+}
+
+func TestRefundInvalidAmount(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"-5.00", "invalid refund amount: amount must be positive: -5.00"},
+		{"abc", "invalid refund amount: invalid amount format: abc"},
+	}
+	for _, tt := range tests {
+		_, err := p.Execute(parseCmd(t, "REFUND P001 "+tt.amount))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("REFUND P001 %s error = %v, want %q", tt.amount, err, tt.want)
+		}
+	}
+
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateCaptured {
+		t.Errorf("state = %s, want CAPTURED after rejected refunds", payment.State)
+	}
+}
+
 func TestAuthorizeNotFound(t *testing.T) {
 	p := newTestProcessor()
 
@@ -433,6 +827,64 @@ func TestAuthorizeNotFound(t *testing.T) {
 	}
 }
 
+func TestCapture_PartialAmount(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	result, err := p.Execute(parseCmd(t, "CAPTURE P001 60.00"))
+	if err != nil {
+		t.Fatalf("partial CAPTURE failed: %v", err)
+	}
+	if want := "Payment P001 captured 60.00 of 100.00 USD"; result != want {
+		t.Errorf("CAPTURE = %q, want %q", result, want)
+	}
+
+	// REFUND works against the captured amount, not the authorized one
+	if _, err := p.Execute(parseCmd(t, "REFUND P001 60.01")); err == nil {
+		t.Error("expected a refund above the captured amount to fail")
+	}
+	p.Execute(parseCmd(t, "REFUND P001 10.00"))
+
+	result, err = p.Execute(parseCmd(t, "SETTLE P001"))
+	if err != nil {
+		t.Fatalf("SETTLE failed: %v", err)
+	}
+	if want := "Payment P001 settled (released uncaptured remainder 40.00 USD)"; result != want {
+		t.Errorf("SETTLE = %q, want %q", result, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if net := payment.NetAmount(); net.Cmp(big.NewRat(50, 1)) != 0 {
+		t.Errorf("net = %s, want 50.00", net.FloatString(2))
+	}
+}
+
+func TestCapture_InvalidAmount(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"100.01", "capture amount 100.01 exceeds authorized 100.00 USD"},
+		{"0", "invalid capture amount: amount must be positive: 0"},
+		{"abc", "invalid capture amount: invalid amount format: abc"},
+	}
+	for _, tt := range tests {
+		_, err := p.Execute(parseCmd(t, "CAPTURE P001 "+tt.amount))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("CAPTURE P001 %s error = %v, want %q", tt.amount, err, tt.want)
+		}
+	}
+
+	// An explicit full amount is an ordinary capture
+	if result, err := p.Execute(parseCmd(t, "CAPTURE P001 100.00")); err != nil || result != "Payment P001 captured" {
+		t.Errorf("CAPTURE = %q, %v; want a full capture", result, err)
+	}
+}
+
 func TestCaptureNotFound(t *testing.T) {
 	p := newTestProcessor()
 
@@ -501,6 +953,94 @@ func TestExecuteUnknownCommand(t *testing.T) {
 	}
 }
 
+func TestSettlement_MaxBatchSize(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+	p.SetMaxBatchSize(2)
+
+	settlePaymentInBatch(t, p, "P001", "BATCH1")
+	settlePaymentInBatch(t, p, "P002", "BATCH1")
+	p.Execute(parseCmd(t, "CREATE P003 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	p.Execute(parseCmd(t, "CAPTURE P003"))
+
+	_, err := p.Execute(parseCmd(t, "SETTLE P003 BATCH1"))
+	if err == nil || err.Error() != "batch BATCH1 is full (max 2 payments)" {
+		t.Fatalf("SETTLE into a full batch error = %v", err)
+	}
+	if payment, _ := memStore.Get("P003"); payment.State != domain.StateCaptured {
+		t.Errorf("P003 state = %s after rejected SETTLE, want CAPTURED", payment.State)
+	}
+	if _, err := p.Execute(parseCmd(t, "SETTLE P003 BATCH2")); err != nil {
+		t.Fatalf("SETTLE into a new batch failed: %v", err)
+	}
+
+	result, err := p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	if err != nil {
+		t.Fatalf("SETTLEMENT failed: %v", err)
+	}
+	if result != "SETTLEMENT BATCH1 recorded. Settled payments: 2\nBATCH1: USD 200.00 (2 payments)" {
+		t.Errorf("SETTLEMENT result = %v", result)
+	}
+
+	want := map[string]string{"P001": "BATCH1", "P002": "BATCH1", "P003": "BATCH2"}
+	for id, batch := range want {
+		if payment, _ := memStore.Get(id); payment.BatchID != batch {
+			t.Errorf("%s BatchID = %q, want %q", id, payment.BatchID, batch)
+		}
+	}
+}
+
+func TestSettlement_CapturedTotal(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+	settlePaymentInBatch(t, p, "P001", "BATCH1")
+
+	// Partially captured payment: authorized 100.00, captured 60.00
+	partial := domain.NewPayment("P002", big.NewRat(100, 1), "USD", "M001")
+	partial.TransitionTo(domain.StateAuthorized, "AUTHORIZE", "")
+	partial.TransitionTo(domain.StateCaptured, "CAPTURE", "")
+	partial.RecordCapture(big.NewRat(60, 1), "USD")
+	partial.TransitionTo(domain.StateSettled, "SETTLE", "")
+	partial.AssignBatch("BATCH1")
+	memStore.Save(partial)
+
+	result, err := p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	if err != nil {
+		t.Fatalf("SETTLEMENT failed: %v", err)
+	}
+	want := "SETTLEMENT BATCH1 recorded. Settled payments: 2\nBATCH1: USD 160.00 (2 payments)"
+	if result != want {
+		t.Errorf("SETTLEMENT result = %v, want %v", result, want)
+	}
+}
+
+func TestSettlement_ReportByCurrency(t *testing.T) {
+	p := newTestProcessor()
+	settlePaymentInBatch(t, p, "P001", "BATCH001")
+	settlePaymentInBatch(t, p, "P002", "BATCH001")
+	p.Execute(parseCmd(t, "CREATE P003 40.50 EUR M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	p.Execute(parseCmd(t, "CAPTURE P003"))
+	p.Execute(parseCmd(t, "SETTLE P003 BATCH001"))
+	settlePayment(t, p, "P004")
+	settlePaymentInBatch(t, p, "P005", "BATCH002")
+
+	result, err := p.Execute(parseCmd(t, "SETTLEMENT BATCH001"))
+	if err != nil {
+		t.Fatalf("SETTLEMENT failed: %v", err)
+	}
+	want := "SETTLEMENT BATCH001 recorded. Settled payments: 3\nBATCH001: EUR 40.50 (1 payment), USD 200.00 (2 payments)"
+	if result != want {
+		t.Errorf("SETTLEMENT result =\n%v\nwant\n%v", result, want)
+	}
+
+	_, err = p.Execute(parseCmd(t, "SETTLEMENT BATCH001"))
+	if err == nil || err.Error() != "batch BATCH001 already recorded" {
+		t.Errorf("repeated SETTLEMENT error = %v, want already recorded", err)
+	}
+}
+
 func TestSettlementNoSettledPayments(t *testing.T) {
 	p := newTestProcessor()
 
@@ -614,36 +1154,2687 @@ func TestAuthorizeInvalidTransition(t *testing.T) {
 	}
 }
 
-func TestCreateAfterPaymentProgressed(t *testing.T) {
+func TestCreateAuto_SequentialIDs(t *testing.T) {
 	p := newTestProcessor()
 
-	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
-	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	first, err := p.ExecuteResult(parseCmd(t, "CREATE AUTO 100.00 USD M001"))
+	if err != nil {
+		t.Fatalf("CREATE AUTO failed: %v", err)
+	}
+	second, _ := p.ExecuteResult(parseCmd(t, "CREATE AUTO 100.00 USD M001"))
+	if first.PaymentID != "PAY-000001" || second.PaymentID != "PAY-000002" {
+		t.Errorf("generated IDs = %v, %v, want PAY-000001, PAY-000002", first.PaymentID, second.PaymentID)
+	}
+	if first.Message != "Payment PAY-000001 created: 100.0 USD" {
+		t.Errorf("CREATE AUTO result = %v", first.Message)
+	}
 
-	// Try to CREATE again with same attrs - should fail because state is AUTHORIZED
-	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
-	if err == nil {
-		t.Error("CREATE should fail when payment has progressed beyond INITIATED")
+	status, err := p.Execute(parseCmd(t, "STATUS PAY-000002"))
+	if err != nil || !strings.Contains(status, "state=INITIATED") {
+		t.Errorf("STATUS of generated ID = %v, %v", status, err)
 	}
-	if !strings.Contains(err.Error(), "already exists in state") {
-		t.Errorf("Expected 'already exists in state' error, got: %v", err)
+}
+
+func TestCreateAuto_SkipsExistingIDs(t *testing.T) {
+	p := newTestProcessor()
+	p.SetAutoIDSeed(7)
+
+	p.Execute(parseCmd(t, "CREATE PAY-000007 5.00 USD M001"))
+	result, _ := p.ExecuteResult(parseCmd(t, "CREATE AUTO 100.00 USD M001"))
+	if result.PaymentID != "PAY-000008" {
+		t.Errorf("generated ID = %v, want PAY-000008", result.PaymentID)
 	}
 }
 
-func TestCreateAfterSettle(t *testing.T) {
+func TestCreate_IdempotencyKey(t *testing.T) {
 	p := newTestProcessor()
 
-	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
-	p.Execute(parseCmd(t, "AUTHORIZE P001"))
-	p.Execute(parseCmd(t, "CAPTURE P001"))
-	p.Execute(parseCmd(t, "SETTLE P001"))
+	result, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001 idempotency_key=req-1"))
+	if err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
 
-	// Try to CREATE again - should fail because payment is SETTLED
-	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
-	if err == nil {
-		t.Error("CREATE should fail when payment is in SETTLED state")
+	// A retry under another ID returns the original result
+	replay, err := p.Execute(parseCmd(t, "CREATE P002 100.00 USD M001 idempotency_key=req-1"))
+	if err != nil {
+		t.Fatalf("CREATE replay failed: %v", err)
 	}
-	if !strings.Contains(err.Error(), "SETTLED") {
-		t.Errorf("Expected SETTLED in error, got: %v", err)
+	if replay != result {
+		t.Errorf("CREATE replay = %q, want the original %q", replay, result)
+	}
+	if p.store.Exists("P002") {
+		t.Error("CREATE replay created a second payment")
+	}
+
+	// Replays also work once the original has progressed
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	if _, err := p.Execute(parseCmd(t, "CREATE AUTO 100.00 USD M001 idempotency_key=req-1")); err != nil {
+		t.Errorf("CREATE AUTO replay failed: %v", err)
+	}
+	if p.store.Exists("PAY-000001") {
+		t.Error("CREATE AUTO replay consumed a generated ID")
+	}
+
+	_, err = p.Execute(parseCmd(t, "CREATE P003 250.00 USD M001 idempotency_key=req-1"))
+	if err == nil || !strings.Contains(err.Error(), "different attributes") {
+		t.Errorf("CREATE with a reused key and new amount error = %v", err)
+	}
+	if _, err := p.Execute(parseCmd(t, "CREATE P004 100.00 USD M001 idem=req-2")); err == nil {
+		t.Error("CREATE with an unknown option should fail")
+	}
+}
+
+func TestCreateAfterPaymentProgressed(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	// Try to CREATE again with same attrs - should fail because state is AUTHORIZED
+	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err == nil {
+		t.Error("CREATE should fail when payment has progressed beyond INITIATED")
+	}
+	if !strings.Contains(err.Error(), "already exists in state") {
+		t.Errorf("Expected 'already exists in state' error, got: %v", err)
+	}
+}
+
+func TestCreateAfterSettle(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	p.Execute(parseCmd(t, "SETTLE P001"))
+
+	// Try to CREATE again - should fail because payment is SETTLED
+	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err == nil {
+		t.Error("CREATE should fail when payment is in SETTLED state")
+	}
+	if !strings.Contains(err.Error(), "SETTLED") {
+		t.Errorf("Expected SETTLED in error, got: %v", err)
+	}
+}
+
+func TestIdempotency_CreateWithDifferentCurrencyCase(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 usd M001"))
+
+	result, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err != nil {
+		t.Fatalf("CREATE differing only by currency case failed: %v", err)
+	}
+	if !strings.Contains(result, "idempotent") {
+		t.Errorf("CREATE result = %v, want 'idempotent'", result)
+	}
+}
+
+func TestCreate_UnknownCurrency(t *testing.T) {
+	p := newTestProcessor()
+
+	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 ZZZ M001"))
+	var verr *domain.ValidationError
+	if !errors.As(err, &verr) || verr.Field != "currency" {
+		t.Fatalf("CREATE with ZZZ error = %v, want a currency ValidationError", err)
+	}
+	if p.store.Exists("P001") {
+		t.Error("payment with an unknown currency must not be stored")
+	}
+
+	// Lowercase codes are accepted and stored uppercase
+	p.Execute(parseCmd(t, "CREATE P002 100.00 eur M001"))
+	if payment, err := p.store.Get("P002"); err != nil || payment.Currency != "EUR" {
+		t.Errorf("CREATE with eur = %v, %v; want stored as EUR", payment, err)
+	}
+}
+
+func TestCreate_LowercaseCommand(t *testing.T) {
+	p := newTestProcessor()
+
+	if _, err := p.Execute(parseCmd(t, "create p1 10 usd m1")); err != nil {
+		t.Fatalf("lowercase CREATE failed: %v", err)
+	}
+	payment, err := p.store.Get("p1")
+	if err != nil {
+		t.Fatalf("payment p1 not stored under its lowercase ID: %v", err)
+	}
+	if payment.Currency != "USD" || payment.MerchantID != "m1" {
+		t.Errorf("p1 currency=%s merchant=%s, want USD and m1", payment.Currency, payment.MerchantID)
+	}
+}
+
+// PATHS-TO Tests
+
+func TestPathsTo_Captured(t *testing.T) {
+	p := newTestProcessor()
+
+	result, err := p.Execute(parseCmd(t, "PATHS-TO CAPTURED"))
+	if err != nil {
+		t.Fatalf("PATHS-TO failed: %v", err)
+	}
+	if result != "PATHS-TO CAPTURED: AUTHORIZED, DISPUTED, PRE_SETTLEMENT_REVIEW" {
+		t.Errorf("PATHS-TO result = %v", result)
+	}
+}
+
+func TestPathsTo_Initiated(t *testing.T) {
+	p := newTestProcessor()
+
+	result, err := p.Execute(parseCmd(t, "PATHS-TO INITIATED"))
+	if err != nil {
+		t.Fatalf("PATHS-TO failed: %v", err)
+	}
+	if result != "PATHS-TO INITIATED: none" {
+		t.Errorf("PATHS-TO result = %v", result)
+	}
+}
+
+func TestPathsTo_UnknownState(t *testing.T) {
+	p := newTestProcessor()
+
+	_, err := p.Execute(parseCmd(t, "PATHS-TO BOGUS"))
+	if err == nil {
+		t.Error("PATHS-TO for unknown state should fail")
+	}
+}
+
+// Save error Tests
+
+// brokenSaveStore accepts the first allowed calls to Save and fails the rest,
+// like a FileStore whose disk filled up.
+type brokenSaveStore struct {
+	*store.MemoryStore
+	allowed int
+}
+
+func (s *brokenSaveStore) Save(payment *domain.Payment) error {
+	if s.allowed <= 0 {
+		return errors.New("disk full")
+	}
+	s.allowed--
+	return s.MemoryStore.Save(payment)
+}
+
+func TestSaveErrorsAreReported(t *testing.T) {
+	p := NewProcessor(&brokenSaveStore{MemoryStore: store.NewMemoryStore(), allowed: 1}, nil)
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001")); err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
+	_, err := p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	if err == nil || !strings.Contains(err.Error(), "failed to save payment: disk full") {
+		t.Errorf("AUTHORIZE error = %v, want the save failure", err)
+	}
+}
+
+// VOID reason policy Tests
+
+func TestVoidReasons_AllowedReason(t *testing.T) {
+	p := newTestProcessor()
+	p.SetVoidReasons([]string{"CUSTOMER_REQUEST", "FRAUD", "DUPLICATE"})
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	result, err := p.Execute(parseCmd(t, "VOID P001 FRAUD"))
+	if err != nil {
+		t.Fatalf("VOID with allowed reason failed: %v", err)
+	}
+	if !strings.Contains(result, "voided") {
+		t.Errorf("VOID result = %v, want 'voided'", result)
+	}
+}
+
+func TestVoidReasons_DisallowedReason(t *testing.T) {
+	p := newTestProcessor()
+	p.SetVoidReasons([]string{"CUSTOMER_REQUEST", "FRAUD", "DUPLICATE"})
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	_, err := p.Execute(parseCmd(t, "VOID P001 BORED"))
+	if err == nil {
+		t.Fatal("VOID with disallowed reason should fail")
+	}
+
+	// Payment must not be mutated
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "INITIATED") {
+		t.Errorf("STATUS = %v, want state=INITIATED", status)
+	}
+
+	// Omitted reason is still allowed without the require flag
+	if _, err := p.Execute(parseCmd(t, "VOID P001")); err != nil {
+		t.Errorf("VOID without reason failed: %v", err)
+	}
+}
+
+func TestVoid_UnknownReasonCode(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	_, err := p.Execute(parseCmd(t, "VOID P001 BORED"))
+	var verr *domain.ValidationError
+	if !errors.As(err, &verr) || verr.Field != "reason_code" {
+		t.Fatalf("VOID with BORED error = %v, want a reason_code ValidationError", err)
+	}
+
+	// Reason codes are case-insensitive and stored uppercase
+	result, err := p.Execute(parseCmd(t, "VOID P001 fraud"))
+	if err != nil {
+		t.Fatalf("VOID with lowercase reason failed: %v", err)
+	}
+	if result != "Payment P001 voided (reason: FRAUD)" {
+		t.Errorf("VOID result = %v, want reason FRAUD", result)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.VoidReason != domain.VoidReasonFraud {
+		t.Errorf("VoidReason = %q, want FRAUD", payment.VoidReason)
+	}
+}
+
+func TestVoidReasons_ValidReasonNotInAllowlist(t *testing.T) {
+	p := newTestProcessor()
+	p.SetVoidReasons([]string{"fraud"})
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if _, err := p.Execute(parseCmd(t, "VOID P001 DUPLICATE")); err == nil {
+		t.Error("VOID with a reason outside -void-reasons should fail")
+	}
+	if _, err := p.Execute(parseCmd(t, "VOID P001 Fraud")); err != nil {
+		t.Errorf("VOID with allowlisted reason failed: %v", err)
+	}
+}
+
+func TestVoidReasons_RequireReason(t *testing.T) {
+	p := newTestProcessor()
+	p.SetRequireVoidReason(true)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	_, err := p.Execute(parseCmd(t, "VOID P001"))
+	if err == nil {
+		t.Error("VOID without reason should fail when reason is required")
+	}
+}
+
+// GENERATE Tests
+
+func TestGenerate_BulkCreate(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	result, err := p.Execute(parseCmd(t, "GENERATE 1000 G"))
+	if err != nil {
+		t.Fatalf("GENERATE failed: %v", err)
+	}
+	if !strings.Contains(result, "Generated 1000 payments") {
+		t.Errorf("GENERATE result = %v, want count", result)
+	}
+
+	payments, _ := memStore.List()
+	if len(payments) != 1000 {
+		t.Fatalf("List() returned %d payments, want 1000", len(payments))
+	}
+	for i := 1; i < len(payments); i++ {
+		if payments[i-1].ID >= payments[i].ID {
+			t.Fatalf("List() not sorted at %d: %s >= %s", i, payments[i-1].ID, payments[i].ID)
+		}
+	}
+}
+
+func TestGenerate_Collision(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	p.Execute(parseCmd(t, "CREATE G3 100.00 USD M001"))
+
+	_, err := p.Execute(parseCmd(t, "GENERATE 5 G"))
+	if err == nil {
+		t.Fatal("GENERATE with colliding ID should fail")
+	}
+	if memStore.Exists("G1") {
+		t.Error("GENERATE should not create any payment on collision")
+	}
+}
+
+func TestGenerate_InvalidCount(t *testing.T) {
+	p := newTestProcessor()
+
+	if _, err := p.Execute(parseCmd(t, "GENERATE abc G")); err == nil {
+		t.Error("GENERATE with non-numeric count should fail")
+	}
+	if _, err := p.Execute(parseCmd(t, "GENERATE 0 G")); err == nil {
+		t.Error("GENERATE with zero count should fail")
+	}
+}
+
+func TestGenerate_CountLimit(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	_, err := p.Execute(parseCmd(t, fmt.Sprintf("GENERATE %d G", maxGenerateCount+1)))
+	var verr *domain.ValidationError
+	if !errors.As(err, &verr) || verr.Field != "count" {
+		t.Fatalf("GENERATE above the limit: err = %v, want ValidationError", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("must be at most %d", maxGenerateCount)) {
+		t.Errorf("error = %q, want the limit", err.Error())
+	}
+	if memStore.Exists("G1") {
+		t.Error("GENERATE above the limit should not create any payment")
+	}
+}
+
+// fakeClock is a manually advanced clock for time-based tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sleep advances the clock instead of waiting, so webhook retries run
+// instantly.
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// Webhook Tests
+
+// flakyWebhookClient fails the first failures deliveries and records the
+// clock time of every attempt.
+type flakyWebhookClient struct {
+	clock    *fakeClock
+	failures int
+	attempts []time.Time
+}
+
+func (c *flakyWebhookClient) Deliver(payload []byte) error {
+	c.attempts = append(c.attempts, c.clock.Now())
+	if c.failures > 0 {
+		c.failures--
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
+
+func TestWebhookEmitter_RetriesWithBackoff(t *testing.T) {
+	clock := newFakeClock()
+	client := &flakyWebhookClient{clock: clock, failures: 2}
+	var log strings.Builder
+	emitter := NewWebhookEmitter(client, 3, time.Second, &log)
+	emitter.SetClock(clock)
+	defer emitter.Close()
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if got := emitter.BackoffSchedule(); !reflect.DeepEqual(got, want) {
+		t.Errorf("BackoffSchedule() = %v, want %v", got, want)
+	}
+
+	p := newTestProcessor()
+	p.AddTransitionHook(emitter.Emit)
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001")); err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err != nil {
+		t.Fatalf("AUTHORIZE failed: %v", err)
+	}
+	emitter.Flush()
+
+	if len(client.attempts) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(client.attempts))
+	}
+	start := client.attempts[0]
+	if client.attempts[1].Sub(start) != time.Second || client.attempts[2].Sub(start) != 3*time.Second {
+		t.Errorf("attempt offsets = %v, %v; want 1s, 3s", client.attempts[1].Sub(start), client.attempts[2].Sub(start))
+	}
+	if log.Len() != 0 {
+		t.Errorf("unexpected failure log: %q", log.String())
+	}
+}
+
+func TestWebhookEmitter_LogsExhaustedRetries(t *testing.T) {
+	clock := newFakeClock()
+	client := &flakyWebhookClient{clock: clock, failures: 10}
+	var log strings.Builder
+	emitter := NewWebhookEmitter(client, 2, time.Second, &log)
+	emitter.SetClock(clock)
+	defer emitter.Close()
+
+	p := newTestProcessor()
+	p.AddTransitionHook(emitter.Emit)
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err != nil {
+		t.Fatalf("AUTHORIZE should succeed despite webhook failure: %v", err)
+	}
+	emitter.Flush()
+
+	if len(client.attempts) != 3 {
+		t.Errorf("attempts = %d, want 3", len(client.attempts))
+	}
+	want := "WARNING webhook delivery for P001 INITIATED->AUTHORIZED failed after 3 attempt(s): connection refused\n"
+	if log.String() != want {
+		t.Errorf("log = %q, want %q", log.String(), want)
+	}
+}
+
+// blockingWebhookClient blocks every delivery until release is closed.
+type blockingWebhookClient struct {
+	release   chan struct{}
+	delivered chan []byte
+}
+
+func (c *blockingWebhookClient) Deliver(payload []byte) error {
+	<-c.release
+	c.delivered <- payload
+	return nil
+}
+
+func TestWebhookEmitter_DeliversOutsideCommand(t *testing.T) {
+	client := &blockingWebhookClient{release: make(chan struct{}), delivered: make(chan []byte, 4)}
+	emitter := NewWebhookEmitter(client, 0, time.Second, nil)
+	defer emitter.Close()
+
+	p := newTestProcessor()
+	p.AddTransitionHook(emitter.Emit)
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	// The endpoint is stuck, yet commands keep completing
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Execute(parseCmd(t, "AUTHORIZE P001"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("AUTHORIZE failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AUTHORIZE blocked on webhook delivery")
+	}
+	if _, err := p.Execute(parseCmd(t, "STATUS P001")); err != nil {
+		t.Fatalf("STATUS failed: %v", err)
+	}
+
+	close(client.release)
+	emitter.Flush()
+	if len(client.delivered) != 1 {
+		t.Errorf("delivered = %d, want 1", len(client.delivered))
+	}
+}
+
+func TestWebhookEmitter_SimClockRetry(t *testing.T) {
+	clock := domain.NewSimClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	client := &flakyWebhookClient{clock: newFakeClock(), failures: 1}
+	emitter := NewWebhookEmitter(client, 1, time.Minute, nil)
+	emitter.SetClock(clock)
+	defer emitter.Close()
+
+	emitter.Emit(TransitionEvent{PaymentID: "P001", From: "INITIATED", To: "AUTHORIZED"})
+	flushed := make(chan struct{})
+	go func() {
+		emitter.Flush()
+		close(flushed)
+	}()
+	// The retry waits for simulated time to pass
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case <-flushed:
+			done = true
+		case <-deadline:
+			t.Fatal("retry never ran after advancing the SimClock")
+		case <-time.After(webhookPollInterval):
+			clock.Advance(time.Minute)
+		}
+	}
+	if len(client.attempts) != 2 {
+		t.Errorf("attempts = %d, want 2 after the SimClock passed the backoff", len(client.attempts))
+	}
+}
+
+// CHANGED-SINCE Tests
+
+func TestChangedSince(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P003 10.00 USD M001"))
+	clock.Advance(time.Hour)
+	p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	clock.Advance(time.Hour)
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	result, err := p.Execute(parseCmd(t, "CHANGED-SINCE 2024-01-01T13:00:00Z"))
+	if err != nil {
+		t.Fatalf("CHANGED-SINCE failed: %v", err)
+	}
+	want := `Payments changed since 2024-01-01T13:00:00Z (2):
+  P003 AUTHORIZED updated=2024-01-01T13:00:00Z
+  P001 AUTHORIZED updated=2024-01-01T14:00:00Z`
+	if result != want {
+		t.Errorf("CHANGED-SINCE =\n%s\nwant\n%s", result, want)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "CHANGED-SINCE yesterday")); err == nil {
+		t.Error("CHANGED-SINCE with a malformed timestamp should fail")
+	}
+}
+
+// DEADENDS Tests
+
+func TestDeadEnds_BuiltInTable(t *testing.T) {
+	p := newTestProcessor()
+	result, err := p.Execute(parseCmd(t, "DEADENDS"))
+	if err != nil {
+		t.Fatalf("DEADENDS failed: %v", err)
+	}
+	want := "DEADENDS: none (intended terminal states: CHARGED_BACK, DECLINED, EXPIRED, FAILED, REFUNDED, REVERSED, SETTLED, VOIDED)"
+	if result != want {
+		t.Errorf("DEADENDS = %q, want %q", result, want)
+	}
+}
+
+// OLDEST-OPEN Tests
+
+func TestOldestOpen(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+
+	p.Execute(parseCmd(t, "CREATE P004 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P003 10.00 USD M001")) // same age as P004
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+	p.Execute(parseCmd(t, "VOID P001"))
+	clock.Advance(time.Hour)
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+	clock.Advance(30 * time.Minute)
+
+	result, err := p.Execute(parseCmd(t, "OLDEST-OPEN"))
+	if err != nil {
+		t.Fatalf("OLDEST-OPEN failed: %v", err)
+	}
+	if want := "Oldest open payment: P003 (INITIATED), open for 1h30m0s"; result != want {
+		t.Errorf("OLDEST-OPEN = %q, want %q", result, want)
+	}
+}
+
+func TestOldestOpen_NoneOpen(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "VOID P002"))
+
+	result, err := p.Execute(parseCmd(t, "OLDEST-OPEN"))
+	if err != nil || result != "No open payments" {
+		t.Errorf("OLDEST-OPEN = %q, %v; want no open payments", result, err)
+	}
+}
+
+// Amount dialect Tests
+
+func TestCreate_AmountDialect(t *testing.T) {
+	minorUnits := domain.AmountParserFunc(func(s string) (*big.Rat, error) {
+		cents, ok := new(big.Int).SetString(s, 10)
+		if !ok || cents.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid minor-unit amount: %s", s)
+		}
+		return new(big.Rat).SetFrac(cents, big.NewInt(100)), nil
+	})
+	if err := domain.RegisterAmountParser("test-minor-units", minorUnits); err != nil {
+		t.Fatalf("RegisterAmountParser failed: %v", err)
+	}
+	parser, ok := domain.LookupAmountParser("test-minor-units")
+	if !ok {
+		t.Fatal("registered dialect not found")
+	}
+
+	p := newTestProcessor()
+	p.SetAmountParser(parser)
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 1050 USD M001")); err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
+	payment, _ := p.store.Get("P001")
+	if want := big.NewRat(1050, 100); payment.Amount.Cmp(want) != 0 {
+		t.Errorf("amount = %s, want 10.50", payment.Amount.FloatString(2))
+	}
+	if _, err := p.Execute(parseCmd(t, "CREATE P002 10.50 USD M001")); err == nil {
+		t.Error("expected the minor-unit dialect to reject a decimal amount")
+	}
+
+	// Processors without a dialect keep the strict decimal default
+	other := newTestProcessor()
+	if _, err := other.Execute(parseCmd(t, "CREATE P001 10.50 USD M001")); err != nil {
+		t.Fatalf("default CREATE failed: %v", err)
+	}
+	payment, _ = other.store.Get("P001")
+	if want := big.NewRat(1050, 100); payment.Amount.Cmp(want) != 0 {
+		t.Errorf("default amount = %s, want 10.50", payment.Amount.FloatString(2))
+	}
+}
+
+// SETTLE-LAG Tests
+
+func TestSettleLag(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+
+	capture := func(id, currency string) {
+		for _, line := range []string{"CREATE " + id + " 10.00 " + currency + " M001", "AUTHORIZE " + id, "CAPTURE " + id} {
+			if _, err := p.Execute(parseCmd(t, line)); err != nil {
+				t.Fatalf("%s failed: %v", line, err)
+			}
+		}
+	}
+	capture("P001", "USD")
+	capture("P002", "USD")
+	capture("P003", "EUR")
+	capture("P004", "USD") // never settled
+	clock.Advance(time.Hour)
+	p.Execute(parseCmd(t, "SETTLE P001"))
+	clock.Advance(2 * time.Hour)
+	p.Execute(parseCmd(t, "SETTLE P002"))
+	clock.Advance(3 * time.Hour)
+	p.Execute(parseCmd(t, "SETTLE P003"))
+
+	result, err := p.Execute(parseCmd(t, "SETTLE-LAG"))
+	if err != nil {
+		t.Fatalf("SETTLE-LAG failed: %v", err)
+	}
+	want := "SETTLE-LAG: 3 payment(s), avg 3h20m0s, min 1h0m0s, max 6h0m0s\n" +
+		"  EUR: 1 payment(s), avg 6h0m0s, min 6h0m0s, max 6h0m0s\n" +
+		"  USD: 2 payment(s), avg 2h0m0s, min 1h0m0s, max 3h0m0s"
+	if result != want {
+		t.Errorf("SETTLE-LAG = %q, want %q", result, want)
+	}
+}
+
+func TestSettleLag_NoSettled(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	result, err := p.Execute(parseCmd(t, "SETTLE-LAG"))
+	if err != nil || result != "SETTLE-LAG: no settled payments" {
+		t.Errorf("SETTLE-LAG = %q, %v; want no settled payments", result, err)
+	}
+}
+
+// BENCH Tests
+
+func TestBench(t *testing.T) {
+	p := newTestProcessor()
+	for _, op := range []string{"create", "get", "list"} {
+		result, err := p.Execute(parseCmd(t, "BENCH "+op+" 500"))
+		if err != nil {
+			t.Fatalf("BENCH %s failed: %v", op, err)
+		}
+		prefix := "BENCH " + strings.ToUpper(op) + ": 500 ops in "
+		if !strings.HasPrefix(result, prefix) {
+			t.Errorf("BENCH %s = %q, want prefix %q", op, result, prefix)
+		}
+		var rate float64
+		if _, err := fmt.Sscanf(result[strings.LastIndex(result, "(")+1:], "%f ops/sec", &rate); err != nil || rate <= 0 {
+			t.Errorf("BENCH %s throughput = %v (%v), want positive", op, rate, err)
+		}
+	}
+
+	if payments, _ := p.store.List(); len(payments) != 0 {
+		t.Errorf("BENCH polluted the main store with %d payments", len(payments))
+	}
+	if _, err := p.Execute(parseCmd(t, "BENCH delete 10")); err == nil {
+		t.Error("BENCH with an unknown op should fail")
+	}
+	_, err := p.Execute(parseCmd(t, fmt.Sprintf("BENCH list %d", maxBenchCount+1)))
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("at most %d", maxBenchCount)) {
+		t.Errorf("BENCH above the limit: err = %v, want limit error", err)
+	}
+}
+
+func TestBench_UsesConfiguredStore(t *testing.T) {
+	p := newTestProcessor()
+	path := filepath.Join(t.TempDir(), "bench.json")
+	cleaned := false
+	p.SetBenchStore(func() (store.Repository, func(), error) {
+		s, err := store.NewFileStore(path)
+		return s, func() { cleaned = true }, err
+	})
+
+	if _, err := p.Execute(parseCmd(t, "BENCH create 10")); err != nil {
+		t.Fatalf("BENCH failed: %v", err)
+	}
+	reloaded, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("scratch FileStore not written: %v", err)
+	}
+	if payments, _ := reloaded.List(); len(payments) != benchSeedSize+10 {
+		t.Errorf("scratch FileStore holds %d payments, want %d", len(payments), benchSeedSize+10)
+	}
+	if !cleaned {
+		t.Error("BENCH should dispose of its scratch store")
+	}
+}
+
+// TICK and SWEEP Tests
+
+func TestTick_AdvancesSimClockAndSweepExpiresReview(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+	p.SetClock(domain.NewSimClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	p.SetReviewTTL(time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 1500.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	if result, _ := p.Execute(parseCmd(t, "SWEEP")); result != "SWEEP: no expired reviews" {
+		t.Errorf("SWEEP before TTL = %q", result)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "TICK 61m")); err != nil {
+		t.Fatalf("TICK failed: %v", err)
+	}
+	result, err := p.Execute(parseCmd(t, "SWEEP"))
+	if err != nil {
+		t.Fatalf("SWEEP failed: %v", err)
+	}
+	if result != "SWEEP: voided 1 expired review(s): P001" {
+		t.Errorf("SWEEP = %q", result)
+	}
+
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateVoided || payment.VoidReason != domain.ReviewExpiredReason {
+		t.Errorf("P001 = %s (%s), want VOIDED (REVIEW_EXPIRED)", payment.State, payment.VoidReason)
+	}
+	if v := payment.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+}
+
+func TestReauthorize_CannotEscapeReviewTTL(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+	p.SetClock(domain.NewSimClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	p.SetReviewTTL(time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 5000.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	// Reauthorizing within the TTL does not restart the review
+	p.Execute(parseCmd(t, "TICK 40m"))
+	if _, err := p.Execute(parseCmd(t, "REAUTHORIZE P001")); err != nil {
+		t.Fatalf("REAUTHORIZE within the TTL failed: %v", err)
+	}
+	p.Execute(parseCmd(t, "TICK 40m"))
+
+	_, err := p.Execute(parseCmd(t, "REAUTHORIZE P001"))
+	want := "cannot reauthorize payment P001: review time limit elapsed"
+	if err == nil || err.Error() != want {
+		t.Errorf("REAUTHORIZE after the TTL error = %v, want %q", err, want)
+	}
+	if result, _ := p.Execute(parseCmd(t, "SWEEP")); result != "SWEEP: voided 1 expired review(s): P001" {
+		t.Errorf("SWEEP = %q, want P001 voided", result)
+	}
+}
+
+func TestProcessor_ClockAppliesToReloadedPayments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	fileStore, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	p := NewProcessor(fileStore, nil)
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	fileStore.Close()
+
+	// A later run with a simulated clock
+	reloaded, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	simNow := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	p = NewProcessor(reloaded, nil)
+	p.SetClock(domain.NewSimClock(simNow))
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err != nil {
+		t.Fatalf("AUTHORIZE failed: %v", err)
+	}
+
+	payment, _ := reloaded.Get("P001")
+	entry, _ := payment.LastEntry("AUTHORIZE")
+	if !entry.Timestamp.Equal(simNow) || !payment.UpdatedAt.Equal(simNow) {
+		t.Errorf("AUTHORIZE at %v, updated %v; want the SimClock's %v", entry.Timestamp, payment.UpdatedAt, simNow)
+	}
+}
+
+func TestTick_RequiresSimClock(t *testing.T) {
+	p := newTestProcessor()
+	if _, err := p.Execute(parseCmd(t, "TICK 1h")); err == nil {
+		t.Error("TICK without a simulated clock should fail")
+	}
+}
+
+// Max open authorization Tests
+
+func TestMaxOpenAuth(t *testing.T) {
+	p := newTestProcessor()
+	p.SetMaxOpenAuth(2)
+	for _, id := range []string{"P001", "P002", "P003"} {
+		p.Execute(parseCmd(t, "CREATE "+id+" 10.00 USD M001"))
+	}
+	p.Execute(parseCmd(t, "CREATE P004 10.00 USD M002"))
+
+	for _, id := range []string{"P001", "P002"} {
+		if _, err := p.Execute(parseCmd(t, "AUTHORIZE "+id)); err != nil {
+			t.Fatalf("AUTHORIZE %s within cap failed: %v", id, err)
+		}
+	}
+
+	_, err := p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	want := "merchant M001 already has 2 open authorization(s) (max 2)"
+	if err == nil || err.Error() != want {
+		t.Errorf("AUTHORIZE over cap error = %v, want %q", err, want)
+	}
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P004")); err != nil {
+		t.Errorf("other merchant should not be capped: %v", err)
+	}
+
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P003")); err != nil {
+		t.Errorf("AUTHORIZE after CAPTURE freed a slot failed: %v", err)
+	}
+}
+
+// Capture window Tests
+
+func TestCaptureWindow_WithinWindow(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+	p.SetCaptureWindow(time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	clock.Advance(59 * time.Minute)
+
+	result, err := p.Execute(parseCmd(t, "CAPTURE P001"))
+	if err != nil {
+		t.Fatalf("CAPTURE within window failed: %v", err)
+	}
+	if !strings.Contains(result, "captured") {
+		t.Errorf("CAPTURE result = %v, want 'captured'", result)
+	}
+}
+
+func TestCaptureWindow_Elapsed(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+	p.SetCaptureWindow(time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	clock.Advance(61 * time.Minute)
+
+	_, err := p.Execute(parseCmd(t, "CAPTURE P001"))
+	if err == nil {
+		t.Fatal("CAPTURE outside window should fail")
+	}
+	if !strings.Contains(err.Error(), "capture window elapsed") {
+		t.Errorf("Error = %v, want 'capture window elapsed'", err)
+	}
+
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "state=AUTHORIZED") {
+		t.Errorf("STATUS = %v, want state=AUTHORIZED", status)
+	}
+}
+
+func TestAuthExpiry_ExpiresStaleAuthorization(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+	p.SetAuthExpiry(168 * time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CREATE P002 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+	clock.Advance(167 * time.Hour)
+	if _, err := p.Execute(parseCmd(t, "CAPTURE P002")); err != nil {
+		t.Errorf("CAPTURE within the TTL failed: %v", err)
+	}
+	clock.Advance(2 * time.Hour)
+
+	_, err := p.Execute(parseCmd(t, "CAPTURE P001"))
+	want := "cannot capture: authorization for payment P001 expired (older than 168h0m0s)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("CAPTURE error = %v, want %q", err, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateExpired {
+		t.Errorf("state = %s, want EXPIRED", payment.State)
+	}
+	if v := payment.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+	if _, err := p.Execute(parseCmd(t, "CAPTURE P001")); err == nil {
+		t.Error("expected CAPTURE of an EXPIRED payment to fail")
+	}
+}
+
+func TestReauthorize_RefreshesCaptureWindow(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+	p.SetCaptureWindow(time.Hour)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	clock.Advance(61 * time.Minute)
+
+	result, err := p.Execute(parseCmd(t, "REAUTHORIZE P001"))
+	if err != nil || result != "Payment P001 reauthorized" {
+		t.Fatalf("REAUTHORIZE = %q, %v; want reauthorized", result, err)
+	}
+	clock.Advance(30 * time.Minute)
+	if _, err := p.Execute(parseCmd(t, "CAPTURE P001")); err != nil {
+		t.Errorf("CAPTURE within the refreshed window failed: %v", err)
+	}
+
+	payment, _ := p.store.Get("P001")
+	entry, ok := payment.LastEntry("REAUTHORIZE")
+	if !ok || entry.FromState != domain.StateAuthorized || entry.ToState != domain.StateAuthorized {
+		t.Errorf("REAUTHORIZE history entry = %+v, want AUTHORIZED->AUTHORIZED", entry)
+	}
+}
+
+func TestReauthorize_ReappliesReviewThreshold(t *testing.T) {
+	p := newTestProcessorWithThreshold("1000")
+	p.Execute(parseCmd(t, "CREATE P001 1500.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	result, err := p.Execute(parseCmd(t, "REAUTHORIZE P001"))
+	if err != nil {
+		t.Fatalf("REAUTHORIZE failed: %v", err)
+	}
+	if want := "Payment P001 reauthorized and moved to PRE_SETTLEMENT_REVIEW"; result != want {
+		t.Errorf("REAUTHORIZE = %q, want %q", result, want)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StatePreSettlementReview {
+		t.Errorf("state = %s, want PRE_SETTLEMENT_REVIEW", payment.State)
+	}
+	if v := payment.HistoryViolations(); len(v) != 0 {
+		t.Errorf("HistoryViolations() = %v, want none", v)
+	}
+}
+
+func TestReauthorize_InvalidState(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	_, err := p.Execute(parseCmd(t, "REAUTHORIZE P001"))
+	want := "cannot reauthorize payment P001 in state INITIATED (must be AUTHORIZED or PRE_SETTLEMENT_REVIEW)"
+	if err == nil || err.Error() != want {
+		t.Errorf("REAUTHORIZE error = %v, want %q", err, want)
+	}
+
+	// The AUTHORIZED self-loop does not make AUTHORIZE repeatable
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err == nil {
+		t.Error("expected a second AUTHORIZE to fail")
+	}
+}
+
+// Result Tests
+
+func TestExecuteResult_Create(t *testing.T) {
+	p := newTestProcessor()
+
+	result, err := p.ExecuteResult(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
+	if result.Command != "CREATE" || result.PaymentID != "P001" {
+		t.Errorf("Result = %+v, want CREATE for P001", result)
+	}
+	if result.Outcome != "created" || result.State != "INITIATED" {
+		t.Errorf("Result outcome/state = %s/%s, want created/INITIATED", result.Outcome, result.State)
+	}
+	if result.Currency != "USD" || result.Amount.Cmp(big.NewRat(100, 1)) != 0 {
+		t.Errorf("Result amount = %v %s, want 100 USD", result.Amount, result.Currency)
+	}
+
+	if got := (TextFormatter{}).Format(result); got != "Payment P001 created: 100.0 USD" {
+		t.Errorf("TextFormatter.Format() = %q", got)
+	}
+}
+
+// AUDIT-MONEY Tests
+
+func TestAuditMoney_Consistent(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	result, err := p.Execute(parseCmd(t, "AUDIT-MONEY P001"))
+	if err != nil {
+		t.Fatalf("AUDIT-MONEY failed: %v", err)
+	}
+	if !strings.Contains(result, "consistent") || strings.Contains(result, "inconsistent") {
+		t.Errorf("AUDIT-MONEY result = %v, want consistent", result)
+	}
+	if !strings.Contains(result, "net=100.0") {
+		t.Errorf("AUDIT-MONEY result = %v, want net=100.0", result)
+	}
+}
+
+func TestAuditMoney_OverRefund(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	// Fabricate an over-refund
+	payment, _ := memStore.Get("P001")
+	payment.RefundedAmount = big.NewRat(150, 1)
+
+	result, err := p.Execute(parseCmd(t, "AUDIT-MONEY P001"))
+	if err != nil {
+		t.Fatalf("AUDIT-MONEY failed: %v", err)
+	}
+	if !strings.Contains(result, "inconsistent") {
+		t.Errorf("AUDIT-MONEY result = %v, want inconsistent", result)
+	}
+	if !strings.Contains(result, "refunded 150.0 exceeds captured 100.0") {
+		t.Errorf("AUDIT-MONEY result = %v, want over-refund breakdown", result)
+	}
+}
+
+// UNSETTLE Tests
+
+func settlePayment(t *testing.T, p *Processor, id string) {
+	t.Helper()
+	settlePaymentInBatch(t, p, id, "")
+}
+
+// settlePaymentInBatch is settlePayment with SETTLE tagging the payment with
+// batchID (none if empty).
+func settlePaymentInBatch(t *testing.T, p *Processor, id, batchID string) {
+	t.Helper()
+	settle := "SETTLE " + id
+	if batchID != "" {
+		settle += " " + batchID
+	}
+	for _, line := range []string{
+		"CREATE " + id + " 100.00 USD M001",
+		"AUTHORIZE " + id,
+		"CAPTURE " + id,
+		settle,
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+}
+
+func TestUnsettle_ReversesBatchMembers(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	settlePaymentInBatch(t, p, "P001", "BATCH001")
+	settlePaymentInBatch(t, p, "P002", "BATCH001")
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH001"))
+	settlePaymentInBatch(t, p, "P003", "BATCH002")
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH002"))
+
+	result, err := p.Execute(parseCmd(t, "UNSETTLE BATCH001"))
+	if err != nil {
+		t.Fatalf("UNSETTLE failed: %v", err)
+	}
+	if !strings.Contains(result, "2 payments") {
+		t.Errorf("UNSETTLE result = %v, want 2 payments", result)
+	}
+
+	for _, id := range []string{"P001", "P002"} {
+		payment, _ := memStore.Get(id)
+		if payment.State != "CAPTURED" || payment.BatchID != "" {
+			t.Errorf("%s state=%s batch=%q, want CAPTURED with cleared batch", id, payment.State, payment.BatchID)
+		}
+	}
+
+	other, _ := memStore.Get("P003")
+	if other.State != "SETTLED" || other.BatchID != "BATCH002" {
+		t.Errorf("P003 state=%s batch=%q, want untouched SETTLED in BATCH002", other.State, other.BatchID)
+	}
+}
+
+func TestValidateBatch(t *testing.T) {
+	p := newTestProcessor()
+	settlePaymentInBatch(t, p, "P001", "B1")
+	settlePaymentInBatch(t, p, "P002", "B1")
+	p.Execute(parseCmd(t, "SETTLEMENT B1"))
+
+	result, err := p.Execute(parseCmd(t, "VALIDATE-BATCH B1"))
+	if err != nil || result != "VALIDATE-BATCH B1: valid, 2 member(s) SETTLED" {
+		t.Errorf("VALIDATE-BATCH intact = %q, %v", result, err)
+	}
+
+	p.Execute(parseCmd(t, "UNSETTLE B1"))
+	p.Execute(parseCmd(t, "SETTLE P001"))
+
+	result, err = p.Execute(parseCmd(t, "VALIDATE-BATCH B1"))
+	if err != nil || result != "VALIDATE-BATCH B1: 1 of 2 member(s) drifted: P002 (CAPTURED)" {
+		t.Errorf("VALIDATE-BATCH drifted = %q, %v", result, err)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "VALIDATE-BATCH NOPE")); err == nil {
+		t.Error("VALIDATE-BATCH of an unrecorded batch should fail")
+	}
+}
+
+func TestUnsettle_UnknownBatch(t *testing.T) {
+	p := newTestProcessor()
+
+	_, err := p.Execute(parseCmd(t, "UNSETTLE NOPE"))
+	if err == nil {
+		t.Error("UNSETTLE of unrecorded batch should fail")
+	}
+}
+
+// Amount expression Tests
+
+func TestAmountExpr_Create(t *testing.T) {
+	p := newTestProcessor()
+	p.SetAmountExpr(true)
+
+	result, err := p.Execute(parseCmd(t, "CREATE P001 10.00*3 USD M001"))
+	if err != nil {
+		t.Fatalf("CREATE with expression failed: %v", err)
+	}
+	if !strings.Contains(result, "created: 30.0 USD") {
+		t.Errorf("CREATE result = %v, want 30.0 USD", result)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P002 (10+2 USD M001")); err == nil {
+		t.Error("CREATE with unbalanced expression should fail")
+	}
+}
+
+func TestAmountExpr_StrictPrecisionStillApplies(t *testing.T) {
+	p := newTestProcessor()
+	p.SetAmountExpr(true)
+	p.SetStrictPrecision(true)
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 10.005 USD M001")); err == nil {
+		t.Error("CREATE 10.005 USD should fail -strict-precision instead of being rounded")
+	}
+	if _, err := p.Execute(parseCmd(t, "CREATE P002 100/3 USD M001")); err != nil {
+		t.Errorf("CREATE 100/3 USD (rounded division) failed: %v", err)
+	}
+}
+
+func TestAmountExpr_DisabledByDefault(t *testing.T) {
+	p := newTestProcessor()
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 10.00*3 USD M001")); err == nil {
+		t.Error("CREATE with expression should fail when -amount-expr is off")
+	}
+}
+
+// Restricted mode Tests
+
+func TestAllowedCommands_Restricted(t *testing.T) {
+	p := newTestProcessor()
+	p.SetAllowedCommands([]string{"CREATE", "STATUS", "LIST"})
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001")); err != nil {
+		t.Fatalf("allowed CREATE failed: %v", err)
+	}
+
+	_, err := p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	if err == nil || err.Error() != "command AUTHORIZE not permitted in restricted mode" {
+		t.Errorf("AUTHORIZE error = %v, want restricted mode error", err)
+	}
+
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "INITIATED") {
+		t.Errorf("rejected AUTHORIZE should not change state: %v", status)
+	}
+
+	p.SetAllowedCommands(nil)
+	if _, err := p.Execute(parseCmd(t, "AUTHORIZE P001")); err != nil {
+		t.Errorf("AUTHORIZE should be allowed with an empty list: %v", err)
+	}
+}
+
+// ASSERT-ALL-TERMINAL Tests
+
+func TestAssertAllTerminal_AllTerminal(t *testing.T) {
+	p := newTestProcessor()
+
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "VOID P002"))
+
+	result, err := p.Execute(parseCmd(t, "ASSERT-ALL-TERMINAL"))
+	if err != nil {
+		t.Fatalf("ASSERT-ALL-TERMINAL failed: %v", err)
+	}
+	if !strings.Contains(result, "passed: 2 payment(s)") {
+		t.Errorf("ASSERT-ALL-TERMINAL result = %v, want passed", result)
+	}
+}
+
+func TestAssertAllTerminal_LingeringAuthorized(t *testing.T) {
+	p := newTestProcessor()
+
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+
+	_, err := p.Execute(parseCmd(t, "ASSERT-ALL-TERMINAL"))
+	if err == nil {
+		t.Fatal("ASSERT-ALL-TERMINAL should fail with an AUTHORIZED payment")
+	}
+	if !strings.Contains(err.Error(), "P002 (AUTHORIZED)") || strings.Contains(err.Error(), "P001") {
+		t.Errorf("ASSERT-ALL-TERMINAL error = %v, want only P002 listed", err)
+	}
+}
+
+// EXPORT / IMPORT Tests
+
+// writeImportFile writes content to a CSV file in a temporary directory.
+func writeImportFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "payments.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestImport_RestoresStates(t *testing.T) {
+	p := newTestProcessor()
+	path := writeImportFile(t, `id,amount,currency,merchant_id,state,captured,refunded,batch_id,void_reason
+P001,100.0,USD,M001,SETTLED,100.0,,BATCH1,
+P002,25.5,EUR,M002,AUTHORIZED,,,,
+`)
+
+	result, err := p.Execute(parseCmd(t, "IMPORT "+path))
+	if err != nil {
+		t.Fatalf("IMPORT failed: %v", err)
+	}
+	if !strings.Contains(result, "2 payment(s) imported") {
+		t.Errorf("IMPORT result = %v, want 2 imported", result)
+	}
+
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "state=SETTLED") {
+		t.Errorf("P001 status = %v, want SETTLED", status)
+	}
+	status, _ = p.Execute(parseCmd(t, "STATUS P002"))
+	if !strings.Contains(status, "state=AUTHORIZED amount=25.50 currency=EUR") {
+		t.Errorf("P002 status = %v, want AUTHORIZED 25.50 EUR", status)
+	}
+
+	payment, _ := p.store.Get("P001")
+	if entry, ok := payment.LastEntry("IMPORT"); !ok || entry.ToState != "SETTLED" {
+		t.Errorf("history should end with a synthetic IMPORT entry: %+v", payment.History)
+	}
+	if money, _ := p.Execute(parseCmd(t, "AUDIT-MONEY P001")); !strings.Contains(money, "consistent") {
+		t.Errorf("imported money fields should be consistent: %v", money)
+	}
+	if !p.store.BatchIDExists("BATCH1") {
+		t.Error("IMPORT should record the batch ID")
+	}
+}
+
+func TestSettle_ReleasesPartialCaptureRemainder(t *testing.T) {
+	p := newTestProcessor()
+	path := writeImportFile(t, `id,amount,currency,merchant_id,state,captured,refunded,batch_id,void_reason
+P001,100.00,USD,M001,CAPTURED,60.00,,,
+`)
+	if _, err := p.Execute(parseCmd(t, "IMPORT "+path)); err != nil {
+		t.Fatalf("IMPORT failed: %v", err)
+	}
+
+	result, err := p.Execute(parseCmd(t, "SETTLE P001 BATCH1"))
+	if err != nil {
+		t.Fatalf("SETTLE failed: %v", err)
+	}
+	if want := "Payment P001 settled in batch BATCH1 (released uncaptured remainder 40.00 USD)"; result != want {
+		t.Errorf("SETTLE = %q, want %q", result, want)
+	}
+
+	payment, _ := p.store.Get("P001")
+	release := payment.Movements[len(payment.Movements)-1]
+	if release.Kind != domain.MovementRelease || release.Amount.Cmp(big.NewRat(40, 1)) != 0 {
+		t.Errorf("last movement = %s %v, want RELEASE 40", release.Kind, release.Amount)
+	}
+	entry, _ := payment.LastEntry("SETTLE")
+	if !strings.Contains(entry.Details, "settled for 60.00 USD; released uncaptured remainder 40.00 USD") {
+		t.Errorf("SETTLE history details = %q", entry.Details)
+	}
+
+	result, _ = p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	if !strings.Contains(result, "BATCH1: USD 60.00 (1 payment)") {
+		t.Errorf("SETTLEMENT = %q, want settled amount equal to the captured 60.00", result)
+	}
+}
+
+func TestImport_RejectsUnknownState(t *testing.T) {
+	p := newTestProcessor()
+	path := writeImportFile(t, `id,amount,currency,merchant_id,state,captured,refunded,batch_id,void_reason
+P001,100.0,USD,M001,SETTLED,100.0,,,
+P002,25.5,EUR,M002,PENDING,,,,
+P003,-1,EUR,M002,INITIATED,,,,
+`)
+
+	_, err := p.Execute(parseCmd(t, "IMPORT "+path))
+	if err == nil {
+		t.Fatal("IMPORT with an unknown state should fail")
+	}
+	if !strings.Contains(err.Error(), "row 3: unknown state: PENDING") || !strings.Contains(err.Error(), "row 4: invalid amount") {
+		t.Errorf("IMPORT error = %v, want row-level errors", err)
+	}
+	if p.store.Exists("P001") {
+		t.Error("a failed IMPORT should not import any rows")
+	}
+}
+
+func TestImport_RejectsUnknownCurrency(t *testing.T) {
+	p := newTestProcessor()
+	path := writeImportFile(t, `id,amount,currency,merchant_id,state,captured,refunded,batch_id,void_reason
+P001,100.0,USD,M001,INITIATED,,,,
+P002,25.5,ZZZ,M002,INITIATED,,,,
+`)
+
+	_, err := p.Execute(parseCmd(t, "IMPORT "+path))
+	if err == nil || !strings.Contains(err.Error(), "row 3: validation error for currency: unknown ISO 4217 currency code: ZZZ") {
+		t.Errorf("IMPORT error = %v, want the CREATE currency check", err)
+	}
+	if p.store.Exists("P001") {
+		t.Error("a failed IMPORT should not import any rows")
+	}
+}
+
+func TestImport_SaveFailureImportsNothing(t *testing.T) {
+	p := NewProcessor(&brokenSaveStore{MemoryStore: store.NewMemoryStore(), allowed: 1}, nil)
+	path := writeImportFile(t, `id,amount,currency,merchant_id,state,captured,refunded,batch_id,void_reason
+P001,100.0,USD,M001,SETTLED,100.0,,BATCH1,
+P002,25.5,EUR,M002,AUTHORIZED,,,,
+`)
+
+	var events []TransitionEvent
+	p.AddTransitionHook(func(e TransitionEvent) { events = append(events, e) })
+	_, err := p.Execute(parseCmd(t, "IMPORT "+path))
+	if err == nil || !strings.Contains(err.Error(), "nothing imported: failed to save payment: disk full") {
+		t.Fatalf("IMPORT error = %v, want the save failure", err)
+	}
+	if p.store.Exists("P001") || p.store.BatchIDExists("BATCH1") {
+		t.Error("a failed IMPORT should roll back the rows already saved")
+	}
+	if len(events) != 0 {
+		t.Errorf("a failed IMPORT notified hooks: %v", events)
+	}
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	p.Execute(parseCmd(t, "CREATE P002 40.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P002"))
+	p.Execute(parseCmd(t, "CAPTURE P002"))
+	p.Execute(parseCmd(t, "REFUND P002"))
+
+	exported, err := p.Execute(parseCmd(t, "EXPORT CSV"))
+	if err != nil {
+		t.Fatalf("EXPORT CSV failed: %v", err)
+	}
+
+	restored := newTestProcessor()
+	if _, err := restored.Execute(parseCmd(t, "IMPORT "+writeImportFile(t, exported+"\n"))); err != nil {
+		t.Fatalf("IMPORT of exported CSV failed: %v", err)
+	}
+	reexported, _ := restored.Execute(parseCmd(t, "EXPORT CSV"))
+	if reexported != exported {
+		t.Errorf("round trip mismatch:\n%v\nwant\n%v", reexported, exported)
+	}
+}
+
+func TestExport_CurrencyMinorUnits(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.5 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 100 JPY M001"))
+	p.Execute(parseCmd(t, "CREATE P003 1.5 KWD M001"))
+
+	exported, err := p.Execute(parseCmd(t, "EXPORT CSV"))
+	if err != nil {
+		t.Fatalf("EXPORT CSV failed: %v", err)
+	}
+	for _, row := range []string{"P001,100.50,USD,", "P002,100,JPY,", "P003,1.500,KWD,"} {
+		if !strings.Contains(exported, row) {
+			t.Errorf("EXPORT CSV missing %q:\n%s", row, exported)
+		}
+	}
+
+	exported, _ = p.Execute(parseCmd(t, "EXPORT JSON"))
+	var doc jsonStore
+	if err := json.Unmarshal([]byte(exported), &doc); err != nil {
+		t.Fatalf("EXPORT JSON is not valid JSON: %v", err)
+	}
+	if doc.Payments[0].Amount != "100.50" || doc.Payments[1].Amount != "100" {
+		t.Errorf("EXPORT JSON amounts = %s, %s; want 100.50, 100", doc.Payments[0].Amount, doc.Payments[1].Amount)
+	}
+}
+
+// VERIFY-HISTORY Tests
+
+func TestVerifyHistory_Consistent(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "SETTLEMENT B1"))
+	p.Execute(parseCmd(t, "UNSETTLE B1"))
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 20.00 USD M001")) // conflict marks P002 FAILED
+
+	result, err := p.Execute(parseCmd(t, "VERIFY-HISTORY"))
+	if err != nil {
+		t.Fatalf("VERIFY-HISTORY failed: %v", err)
+	}
+	if result != "VERIFY-HISTORY: 2 payments checked, all consistent" {
+		t.Errorf("VERIFY-HISTORY = %v, want all consistent", result)
+	}
+}
+
+func TestVerifyHistory_MismatchedState(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+	settlePayment(t, p, "P001")
+	p.Execute(parseCmd(t, "CREATE P002 10.00 USD M001"))
+
+	// Corrupt P002 behind the processor's back
+	payment, _ := memStore.Get("P002")
+	payment.State = domain.StateCaptured
+
+	result, err := p.Execute(parseCmd(t, "VERIFY-HISTORY"))
+	if err != nil {
+		t.Fatalf("VERIFY-HISTORY failed: %v", err)
+	}
+	if !strings.HasPrefix(result, "VERIFY-HISTORY: 1 of 2 payments inconsistent") ||
+		!strings.Contains(result, "P002: state CAPTURED does not match last history entry INITIATED") {
+		t.Errorf("VERIFY-HISTORY = %v, want P002 flagged", result)
+	}
+	if strings.Contains(result, "P001") {
+		t.Errorf("VERIFY-HISTORY flagged consistent P001: %v", result)
+	}
+}
+
+// HISTORY Tests
+
+func TestHistory_Limit(t *testing.T) {
+	p := newTestProcessor()
+	p.SetClock(newFakeClock())
+	settlePayment(t, p, "P001") // CREATE, AUTHORIZE, CAPTURE, SETTLE
+
+	result, err := p.Execute(parseCmd(t, "HISTORY P001 2"))
+	if err != nil {
+		t.Fatalf("HISTORY failed: %v", err)
+	}
+	want := "History for P001 (last 2 of 4, 2 earlier entries omitted):\n" +
+		"  #3 2024-01-01T12:00:00Z CAPTURE AUTHORIZED -> CAPTURED: Payment captured\n" +
+		"  #4 2024-01-01T12:00:00Z SETTLE CAPTURED -> SETTLED: Payment settled"
+	if result != want {
+		t.Errorf("HISTORY =\n%v\nwant\n%v", result, want)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "HISTORY P001 zero")); err == nil {
+		t.Error("HISTORY with a non-numeric limit should fail")
+	}
+}
+
+func TestHistory_Unlimited(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+
+	result, err := p.Execute(parseCmd(t, "HISTORY P001"))
+	if err != nil {
+		t.Fatalf("HISTORY failed: %v", err)
+	}
+	if !strings.HasPrefix(result, "History for P001 (4 entries):") || strings.Contains(result, "omitted") {
+		t.Errorf("HISTORY = %v, want all 4 entries", result)
+	}
+	for i, action := range []string{"#1", "#2", "#3", "#4"} {
+		if !strings.Contains(result, action) {
+			t.Errorf("HISTORY missing entry %d: %v", i+1, result)
+		}
+	}
+
+	// A limit above the entry count shows everything
+	limited, _ := p.Execute(parseCmd(t, "HISTORY P001 10"))
+	if limited != result {
+		t.Errorf("HISTORY P001 10 = %v, want %v", limited, result)
+	}
+}
+
+// AUDIT-EXPORT Tests
+
+func TestAuditExport_NumberedEvents(t *testing.T) {
+	p := newTestProcessor()
+	clock := newFakeClock()
+	p.SetClock(clock)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	clock.Advance(time.Minute)
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	clock.Advance(time.Minute)
+	p.Execute(parseCmd(t, "VOID P001 FRAUD"))
+
+	result, err := p.Execute(parseCmd(t, "AUDIT-EXPORT P001"))
+	if err != nil {
+		t.Fatalf("AUDIT-EXPORT failed: %v", err)
+	}
+	for _, want := range []string{
+		"Audit trail for payment P001",
+		"  State:    VOIDED",
+		"  Void reason: FRAUD",
+		"  1. 2024-01-01T12:00:00Z CREATE none -> INITIATED: Payment created",
+		"  2. 2024-01-01T12:01:00Z AUTHORIZE INITIATED -> AUTHORIZED",
+		"  3. 2024-01-01T12:02:00Z VOID AUTHORIZED -> VOIDED",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("AUDIT-EXPORT missing %q:\n%v", want, result)
+		}
+	}
+	if strings.Index(result, "1. ") > strings.Index(result, "2. ") || strings.Index(result, "2. ") > strings.Index(result, "3. ") {
+		t.Errorf("AUDIT-EXPORT events out of order:\n%v", result)
+	}
+}
+
+func TestAuditExport_WritesFile(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	path := filepath.Join(t.TempDir(), "p001.txt")
+	if _, err := p.Execute(parseCmd(t, "AUDIT-EXPORT P001 "+path)); err != nil {
+		t.Fatalf("AUDIT-EXPORT to file failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(data), "1. ") || !strings.Contains(string(data), "CREATE") {
+		t.Errorf("exported file = %q, want numbered events", data)
+	}
+}
+
+func TestAuditExport_NotFound(t *testing.T) {
+	p := newTestProcessor()
+
+	if _, err := p.Execute(parseCmd(t, "AUDIT-EXPORT P404")); err == nil {
+		t.Error("AUDIT-EXPORT of unknown payment should fail")
+	}
+}
+
+// SUMMARY Tests
+
+func TestSummary_PerCurrencyTotals(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 10.10 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 0.20 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P003 1500 JPY M001"))
+
+	result, err := p.Execute(parseCmd(t, "SUMMARY"))
+	if err != nil {
+		t.Fatalf("SUMMARY failed: %v", err)
+	}
+	want := "Summary: 3 payment(s)\n  JPY: count=1 total=1500\n  USD: count=2 total=10.30"
+	if result != want {
+		t.Errorf("SUMMARY =\n%v\nwant\n%v", result, want)
+	}
+}
+
+func TestSummary_LargeSumIsExact(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	cent := big.NewRat(1, 100)
+	for i := 0; i < 10000; i++ {
+		memStore.Save(domain.NewPayment(fmt.Sprintf("P%05d", i), cent, "USD", "M001"))
+	}
+
+	result, err := p.Execute(parseCmd(t, "SUMMARY"))
+	if err != nil {
+		t.Fatalf("SUMMARY failed: %v", err)
+	}
+	if !strings.Contains(result, "USD: count=10000 total=100.00") {
+		t.Errorf("SUMMARY = %v, want exactly 100.00", result)
+	}
+}
+
+// STATS Tests
+
+func TestStats_StatesAndCurrencyTotals(t *testing.T) {
+	p := newTestProcessor()
+
+	settlePayment(t, p, "P001")
+	for _, line := range []string{
+		"CREATE P002 50.00 USD M001",
+		"AUTHORIZE P002",
+		"CAPTURE P002 20.00",
+		"CREATE P003 1500 JPY M001",
+		"AUTHORIZE P003",
+		"CREATE P004 10.00 EUR M001",
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+
+	result, err := p.Execute(parseCmd(t, "STATS"))
+	if err != nil {
+		t.Fatalf("STATS failed: %v", err)
+	}
+	want := `Stats: 4 payment(s)
+  AUTHORIZED: 1
+  CAPTURED: 1
+  INITIATED: 1
+  SETTLED: 1
+  EUR: authorized=0.00 captured=0.00 settled=0.00
+  JPY: authorized=1500 captured=0 settled=0
+  USD: authorized=150.00 captured=120.00 settled=100.00`
+	if result != want {
+		t.Errorf("STATS =\n%v\nwant\n%v", result, want)
+	}
+}
+
+func TestStats_Empty(t *testing.T) {
+	p := newTestProcessor()
+
+	result, err := p.Execute(parseCmd(t, "STATS"))
+	if err != nil {
+		t.Fatalf("STATS failed: %v", err)
+	}
+	if result != "Stats: 0 payment(s)" {
+		t.Errorf("STATS = %q, want %q", result, "Stats: 0 payment(s)")
+	}
+}
+
+// REFUNDABLE Tests
+
+func TestRefundable_Captured(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	result, err := p.Execute(parseCmd(t, "REFUNDABLE P001"))
+	if err != nil {
+		t.Fatalf("REFUNDABLE failed: %v", err)
+	}
+	if result != "REFUNDABLE P001: 100.00 USD" {
+		t.Errorf("REFUNDABLE = %v, want full captured amount", result)
+	}
+}
+
+func TestRefundable_PartiallyRefunded(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	p.Execute(parseCmd(t, "REFUND P001 30.50"))
+
+	result, _ := p.Execute(parseCmd(t, "REFUNDABLE P001"))
+	if result != "REFUNDABLE P001: 69.50 USD" {
+		t.Errorf("REFUNDABLE = %v, want remaining 69.50", result)
+	}
+}
+
+func TestRefundable_AuthorizedOnly(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	result, _ := p.Execute(parseCmd(t, "REFUNDABLE P001"))
+	want := "REFUNDABLE P001: 0.00 USD (payment is AUTHORIZED; only CAPTURED payments can be refunded)"
+	if result != want {
+		t.Errorf("REFUNDABLE = %v, want %v", result, want)
+	}
+
+	if _, err := p.Execute(parseCmd(t, "REFUNDABLE P404")); err == nil {
+		t.Error("REFUNDABLE of unknown payment should fail")
+	}
+}
+
+// REFUND-REASONS Tests
+
+func TestRefundReasons_GroupsByReason(t *testing.T) {
+	p := newTestProcessor()
+
+	for _, line := range []string{
+		"CREATE P001 100.00 USD M001", "AUTHORIZE P001", "CAPTURE P001", "REFUND P001 100.00 DAMAGED",
+		"CREATE P002 25.50 USD M001", "AUTHORIZE P002", "CAPTURE P002", "REFUND P002 25.50 DAMAGED",
+		"CREATE P003 40.00 USD M001", "AUTHORIZE P003", "CAPTURE P003", "REFUND P003 40.00 NOT_RECEIVED",
+		"CREATE P004 10.00 USD M001", "AUTHORIZE P004", "CAPTURE P004", "REFUND P004",
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+
+	result, err := p.Execute(parseCmd(t, "REFUND-REASONS"))
+	if err != nil {
+		t.Fatalf("REFUND-REASONS failed: %v", err)
+	}
+	want := "Refund reasons:\n" +
+		"  DAMAGED: count=2 total=125.50 USD\n" +
+		"  NOT_RECEIVED: count=1 total=40.00 USD\n" +
+		"  UNSPECIFIED: count=1 total=10.00 USD"
+	if result != want {
+		t.Errorf("REFUND-REASONS =\n%v\nwant\n%v", result, want)
+	}
+}
+
+func TestExport_JSONRoundTrip(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+	p.SetClock(newFakeClock())
+	settlePaymentInBatch(t, p, "P001", "BATCH1")
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	p.Execute(parseCmd(t, "CREATE P002 10.25 EUR M002"))
+	p.Execute(parseCmd(t, "VOID P002 FRAUD"))
+
+	exported, err := p.Execute(parseCmd(t, "EXPORT JSON"))
+	if err != nil {
+		t.Fatalf("EXPORT JSON failed: %v", err)
+	}
+
+	var doc jsonStore
+	if err := json.Unmarshal([]byte(exported), &doc); err != nil {
+		t.Fatalf("EXPORT JSON is not valid JSON: %v\n%s", err, exported)
+	}
+	if len(doc.BatchIDs) != 1 || doc.BatchIDs[0] != "BATCH1" {
+		t.Errorf("batch_ids = %v, want [BATCH1]", doc.BatchIDs)
+	}
+	if len(doc.Payments) != 2 {
+		t.Fatalf("payments = %d, want 2", len(doc.Payments))
+	}
+
+	for _, jp := range doc.Payments {
+		payment, _ := memStore.Get(jp.ID)
+		if !reflect.DeepEqual(jp, newJSONPayment(payment)) {
+			t.Errorf("%s did not round-trip:\n got %+v\nwant %+v", jp.ID, jp, newJSONPayment(payment))
+		}
+	}
+
+	p1 := doc.Payments[0]
+	if p1.Amount != "100.00" || p1.CapturedAmount != "100.00" || p1.BatchID != "BATCH1" || len(p1.History) != 4 {
+		t.Errorf("P001 = %+v, want settled with 4 history entries", p1)
+	}
+	if doc.Payments[1].Amount != "10.25" || doc.Payments[1].VoidReason != "FRAUD" {
+		t.Errorf("P002 = %+v, want 10.25 voided for FRAUD", doc.Payments[1])
+	}
+}
+
+// CAPTURE-RATE Tests
+
+func TestCaptureRate(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+	for _, line := range []string{
+		"CREATE P002 10.00 USD M001",
+		"AUTHORIZE P002",
+		"CAPTURE P002",
+		"CREATE P003 10.00 USD M001",
+		"AUTHORIZE P003",
+		"VOID P003",
+		"CREATE P004 10.00 USD M001", // never authorized
+		"VOID P004",
+		"CREATE P005 10.00 USD M002",
+		"AUTHORIZE P005",
+	} {
+		p.Execute(parseCmd(t, line))
+	}
+
+	result, err := p.Execute(parseCmd(t, "CAPTURE-RATE M001"))
+	if err != nil {
+		t.Fatalf("CAPTURE-RATE failed: %v", err)
+	}
+	if want := "CAPTURE-RATE M001: 2 of 3 authorized payment(s) captured (66.67%)"; result != want {
+		t.Errorf("CAPTURE-RATE = %q, want %q", result, want)
+	}
+
+	result, _ = p.Execute(parseCmd(t, "CAPTURE-RATE M999"))
+	if result != "CAPTURE-RATE M999: no authorized payments" {
+		t.Errorf("CAPTURE-RATE M999 = %q", result)
+	}
+}
+
+// LIST column width Tests
+
+func TestList_ColumnWidthTruncatesLongIDs(t *testing.T) {
+	p := newTestProcessor()
+	p.SetListColumnWidth(12)
+	p.Execute(parseCmd(t, "CREATE ORDER-2024-000000123 10.00 USD M001"))
+
+	result, err := p.Execute(parseCmd(t, "LIST"))
+	if err != nil {
+		t.Fatalf("LIST failed: %v", err)
+	}
+	if want := "  ORDER-202...: state=INITIATED"; !strings.Contains(result, want) {
+		t.Errorf("LIST = %q, want truncated ID %q", result, want)
+	}
+
+	status, _ := p.Execute(parseCmd(t, "STATUS ORDER-2024-000000123"))
+	if !strings.HasPrefix(status, "Payment ORDER-2024-000000123:") {
+		t.Errorf("STATUS = %q, want the full ID", status)
+	}
+	if !p.store.Exists("ORDER-2024-000000123") {
+		t.Error("truncation must not change the stored ID")
+	}
+}
+
+// CHECK-CURRENCY Tests
+
+func TestCheckCurrency(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 20.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P003 10.00 USD M002"))
+	p.Execute(parseCmd(t, "CREATE P004 10.00 EUR M002"))
+	p.Execute(parseCmd(t, "CREATE P005 15.00 USD M002"))
+
+	tests := []struct {
+		merchant string
+		want     string
+	}{
+		{"M001", "CHECK-CURRENCY M001: consistent, 2 payment(s) in USD"},
+		{"M002", "CHECK-CURRENCY M002: mixed currencies: EUR=1, USD=2"},
+		{"M999", "CHECK-CURRENCY M999: no payments"},
+	}
+	for _, tt := range tests {
+		result, err := p.Execute(parseCmd(t, "CHECK-CURRENCY "+tt.merchant))
+		if err != nil || result != tt.want {
+			t.Errorf("CHECK-CURRENCY %s = %q, %v; want %q", tt.merchant, result, err, tt.want)
+		}
+	}
+}
+
+func TestExport_Events(t *testing.T) {
+	clock := newFakeClock()
+	p := newTestProcessor()
+	p.SetClock(clock)
+	for _, line := range []string{
+		"CREATE P002 10.00 USD M001",
+		"CREATE P001 10.00 USD M001",
+		"AUTHORIZE P002",
+		"AUTHORIZE P001",
+		"CAPTURE P002",
+	} {
+		clock.Advance(time.Minute)
+		p.Execute(parseCmd(t, line))
+	}
+
+	exported, err := p.Execute(parseCmd(t, "EXPORT EVENTS"))
+	if err != nil {
+		t.Fatalf("EXPORT EVENTS failed: %v", err)
+	}
+
+	want := []string{"P002 CREATE", "P001 CREATE", "P002 AUTHORIZE", "P001 AUTHORIZE", "P002 CAPTURE"}
+	lines := strings.Split(exported, "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("events = %d, want %d:\n%s", len(lines), len(want), exported)
+	}
+	var prev time.Time
+	for i, line := range lines {
+		var event jsonEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i+1, err)
+		}
+		if got := event.PaymentID + " " + event.Action; got != want[i] {
+			t.Errorf("event %d = %s, want %s", i+1, got, want[i])
+		}
+		if event.Timestamp.Before(prev) {
+			t.Errorf("event %d is out of order", i+1)
+		}
+		prev = event.Timestamp
+	}
+}
+
+// ADJUST Tests
+
+func TestAdjust_SettledPayment(t *testing.T) {
+	p := newTestProcessor()
+	settlePayment(t, p, "P001")
+
+	if _, err := p.Execute(parseCmd(t, "ADJUST P001 -15.50 GOODWILL")); err != nil {
+		t.Fatalf("negative ADJUST failed: %v", err)
+	}
+	result, err := p.Execute(parseCmd(t, "ADJUST P001 5.00 FEE_CORRECTION"))
+	if err != nil {
+		t.Fatalf("positive ADJUST failed: %v", err)
+	}
+	if want := "Payment P001 adjusted by 5.00 USD (FEE_CORRECTION); net 89.50 USD"; result != want {
+		t.Errorf("ADJUST = %q, want %q", result, want)
+	}
+
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateSettled || len(payment.Adjustments) != 2 {
+		t.Errorf("P001 = %s with %d adjustments, want SETTLED with 2", payment.State, len(payment.Adjustments))
+	}
+
+	statement, _ := p.Execute(parseCmd(t, "STATEMENT M001"))
+	if !strings.Contains(statement, "adjustments=-10.50 net=89.50") {
+		t.Errorf("STATEMENT = %q, want adjustments reflected in net", statement)
+	}
+}
+
+func TestAdjust_RequiresSettled(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	if _, err := p.Execute(parseCmd(t, "ADJUST P001 -5.00 GOODWILL")); err == nil {
+		t.Error("ADJUST of an unsettled payment should fail")
+	}
+	if _, err := p.Execute(parseCmd(t, "ADJUST P001 0 GOODWILL")); err == nil {
+		t.Error("ADJUST with a zero amount should fail")
+	}
+}
+
+// VOID-STATS Tests
+
+func TestVoidStats(t *testing.T) {
+	p := newTestProcessor()
+	for _, line := range []string{
+		"CREATE P001 10.00 USD M001",
+		"CREATE P002 10.00 USD M001",
+		"CREATE P003 10.00 USD M001",
+		"CREATE P004 10.00 USD M001",
+		"CREATE P005 10.00 USD M001",
+		"CREATE P006 10.00 USD M001",
+		"CREATE P007 10.00 USD M001",
+		"CREATE P008 10.00 USD M001",
+		"VOID P001 FRAUD",
+		"VOID P002 DUPLICATE",
+		"VOID P003 FRAUD",
+		"VOID P004",
+		"VOID P005 CUSTOMER_REQUEST",
+		"VOID P006 FRAUD",
+	} {
+		p.Execute(parseCmd(t, line))
+	}
+
+	result, err := p.Execute(parseCmd(t, "VOID-STATS"))
+	if err != nil {
+		t.Fatalf("VOID-STATS failed: %v", err)
+	}
+	want := `VOID-STATS: 6 of 8 payment(s) voided (75.00%)
+  FRAUD: 3
+  CUSTOMER_REQUEST: 1
+  DUPLICATE: 1
+  UNSPECIFIED: 1`
+	if result != want {
+		t.Errorf("VOID-STATS =\n%s\nwant\n%s", result, want)
+	}
+}
+
+// STATEMENT Tests
+
+func TestStatement_SettledAndRefunded(t *testing.T) {
+	p := newTestProcessor()
+
+	settlePayment(t, p, "P001")
+	for _, line := range []string{
+		"CREATE P002 40.00 USD M001",
+		"AUTHORIZE P002",
+		"CAPTURE P002",
+		"REFUND P002",
+		"CREATE P003 5000 JPY M001",
+		"AUTHORIZE P003",
+		"CAPTURE P003",
+		"SETTLE P003",
+		"CREATE P004 75.00 USD M002", // other merchant
+		"AUTHORIZE P004",
+		"CAPTURE P004",
+		"CREATE P005 60.00 USD M001", // never captured
+		"CREATE P006 80.00 USD M001", // captured but not settled
+		"AUTHORIZE P006",
+		"CAPTURE P006",
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+
+	result, err := p.Execute(parseCmd(t, "STATEMENT M001"))
+	if err != nil {
+		t.Fatalf("STATEMENT failed: %v", err)
+	}
+	want := "STATEMENT M001:\n" +
+		"  JPY: gross=5000 refunds=0 fees=0 adjustments=0 net=5000 (payments=1)\n" +
+		"  USD: gross=140.00 refunds=40.00 fees=0.00 adjustments=0.00 net=100.00 (payments=2)"
+	if result != want {
+		t.Errorf("STATEMENT result =\n%v\nwant\n%v", result, want)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	p := newTestProcessor()
+
+	settlePayment(t, p, "P001")
+	for _, line := range []string{
+		"ADJUST P001 -5.25 FEE_CORRECTION",
+		"CREATE P002 40.00 USD M001",
+		"AUTHORIZE P002",
+		"CAPTURE P002",
+		"REFUND P002",
+		"CREATE P003 75.00 USD M002", // captured but not settled
+		"AUTHORIZE P003",
+		"CAPTURE P003",
+		"CREATE P004 5000 JPY M002",
+		"AUTHORIZE P004",
+		"CAPTURE P004",
+		"SETTLE P004",
+		"ADJUST P004 120 GOODWILL",
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+
+	result, err := p.Execute(parseCmd(t, "POSITION"))
+	if err != nil {
+		t.Fatalf("POSITION failed: %v", err)
+	}
+	want := "POSITION:\n" +
+		"  JPY: inflows=5000 refunds=0 adjustments=120 net=5120 (payments=1)\n" +
+		"  USD: inflows=140.00 refunds=40.00 adjustments=-5.25 net=94.75 (payments=2)"
+	if result != want {
+		t.Errorf("POSITION result =\n%v\nwant\n%v", result, want)
+	}
+}
+
+func TestPosition_NoSettled(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	result, err := p.Execute(parseCmd(t, "POSITION"))
+	if err != nil || result != "POSITION: no settled payments" {
+		t.Errorf("POSITION = %q, %v; want no settled payments", result, err)
+	}
+}
+
+func TestStatement_NoPayments(t *testing.T) {
+	p := newTestProcessor()
+
+	result, err := p.Execute(parseCmd(t, "STATEMENT M404"))
+	if err != nil {
+		t.Fatalf("STATEMENT failed: %v", err)
+	}
+	if !strings.Contains(result, "no settled payments") {
+		t.Errorf("STATEMENT result = %v, want no settled payments", result)
+	}
+}
+
+// RENAME-BATCH Tests
+
+func TestRenameBatch_RestampsMembers(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	settlePaymentInBatch(t, p, "P001", "BATHC1")
+	settlePaymentInBatch(t, p, "P002", "BATHC1")
+	p.Execute(parseCmd(t, "SETTLEMENT BATHC1"))
+
+	result, err := p.Execute(parseCmd(t, "RENAME-BATCH BATHC1 BATCH1"))
+	if err != nil {
+		t.Fatalf("RENAME-BATCH failed: %v", err)
+	}
+	if !strings.Contains(result, "re-stamped: 2") {
+		t.Errorf("RENAME-BATCH result = %v, want 2 re-stamped", result)
+	}
+	for _, id := range []string{"P001", "P002"} {
+		if payment, _ := memStore.Get(id); payment.BatchID != "BATCH1" {
+			t.Errorf("%s BatchID = %q, want BATCH1", id, payment.BatchID)
+		}
+	}
+	if memStore.BatchIDExists("BATHC1") || !memStore.BatchIDExists("BATCH1") {
+		t.Errorf("batch IDs = %v, want only BATCH1", memStore.GetBatchIDs())
+	}
+}
+
+func TestRenameBatch_Collision(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	settlePaymentInBatch(t, p, "P001", "BATCH1")
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH1"))
+	p.Execute(parseCmd(t, "SETTLEMENT BATCH2"))
+
+	if _, err := p.Execute(parseCmd(t, "RENAME-BATCH BATCH1 BATCH2")); err == nil {
+		t.Error("RENAME-BATCH onto an existing batch should fail")
+	}
+	if payment, _ := memStore.Get("P001"); payment.BatchID != "BATCH1" {
+		t.Errorf("failed rename changed BatchID to %q", payment.BatchID)
+	}
+	if _, err := p.Execute(parseCmd(t, "RENAME-BATCH NOPE BATCH3")); err == nil {
+		t.Error("RENAME-BATCH of an unknown batch should fail")
+	}
+}
+
+// -no-idempotent Tests
+
+func TestNoIdempotent_DuplicatesError(t *testing.T) {
+	p := newTestProcessor()
+	p.SetNoIdempotent(true)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	_, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err == nil || !strings.Contains(err.Error(), "idempotent CREATE disabled") {
+		t.Errorf("duplicate CREATE error = %v, want idempotency disabled", err)
+	}
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "state=INITIATED") {
+		t.Errorf("duplicate CREATE should not fail the payment: %v", status)
+	}
+
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	p.Execute(parseCmd(t, "SETTLE P001"))
+	_, err = p.Execute(parseCmd(t, "SETTLE P001"))
+	if err == nil || !strings.Contains(err.Error(), "idempotent SETTLE disabled") {
+		t.Errorf("duplicate SETTLE error = %v, want idempotency disabled", err)
+	}
+}
+
+func TestNoIdempotent_DefaultIsIdempotent(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	result, err := p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if err != nil || !strings.Contains(result, "idempotent") {
+		t.Errorf("duplicate CREATE = %v, %v, want idempotent", result, err)
+	}
+
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	p.Execute(parseCmd(t, "SETTLE P001"))
+	result, err = p.Execute(parseCmd(t, "SETTLE P001"))
+	if err != nil || !strings.Contains(result, "already settled (idempotent)") {
+		t.Errorf("duplicate SETTLE = %v, %v, want idempotent", result, err)
+	}
+}
+
+// Strict precision Tests
+
+func TestStrictPrecision_RejectsSubMinorUnit(t *testing.T) {
+	p := newTestProcessor()
+	p.SetStrictPrecision(true)
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 10.001 USD M001")); err == nil {
+		t.Error("CREATE 10.001 USD should fail with -strict-precision")
+	}
+	if _, err := p.Execute(parseCmd(t, "CREATE P002 10.5 JPY M001")); err == nil {
+		t.Error("CREATE 10.5 JPY should fail with -strict-precision")
+	}
+
+	p.SetStrictPrecision(false)
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 10.001 USD M001")); err != nil {
+		t.Errorf("CREATE 10.001 USD should succeed without -strict-precision: %v", err)
+	}
+}
+
+func TestStrictPrecision_AcceptsScaledAmount(t *testing.T) {
+	p := newTestProcessor()
+	p.SetStrictPrecision(true)
+
+	for _, cmd := range []string{
+		"CREATE P001 10.25 USD M001",
+		"CREATE P002 10.250 USD M001", // trailing zeros are exact
+		"CREATE P003 1000 JPY M001",
+		"CREATE P004 1.125 KWD M001",
+	} {
+		if _, err := p.Execute(parseCmd(t, cmd)); err != nil {
+			t.Errorf("%s failed: %v", cmd, err)
+		}
+	}
+}
+
+// Amount band Tests
+
+func newBandedProcessor(t *testing.T, strict bool) (*Processor, *strings.Builder) {
+	t.Helper()
+	bands, err := domain.ParseAmountBands("USD:1-10000,JPY:100-1000000")
+	if err != nil {
+		t.Fatalf("ParseAmountBands failed: %v", err)
+	}
+	var warnings strings.Builder
+	p := newTestProcessor()
+	p.SetAmountBands(bands, strict)
+	p.SetWarningOutput(&warnings)
+	return p, &warnings
+}
+
+func TestAmountBands_InBand(t *testing.T) {
+	p, warnings := newBandedProcessor(t, false)
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 250.00 USD M001")); err != nil {
+		t.Fatalf("CREATE failed: %v", err)
+	}
+	if _, err := p.Execute(parseCmd(t, "CREATE P002 0.01 EUR M001")); err != nil {
+		t.Fatalf("CREATE in a currency without a band failed: %v", err)
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("unexpected warning: %q", warnings.String())
+	}
+}
+
+func TestAmountBands_OutOfBandWarns(t *testing.T) {
+	p, warnings := newBandedProcessor(t, false)
+
+	if _, err := p.Execute(parseCmd(t, "CREATE P001 25000.00 USD M001")); err != nil {
+		t.Fatalf("out-of-band CREATE should only warn: %v", err)
+	}
+	want := "WARNING amount 25000.00 USD is outside the plausible range 1-10000 for payment P001\n"
+	if warnings.String() != want {
+		t.Errorf("warning = %q, want %q", warnings.String(), want)
+	}
+}
+
+func TestAmountBands_StrictRejects(t *testing.T) {
+	p, warnings := newBandedProcessor(t, true)
+
+	_, err := p.Execute(parseCmd(t, "CREATE P001 5 JPY M001"))
+	if err == nil || !strings.Contains(err.Error(), "outside the plausible range 100-1000000") {
+		t.Errorf("CREATE error = %v, want out-of-range error", err)
+	}
+	if p.store.Exists("P001") {
+		t.Error("rejected CREATE should not store the payment")
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("strict mode should error instead of warning: %q", warnings.String())
+	}
+}
+
+// ExecuteBatch Tests
+
+func TestExecuteBatch_AppliesAll(t *testing.T) {
+	p := newTestProcessor()
+
+	results, err := p.ExecuteBatch([]*parser.Command{
+		parseCmd(t, "CREATE P001 100.00 USD M001"),
+		parseCmd(t, "AUTHORIZE P001"),
+	})
+	if err != nil {
+		t.Fatalf("ExecuteBatch() error = %v", err)
+	}
+	if len(results) != 2 || results[1].Outcome != "authorized" {
+		t.Errorf("results = %+v, want CREATE and AUTHORIZE results", results)
+	}
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateAuthorized {
+		t.Errorf("P001 state = %s, want AUTHORIZED", payment.State)
+	}
+}
+
+func TestExecuteBatch_RollsBackOnFailure(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	_, err := p.ExecuteBatch([]*parser.Command{
+		parseCmd(t, "AUTHORIZE P001"),
+		parseCmd(t, "CREATE AUTO 5.00 USD M001"),
+		parseCmd(t, "REFUND P001"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "command 3 (REFUND P001) failed") {
+		t.Fatalf("ExecuteBatch() error = %v, want REFUND failure", err)
+	}
+
+	payment, _ := p.store.Get("P001")
+	if payment.State != domain.StateInitiated || len(payment.History) != 1 {
+		t.Errorf("P001 = %s with %d history entries, want untouched INITIATED", payment.State, len(payment.History))
+	}
+	if p.store.Exists("PAY-000001") {
+		t.Error("payment created inside the failed batch must be rolled back")
+	}
+	// The AUTO counter is rolled back too
+	result, _ := p.Execute(parseCmd(t, "CREATE AUTO 5.00 USD M001"))
+	if !strings.Contains(result, "PAY-000001") {
+		t.Errorf("CREATE AUTO = %v, want PAY-000001 reused", result)
+	}
+}
+
+func TestExecuteBatch_EventsOnlyOnCommit(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+
+	var events []string
+	p.AddTransitionHook(func(e TransitionEvent) {
+		events = append(events, fmt.Sprintf("%s %s->%s", e.PaymentID, e.From, e.To))
+	})
+
+	_, err := p.ExecuteBatch([]*parser.Command{
+		parseCmd(t, "AUTHORIZE P001"),
+		parseCmd(t, "REFUND P001"),
+	})
+	if err == nil {
+		t.Fatal("ExecuteBatch() should fail on REFUND")
+	}
+	if len(events) != 0 {
+		t.Errorf("rolled-back batch notified hooks: %v", events)
+	}
+	if result, _ := p.Execute(parseCmd(t, "TRANSITION-STATS")); result != "No transitions recorded" {
+		t.Errorf("TRANSITION-STATS = %q, want rolled-back edges left out", result)
+	}
+
+	if _, err := p.ExecuteBatch([]*parser.Command{
+		parseCmd(t, "AUTHORIZE P001"),
+		parseCmd(t, "CAPTURE P001"),
+	}); err != nil {
+		t.Fatalf("ExecuteBatch() error = %v", err)
+	}
+	want := []string{"P001 INITIATED->AUTHORIZED", "P001 AUTHORIZED->CAPTURED"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v after commit", events, want)
+	}
+}
+
+// CHECKPOINT / RESTORE Tests
+
+func TestCheckpoint_RestoreUndoesMutation(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	if _, err := p.Execute(parseCmd(t, "CHECKPOINT before")); err != nil {
+		t.Fatalf("CHECKPOINT failed: %v", err)
+	}
+
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CREATE P002 50.00 USD M001"))
+
+	if _, err := p.Execute(parseCmd(t, "RESTORE before")); err != nil {
+		t.Fatalf("RESTORE failed: %v", err)
+	}
+
+	status, _ := p.Execute(parseCmd(t, "STATUS P001"))
+	if !strings.Contains(status, "state=INITIATED") {
+		t.Errorf("STATUS = %v, want state=INITIATED after restore", status)
+	}
+	if _, err := p.Execute(parseCmd(t, "STATUS P002")); err == nil {
+		t.Error("P002 should not exist after restore")
+	}
+}
+
+func TestSnapshot_LaterMutationsDoNotLeak(t *testing.T) {
+	p := newTestProcessor()
+
+	for _, line := range []string{
+		"CREATE P001 100.00 USD M001",
+		"AUTHORIZE P001",
+		"CAPTURE P001 60.00",
+		"SNAPSHOT captured",
+		"REFUND P001 10.00",
+		"RESTORE captured",
+		"REFUND P001 20.00",
+		"RESTORE captured",
+	} {
+		if _, err := p.Execute(parseCmd(t, line)); err != nil {
+			t.Fatalf("%s failed: %v", line, err)
+		}
+	}
+
+	payment, _ := p.store.Get("P001")
+	if payment.Refunded().Sign() != 0 || len(payment.Movements) != 1 || len(payment.History) != 3 {
+		t.Errorf("P001 refunded %s with %d movements and %d history entries, want the snapshot's 0, 1 and 3",
+			domain.FormatRat(payment.Refunded()), len(payment.Movements), len(payment.History))
+	}
+}
+
+func TestCheckpoint_RestoreUnknown(t *testing.T) {
+	p := newTestProcessor()
+
+	_, err := p.Execute(parseCmd(t, "RESTORE nope"))
+	if err == nil {
+		t.Error("RESTORE of unknown checkpoint should fail")
+	}
+}
+
+// VERIFY Tests
+
+func TestVerify_Consistent(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	p.Execute(parseCmd(t, "REFUND P001"))
+
+	result, err := p.Execute(parseCmd(t, "VERIFY"))
+	if err != nil {
+		t.Fatalf("VERIFY failed: %v", err)
+	}
+	if result != "VERIFY: 1 payments checked, all consistent" {
+		t.Errorf("VERIFY result = %v", result)
+	}
+}
+
+func TestVerify_CurrencyDrift(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	p := NewProcessor(memStore, nil)
+
+	p.Execute(parseCmd(t, "CREATE P001 100.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+
+	payment, _ := memStore.Get("P001")
+	payment.Movements[0].Currency = "EUR"
+
+	result, err := p.Execute(parseCmd(t, "VERIFY"))
+	if err != nil {
+		t.Fatalf("VERIFY failed: %v", err)
+	}
+	if !strings.Contains(result, "1 of 1 payments inconsistent") ||
+		!strings.Contains(result, "P001: CAPTURE of 100.0 recorded in EUR") {
+		t.Errorf("VERIFY result = %v, want currency drift for P001", result)
+	}
+}
+
+func TestList_Columns(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+
+	result, err := p.Execute(parseCmd(t, "LIST COLUMNS state,id,amount"))
+	if err != nil {
+		t.Fatalf("LIST COLUMNS failed: %v", err)
+	}
+	want := "Payments:\n  state=AUTHORIZED id=P001 amount=10.0"
+	if result != want {
+		t.Errorf("LIST COLUMNS result = %q, want %q", result, want)
+	}
+	if strings.Contains(result, "merchant") || strings.Contains(result, "USD") {
+		t.Errorf("LIST COLUMNS should only include selected columns: %v", result)
+	}
+}
+
+func TestList_Align(t *testing.T) {
+	p := newTestProcessor()
+	p.SetListAlign(8)
+
+	p.Execute(parseCmd(t, "CREATE P1 5.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P1000 12345 JPY M002"))
+	p.Execute(parseCmd(t, "CREATE P22 100.00 EUR M003"))
+	p.Execute(parseCmd(t, "AUTHORIZE P22"))
+
+	result, err := p.Execute(parseCmd(t, "LIST"))
+	if err != nil {
+		t.Fatalf("LIST failed: %v", err)
+	}
+	want := "Payments:\n" +
+		"  P1:    state=INITIATED  amount=     5.0 USD merchant=M001\n" +
+		"  P1000: state=INITIATED  amount= 12345.0 JPY merchant=M002\n" +
+		"  P22:   state=AUTHORIZED amount=   100.0 EUR merchant=M003"
+	if result != want {
+		t.Errorf("LIST aligned =\n%v\nwant\n%v", result, want)
+	}
+
+	// Amounts wider than the configured width widen the column
+	p.SetListAlign(1)
+	result, _ = p.Execute(parseCmd(t, "LIST"))
+	if !strings.Contains(result, "amount=    5.0 USD") {
+		t.Errorf("LIST should widen to the longest amount: %v", result)
+	}
+}
+
+func TestList_SortByAmountBreaksTiesByID(t *testing.T) {
+	orders := [][]string{
+		{"P003", "P001", "P004", "P002"},
+		{"P002", "P004", "P001", "P003"},
+		{"P004", "P003", "P002", "P001"},
+	}
+	amounts := map[string]string{"P001": "20.00", "P002": "10.00", "P003": "20.00", "P004": "10.00"}
+	want := "Payments:\n  id=P002 amount=10.0\n  id=P004 amount=10.0\n  id=P001 amount=20.0\n  id=P003 amount=20.0"
+
+	for _, order := range orders {
+		p := newTestProcessor()
+		for _, id := range order {
+			p.Execute(parseCmd(t, "CREATE "+id+" "+amounts[id]+" USD M001"))
+		}
+		result, err := p.Execute(parseCmd(t, "LIST COLUMNS id,amount SORT amount"))
+		if err != nil {
+			t.Fatalf("LIST SORT failed: %v", err)
+		}
+		if result != want {
+			t.Errorf("insertion order %v: LIST SORT amount =\n%v\nwant\n%v", order, result, want)
+		}
+	}
+}
+
+func TestList_UnknownSortKey(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	if _, err := p.Execute(parseCmd(t, "LIST SORT colour")); err == nil {
+		t.Error("LIST SORT with unknown key should fail")
+	}
+}
+
+func TestList_Filters(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+	p.Execute(parseCmd(t, "CREATE P002 20.00 EUR M001"))
+	p.Execute(parseCmd(t, "CREATE P003 30.00 USD M002"))
+	p.Execute(parseCmd(t, "AUTHORIZE P001"))
+	p.Execute(parseCmd(t, "CAPTURE P001"))
+	p.Execute(parseCmd(t, "AUTHORIZE P003"))
+	p.Execute(parseCmd(t, "CAPTURE P003"))
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"LIST COLUMNS id state=CAPTURED", "Payments:\n  id=P001\n  id=P003"},
+		{"LIST COLUMNS id state=captured merchant=M001 currency=usd", "Payments:\n  id=P001"},
+		{"LIST COLUMNS id currency=EUR", "Payments:\n  id=P002"},
+		{"LIST merchant=M003", "No payments found"},
+	}
+	for _, tt := range tests {
+		result, err := p.Execute(parseCmd(t, tt.line))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.line, err)
+		}
+		if result != tt.want {
+			t.Errorf("%s =\n%v\nwant\n%v", tt.line, result, tt.want)
+		}
+	}
+}
+
+func TestList_UnknownFilter(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	if _, err := p.Execute(parseCmd(t, "LIST colour=red")); err == nil || !strings.Contains(err.Error(), "unknown LIST filter: colour") {
+		t.Errorf("LIST with unknown filter key error = %v", err)
+	}
+	if _, err := p.Execute(parseCmd(t, "LIST state=PENDING")); err == nil {
+		t.Error("LIST with unknown state should fail")
+	}
+}
+
+func TestList_Pagination(t *testing.T) {
+	p := newTestProcessor()
+	for _, id := range []string{"P005", "P003", "P001", "P004", "P002"} {
+		p.Execute(parseCmd(t, "CREATE "+id+" 10.00 USD M001"))
+	}
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"LIST COLUMNS id limit=2", "Payments:\n  id=P001\n  id=P002\nShowing 1-2 of 5"},
+		{"LIST COLUMNS id offset=2 limit=2", "Payments:\n  id=P003\n  id=P004\nShowing 3-4 of 5"},
+		{"LIST COLUMNS id offset=4 limit=2", "Payments:\n  id=P005\nShowing 5-5 of 5"},
+		{"LIST COLUMNS id offset=3", "Payments:\n  id=P004\n  id=P005\nShowing 4-5 of 5"},
+		{"LIST COLUMNS id offset=9", "Payments:\nShowing 0 of 5"},
+	}
+	for _, tt := range tests {
+		result, err := p.Execute(parseCmd(t, tt.line))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.line, err)
+		}
+		if result != tt.want {
+			t.Errorf("%s =\n%v\nwant\n%v", tt.line, result, tt.want)
+		}
+	}
+}
+
+func TestList_PaginationInvalid(t *testing.T) {
+	p := newTestProcessor()
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	for _, line := range []string{"LIST limit=-1", "LIST offset=-5", "LIST limit=ten", "LIST offset="} {
+		_, err := p.Execute(parseCmd(t, line))
+		var verr *domain.ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s error = %v, want ValidationError", line, err)
+		}
+	}
+}
+
+func TestList_UnknownColumn(t *testing.T) {
+	p := newTestProcessor()
+
+	p.Execute(parseCmd(t, "CREATE P001 10.00 USD M001"))
+
+	if _, err := p.Execute(parseCmd(t, "LIST COLUMNS id,colour")); err == nil {
+		t.Error("LIST COLUMNS with unknown column should fail")
+	}
+	if _, err := p.Execute(parseCmd(t, "LIST SIDEWAYS")); err == nil {
+		t.Error("LIST with unknown option should fail")
 	}
 }
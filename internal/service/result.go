@@ -0,0 +1,201 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"payment-sim/internal/domain"
+)
+
+// Result is the structured outcome of a successfully executed command.
+// Handlers return a Result; a Formatter renders it at the output boundary.
+type Result struct {
+	Command   string        // Command name, e.g. "CREATE"
+	PaymentID string        // Affected payment, empty for store-wide commands
+	Outcome   string        // Short machine-readable outcome, e.g. "created", "idempotent"
+	State     string        // State of the affected payment after the command
+	Amount    *big.Rat      // Amount of the affected payment, if any
+	Currency  string        // Currency of the affected payment, if any
+	Message   string        // Human-readable text form of the result
+	Duration  time.Duration // How long the command took, if timed
+}
+
+// TimedError is a command error annotated with how long the command took.
+type TimedError struct {
+	Err      error
+	Duration time.Duration
+}
+
+func (e *TimedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TimedError) Unwrap() error {
+	return e.Err
+}
+
+// errorDuration returns the duration attached to err, or zero.
+func errorDuration(err error) time.Duration {
+	var timed *TimedError
+	if errors.As(err, &timed) {
+		return timed.Duration
+	}
+	return 0
+}
+
+// durationTrailer renders a duration as the " (1.5ms)" suffix of text
+// output, or "" for zero.
+func durationTrailer(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", d)
+}
+
+// newPaymentResult builds a Result for a command that affected a single payment.
+func newPaymentResult(command, outcome string, payment *domain.Payment, message string) *Result {
+	return &Result{
+		Command:   command,
+		PaymentID: payment.ID,
+		Outcome:   outcome,
+		State:     payment.State,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Message:   message,
+	}
+}
+
+// newReportResult builds a Result for a command that is not about a single payment.
+func newReportResult(command, outcome, message string) *Result {
+	return &Result{
+		Command: command,
+		Outcome: outcome,
+		Message: message,
+	}
+}
+
+// Formatter renders a Result or an error for output.
+type Formatter interface {
+	Format(r *Result) string
+	FormatError(err error) string
+}
+
+// TextFormatter renders results as the plain-text lines printed by the CLI.
+type TextFormatter struct{}
+
+// Format returns the human-readable form of the result, followed by its
+// duration if it has one.
+func (TextFormatter) Format(r *Result) string {
+	if r.Message == "" {
+		return ""
+	}
+	return r.Message + durationTrailer(r.Duration)
+}
+
+// FormatError returns the error line printed by the CLI.
+func (TextFormatter) FormatError(err error) string {
+	return "ERROR " + err.Error() + durationTrailer(errorDuration(err))
+}
+
+// JSONFormatter renders each result or error as a single-line JSON object.
+type JSONFormatter struct{}
+
+// jsonResult is the wire form of a Result.
+type jsonResult struct {
+	OK         bool   `json:"ok"`
+	Command    string `json:"command,omitempty"`
+	PaymentID  string `json:"payment_id,omitempty"`
+	Outcome    string `json:"outcome,omitempty"`
+	State      string `json:"state,omitempty"`
+	Amount     string `json:"amount,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationNS int64  `json:"duration_ns,omitempty"`
+}
+
+// Format returns the result as a JSON object. Results without a message
+// (such as EXIT) render as an empty string so nothing is printed.
+func (JSONFormatter) Format(r *Result) string {
+	if r.Message == "" {
+		return ""
+	}
+	out := jsonResult{
+		OK:         true,
+		Command:    r.Command,
+		PaymentID:  r.PaymentID,
+		Outcome:    r.Outcome,
+		State:      r.State,
+		Currency:   r.Currency,
+		Message:    r.Message,
+		DurationNS: r.Duration.Nanoseconds(),
+	}
+	if r.Amount != nil {
+		out.Amount = domain.FormatRat(r.Amount)
+	}
+	return marshalLine(out)
+}
+
+// FormatError returns the error as a JSON object with ok=false.
+func (JSONFormatter) FormatError(err error) string {
+	return marshalLine(jsonResult{OK: false, Error: err.Error(), DurationNS: errorDuration(err).Nanoseconds()})
+}
+
+// marshalLine encodes v as compact JSON. Encoding plain strings and bools
+// cannot fail.
+func marshalLine(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// ANSI escape sequences used by ColorFormatter.
+const (
+	ansiReset = "\x1b[0m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+)
+
+// stateColors are the ANSI colors used for state names in colored output.
+var stateColors = map[string]string{
+	domain.StateInitiated:           "\x1b[36m", // cyan
+	domain.StateAuthorized:          "\x1b[34m", // blue
+	domain.StatePreSettlementReview: "\x1b[33m", // yellow
+	domain.StateCaptured:            "\x1b[34m", // blue
+	domain.StateSettled:             "\x1b[1;32m",
+	domain.StateVoided:              "\x1b[35m", // magenta
+	domain.StateRefunded:            "\x1b[35m", // magenta
+	domain.StateFailed:              "\x1b[1;31m",
+	domain.StateReversed:            "\x1b[35m", // magenta
+	domain.StateDeclined:            "\x1b[1;31m",
+	domain.StateExpired:             "\x1b[35m", // magenta
+	domain.StateDisputed:            "\x1b[33m", // yellow
+	domain.StateChargedBack:         "\x1b[1;31m",
+}
+
+// statePattern matches whole state names inside a message.
+var statePattern = regexp.MustCompile(`\b(INITIATED|AUTHORIZED|PRE_SETTLEMENT_REVIEW|CAPTURED|SETTLED|VOIDED|REFUNDED|FAILED|REVERSED|DECLINED|EXPIRED|DISPUTED|CHARGED_BACK)\b`)
+
+// ColorFormatter wraps the text output in ANSI colors: successes in green,
+// errors in red, and state names in per-state colors. It is meant for
+// terminals only and must not wrap JSONFormatter.
+type ColorFormatter struct{}
+
+// Format returns the result message in green with state names highlighted.
+func (ColorFormatter) Format(r *Result) string {
+	if r.Message == "" {
+		return ""
+	}
+	colored := statePattern.ReplaceAllStringFunc(r.Message, func(state string) string {
+		return stateColors[state] + state + ansiReset + ansiGreen
+	})
+	return ansiGreen + colored + ansiReset + durationTrailer(r.Duration)
+}
+
+// FormatError returns the error line in red.
+func (ColorFormatter) FormatError(err error) string {
+	return ansiRed + TextFormatter{}.FormatError(err) + ansiReset
+}
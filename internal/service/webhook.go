@@ -0,0 +1,236 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"payment-sim/internal/domain"
+)
+
+// WebhookClient delivers a single webhook payload.
+type WebhookClient interface {
+	Deliver(payload []byte) error
+}
+
+// HTTPWebhookClient POSTs payloads as JSON to a URL.
+type HTTPWebhookClient struct {
+	URL    string
+	Client *http.Client
+}
+
+// Deliver POSTs the payload and treats any non-2xx response as a failure.
+func (c HTTPWebhookClient) Deliver(payload []byte) error {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(c.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookPayload is the JSON body sent for each transition.
+type webhookPayload struct {
+	PaymentID string `json:"payment_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Action    string `json:"action"`
+}
+
+// webhookQueueSize is how many undelivered events an emitter buffers before
+// it starts dropping them.
+const webhookQueueSize = 1024
+
+// webhookPollInterval is the longest a retry wait sleeps before re-reading
+// the clock, so a SimClock advanced by TICK ends the wait promptly.
+const webhookPollInterval = 10 * time.Millisecond
+
+// sleeper is implemented by clocks that pass their own time when waited on,
+// such as the fake clocks of tests.
+type sleeper interface {
+	Sleep(d time.Duration)
+}
+
+// webhookDelivery is a queued event and its encoded payload.
+type webhookDelivery struct {
+	event   TransitionEvent
+	payload []byte
+}
+
+// WebhookEmitter sends every transition event to a webhook. Events are
+// queued and delivered by a background goroutine, so a slow or failing
+// endpoint never holds up the command that caused the event. Failed
+// deliveries are retried with exponential backoff measured on the emitter's
+// clock; once the retries are exhausted the failure is logged.
+type WebhookEmitter struct {
+	client  WebhookClient
+	retries int
+	backoff time.Duration
+	clock   domain.Clock
+	// log receives delivery failures (nil discards them).
+	log io.Writer
+
+	queue   chan webhookDelivery
+	pending sync.WaitGroup
+	// stop is closed by Close to cut retry waits short.
+	stop chan struct{}
+	// done is closed when the delivery goroutine exits.
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewWebhookEmitter creates an emitter that retries a failed delivery up to
+// retries times, waiting backoff, 2*backoff, 4*backoff, ... between attempts,
+// and starts its delivery goroutine. Call Close to stop it.
+func NewWebhookEmitter(client WebhookClient, retries int, backoff time.Duration, log io.Writer) *WebhookEmitter {
+	e := &WebhookEmitter{
+		client:  client,
+		retries: retries,
+		backoff: backoff,
+		clock:   domain.SystemClock{},
+		log:     log,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// SetClock sets the clock retry delays are measured on (default: the wall
+// clock). Set it before the first event is emitted.
+func (e *WebhookEmitter) SetClock(clock domain.Clock) {
+	e.clock = clock
+}
+
+// BackoffSchedule returns the wait before each retry.
+func (e *WebhookEmitter) BackoffSchedule() []time.Duration {
+	schedule := make([]time.Duration, e.retries)
+	delay := e.backoff
+	for i := range schedule {
+		schedule[i] = delay
+		delay *= 2
+	}
+	return schedule
+}
+
+// Emit queues the event for delivery and returns immediately. It is a
+// TransitionHook. Events are dropped, with a warning, when the queue is full
+// or the emitter is closed.
+func (e *WebhookEmitter) Emit(event TransitionEvent) {
+	payload, err := json.Marshal(webhookPayload{
+		PaymentID: event.PaymentID,
+		From:      event.From,
+		To:        event.To,
+		Action:    event.Action,
+	})
+	if err != nil {
+		e.logf("webhook payload for %s: %v", event.PaymentID, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		e.logf("webhook emitter closed, dropping %s %s->%s", event.PaymentID, event.From, event.To)
+		return
+	}
+	e.pending.Add(1)
+	select {
+	case e.queue <- webhookDelivery{event: event, payload: payload}:
+	default:
+		e.pending.Done()
+		e.logf("webhook queue full, dropping %s %s->%s", event.PaymentID, event.From, event.To)
+	}
+}
+
+// Flush waits until every queued event has been delivered or given up on.
+func (e *WebhookEmitter) Flush() {
+	e.pending.Wait()
+}
+
+// Close stops accepting events and waits for the queued ones. Pending retry
+// waits are cut short, so each remaining event gets no further attempts.
+func (e *WebhookEmitter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.stop)
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+	return nil
+}
+
+// run delivers queued events until the queue is closed.
+func (e *WebhookEmitter) run() {
+	defer close(e.done)
+	for d := range e.queue {
+		e.deliver(d)
+		e.pending.Done()
+	}
+}
+
+// deliver sends one event, retrying on failure.
+func (e *WebhookEmitter) deliver(d webhookDelivery) {
+	err := e.client.Deliver(d.payload)
+	attempts := 1
+	for _, delay := range e.BackoffSchedule() {
+		if err == nil || !e.wait(delay) {
+			break
+		}
+		err = e.client.Deliver(d.payload)
+		attempts++
+	}
+	if err != nil {
+		e.logf("webhook delivery for %s %s->%s failed after %d attempt(s): %v",
+			d.event.PaymentID, d.event.From, d.event.To, attempts, err)
+	}
+}
+
+// wait blocks for d on the emitter's clock. It reports false if Close cut
+// the wait short.
+func (e *WebhookEmitter) wait(d time.Duration) bool {
+	select {
+	case <-e.stop:
+		return false
+	default:
+	}
+	if s, ok := e.clock.(sleeper); ok {
+		s.Sleep(d)
+		return true
+	}
+	deadline := e.clock.Now().Add(d)
+	for {
+		remaining := deadline.Sub(e.clock.Now())
+		if remaining <= 0 {
+			return true
+		}
+		select {
+		case <-e.stop:
+			return false
+		case <-time.After(min(remaining, webhookPollInterval)):
+		}
+	}
+}
+
+// logf writes a warning line to the log, if any.
+func (e *WebhookEmitter) logf(format string, args ...interface{}) {
+	if e.log != nil {
+		fmt.Fprintf(e.log, "WARNING "+format+"\n", args...)
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"payment-sim/internal/domain"
+)
+
+// fileState is the JSON document a FileStore persists. Amounts are encoded
+// by big.Rat as exact fractions (e.g. "201/2"), so they round-trip losslessly.
+type fileState struct {
+	Payments        []*domain.Payment `json:"payments"`
+	BatchIDs        []string          `json:"batch_ids"`
+	IdempotencyKeys map[string]string `json:"idempotency_keys,omitempty"`
+}
+
+// FileStore is a MemoryStore whose contents are loaded from a JSON file on
+// construction and written back after every mutation and on Close.
+type FileStore struct {
+	*MemoryStore
+	path string
+
+	// flushMu serializes writes of the state file.
+	flushMu sync.Mutex
+}
+
+// NewFileStore opens the state file at path, loading its payments and batch
+// IDs. A missing file starts an empty store that is created on first write.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read store file: %w", err)
+	}
+
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid store file %s: %w", path, err)
+	}
+	for _, payment := range state.Payments {
+		s.payments[payment.ID] = payment
+	}
+	for _, batchID := range state.BatchIDs {
+		s.batchIDs[batchID] = true
+	}
+	for key, paymentID := range state.IdempotencyKeys {
+		s.idempotencyKeys[key] = paymentID
+	}
+	return s, nil
+}
+
+// Save stores a payment and writes the state file.
+func (s *FileStore) Save(payment *domain.Payment) error {
+	if err := s.MemoryStore.Save(payment); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Delete removes a payment and writes the state file.
+func (s *FileStore) Delete(id string) error {
+	if err := s.MemoryStore.Delete(id); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// RecordBatchID records a batch ID and writes the state file.
+func (s *FileStore) RecordBatchID(batchID string) error {
+	if err := s.MemoryStore.RecordBatchID(batchID); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// RenameBatch renames a batch and writes the state file.
+func (s *FileStore) RenameBatch(oldID, newID string) error {
+	if err := s.MemoryStore.RenameBatch(oldID, newID); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// RecordIdempotencyKey records an idempotency key and writes the state file.
+func (s *FileStore) RecordIdempotencyKey(key, paymentID string) error {
+	if err := s.MemoryStore.RecordIdempotencyKey(key, paymentID); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Restore replaces the store's contents with the snapshot and writes the
+// state file.
+func (s *FileStore) Restore(snapshot Snapshot) error {
+	if err := s.MemoryStore.Restore(snapshot); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Close writes the state file a final time.
+func (s *FileStore) Close() error {
+	return s.flush()
+}
+
+// flush writes the store's contents to a temporary file and renames it over
+// the state file, so a crash never leaves a half-written state behind.
+func (s *FileStore) flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	payments, err := s.MemoryStore.List()
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	keys := copyIdempotencyKeys(s.idempotencyKeys)
+	s.mu.RUnlock()
+	state := fileState{Payments: payments, BatchIDs: s.MemoryStore.GetBatchIDs(), IdempotencyKeys: keys}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot write store file: %w", err)
+	}
+	return nil
+}
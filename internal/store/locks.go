@@ -0,0 +1,75 @@
+package store
+
+import "sync"
+
+// PaymentLocker is implemented by stores that expose per-payment locks, so
+// callers can make multi-step operations on one payment atomic without
+// holding a store-wide lock.
+type PaymentLocker interface {
+	Lock(id string)
+	Unlock(id string)
+	WithLock(id string, fn func() error) error
+}
+
+// keyedLocks is a set of mutexes keyed by payment ID. Entries are reference
+// counted and removed once no goroutine holds or waits for them.
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is one ID's mutex and the number of goroutines using it.
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock blocks until the lock for id is held.
+func (k *keyedLocks) lock(id string) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[id]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[id] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+}
+
+// unlock releases the lock for id. It panics if id is not locked.
+func (k *keyedLocks) unlock(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	l, ok := k.locks[id]
+	if !ok {
+		panic("store: unlock of unlocked payment " + id)
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, id)
+	}
+	l.mu.Unlock()
+}
+
+// Lock acquires the lock for the payment ID, blocking until it is available.
+// Locks for different IDs are independent.
+func (s *MemoryStore) Lock(id string) {
+	s.paymentLocks.lock(id)
+}
+
+// Unlock releases the lock for the payment ID.
+func (s *MemoryStore) Unlock(id string) {
+	s.paymentLocks.unlock(id)
+}
+
+// WithLock runs fn while holding the lock for the payment ID.
+func (s *MemoryStore) WithLock(id string, fn func() error) error {
+	s.Lock(id)
+	defer s.Unlock(id)
+	return fn()
+}
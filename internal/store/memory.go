@@ -1,7 +1,9 @@
-// Package store provides in-memory storage for the payment processing system.
+// Package store provides in-memory and file-backed storage for the payment
+// processing system.
 package store
 
 import (
+	"fmt"
 	"sort"
 	"sync"
 
@@ -14,23 +16,33 @@ type Repository interface {
 	Get(id string) (*domain.Payment, error)
 	List() ([]*domain.Payment, error)
 	Exists(id string) bool
-	RecordBatchID(batchID string)
+	Delete(id string) error
+	RecordBatchID(batchID string) error
 	GetBatchIDs() []string
 	BatchIDExists(batchID string) bool
+	RenameBatch(oldID, newID string) error
+	RecordIdempotencyKey(key, paymentID string) error
+	LookupIdempotencyKey(key string) (string, bool)
 }
 
 // MemoryStore is an in-memory implementation of Repository.
 type MemoryStore struct {
 	payments map[string]*domain.Payment
 	batchIDs map[string]bool
-	mu       sync.RWMutex
+	// idempotencyKeys maps each CREATE idempotency key to its payment ID.
+	idempotencyKeys map[string]string
+	mu              sync.RWMutex
+
+	// paymentLocks are the per-payment locks exposed through PaymentLocker.
+	paymentLocks keyedLocks
 }
 
 // NewMemoryStore creates a new in-memory store.
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		payments: make(map[string]*domain.Payment),
-		batchIDs: make(map[string]bool),
+		payments:        make(map[string]*domain.Payment),
+		batchIDs:        make(map[string]bool),
+		idempotencyKeys: make(map[string]string),
 	}
 }
 
@@ -81,11 +93,29 @@ func (s *MemoryStore) Exists(id string) bool {
 	return exists
 }
 
+// Delete removes a payment and any idempotency keys that refer to it. It
+// returns domain.ErrPaymentNotFound if the payment does not exist.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.payments[id]; !exists {
+		return domain.ErrPaymentNotFound
+	}
+	delete(s.payments, id)
+	for key, paymentID := range s.idempotencyKeys {
+		if paymentID == id {
+			delete(s.idempotencyKeys, key)
+		}
+	}
+	return nil
+}
+
 // RecordBatchID records a processed batch ID.
-func (s *MemoryStore) RecordBatchID(batchID string) {
+func (s *MemoryStore) RecordBatchID(batchID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.batchIDs[batchID] = true
+	return nil
 }
 
 // GetBatchIDs returns all recorded batch IDs sorted.
@@ -106,3 +136,44 @@ func (s *MemoryStore) BatchIDExists(batchID string) bool {
 	defer s.mu.RUnlock()
 	return s.batchIDs[batchID]
 }
+
+// RenameBatch renames a recorded batch ID and re-stamps every payment that
+// carries the old ID. It fails if the old ID is unknown or the new ID exists.
+func (s *MemoryStore) RenameBatch(oldID, newID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.batchIDs[oldID] {
+		return fmt.Errorf("batch %s not found", oldID)
+	}
+	if s.batchIDs[newID] {
+		return fmt.Errorf("batch %s already exists", newID)
+	}
+	delete(s.batchIDs, oldID)
+	s.batchIDs[newID] = true
+	for _, payment := range s.payments {
+		if payment.BatchID == oldID {
+			payment.AssignBatch(newID)
+		}
+	}
+	return nil
+}
+
+// RecordIdempotencyKey records the payment created under an idempotency key.
+// It fails if the key is already recorded for a different payment.
+func (s *MemoryStore) RecordIdempotencyKey(key, paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.idempotencyKeys[key]; ok && existing != paymentID {
+		return fmt.Errorf("idempotency key %s already recorded for payment %s", key, existing)
+	}
+	s.idempotencyKeys[key] = paymentID
+	return nil
+}
+
+// LookupIdempotencyKey returns the payment ID recorded for an idempotency key.
+func (s *MemoryStore) LookupIdempotencyKey(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paymentID, ok := s.idempotencyKeys[key]
+	return paymentID, ok
+}
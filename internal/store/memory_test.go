@@ -2,8 +2,11 @@ package store
 
 import (
 	"math/big"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"payment-sim/internal/domain"
 )
@@ -78,6 +81,49 @@ func TestMemoryStore_Exists(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_Delete(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(domain.NewPayment("P001", big.NewRat(100, 1), "USD", "M001"))
+
+	if err := store.Delete("P001"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if store.Exists("P001") {
+		t.Error("Exists() = true after Delete, want false")
+	}
+	if err := store.Delete("P001"); err != domain.ErrPaymentNotFound {
+		t.Errorf("Delete() error = %v, want ErrPaymentNotFound", err)
+	}
+}
+
+func TestMemoryStore_IdempotencyKeys(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(domain.NewPayment("P001", big.NewRat(100, 1), "USD", "M001"))
+
+	if _, ok := store.LookupIdempotencyKey("K1"); ok {
+		t.Error("LookupIdempotencyKey() found an unrecorded key")
+	}
+	if err := store.RecordIdempotencyKey("K1", "P001"); err != nil {
+		t.Fatalf("RecordIdempotencyKey() error = %v", err)
+	}
+	if id, ok := store.LookupIdempotencyKey("K1"); !ok || id != "P001" {
+		t.Errorf("LookupIdempotencyKey() = %q, %v, want P001", id, ok)
+	}
+	if err := store.RecordIdempotencyKey("K1", "P002"); err == nil {
+		t.Error("RecordIdempotencyKey() should reject a key recorded for another payment")
+	}
+
+	snapshot, _ := store.Snapshot()
+	store.Delete("P001")
+	if _, ok := store.LookupIdempotencyKey("K1"); ok {
+		t.Error("Delete() should drop the payment's idempotency keys")
+	}
+	store.Restore(snapshot)
+	if id, ok := store.LookupIdempotencyKey("K1"); !ok || id != "P001" {
+		t.Errorf("LookupIdempotencyKey() after Restore = %q, %v, want P001", id, ok)
+	}
+}
+
 func TestMemoryStore_BatchIDs(t *testing.T) {
 	store := NewMemoryStore()
 
@@ -137,3 +183,229 @@ func TestMemoryStore_Update(t *testing.T) {
 		t.Errorf("State = %v, want AUTHORIZED", got.State)
 	}
 }
+
+func TestMemoryStore_SnapshotRestore(t *testing.T) {
+	store := NewMemoryStore()
+	payment := domain.NewPayment("P001", big.NewRat(100, 1), "USD", "M001")
+	store.Save(payment)
+	store.RecordBatchID("BATCH001")
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// Mutate after the snapshot, including the shared amount and history
+	payment.Amount.SetInt64(999)
+	payment.TransitionTo(domain.StateAuthorized, "AUTHORIZE", "")
+	store.Save(domain.NewPayment("P002", big.NewRat(5, 1), "USD", "M001"))
+	store.RecordBatchID("BATCH002")
+
+	if err := store.Restore(snap); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	got, err := store.Get("P001")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Amount.Cmp(big.NewRat(100, 1)) != 0 || got.State != domain.StateInitiated || len(got.History) != 1 {
+		t.Errorf("Restored P001 = amount %v state %v history %d, want 100 INITIATED 1",
+			got.Amount, got.State, len(got.History))
+	}
+	if store.Exists("P002") || store.BatchIDExists("BATCH002") {
+		t.Error("Restore() should drop data added after the snapshot")
+	}
+	if !store.BatchIDExists("BATCH001") {
+		t.Error("Restore() should keep batch IDs from the snapshot")
+	}
+}
+
+func TestReadOnlyStore(t *testing.T) {
+	memStore := NewMemoryStore()
+	memStore.Save(domain.NewPayment("P001", big.NewRat(100, 1), "USD", "M001"))
+	memStore.RecordBatchID("BATCH001")
+	view := NewReadOnlyStore(memStore)
+
+	if err := view.Save(domain.NewPayment("P002", big.NewRat(5, 1), "USD", "M001")); err != ErrReadOnly {
+		t.Errorf("Save() error = %v, want ErrReadOnly", err)
+	}
+	if err := view.RecordBatchID("BATCH002"); err != ErrReadOnly {
+		t.Errorf("RecordBatchID() error = %v, want ErrReadOnly", err)
+	}
+	if err := view.Delete("P001"); err != ErrReadOnly {
+		t.Errorf("Delete() error = %v, want ErrReadOnly", err)
+	}
+	if err := view.RecordIdempotencyKey("K1", "P001"); err != ErrReadOnly {
+		t.Errorf("RecordIdempotencyKey() error = %v, want ErrReadOnly", err)
+	}
+	if !memStore.Exists("P001") || memStore.Exists("P002") || memStore.BatchIDExists("BATCH002") {
+		t.Error("read-only view mutated the underlying store")
+	}
+
+	got, err := view.Get("P001")
+	if err != nil || got.ID != "P001" {
+		t.Fatalf("Get() = %v, %v, want P001", got, err)
+	}
+	got.State = domain.StateFailed
+	if original, _ := memStore.Get("P001"); original.State != domain.StateInitiated {
+		t.Error("mutating a payment from Get() changed the underlying store")
+	}
+	if _, err := view.Get("P404"); err != domain.ErrPaymentNotFound {
+		t.Errorf("Get() error = %v, want ErrPaymentNotFound", err)
+	}
+
+	payments, err := view.List()
+	if err != nil || len(payments) != 1 {
+		t.Errorf("List() = %v, %v, want 1 payment", payments, err)
+	}
+	if !view.Exists("P001") || view.Exists("P002") {
+		t.Error("Exists() does not reflect the underlying store")
+	}
+	if !view.BatchIDExists("BATCH001") || len(view.GetBatchIDs()) != 1 {
+		t.Error("batch lookups do not reflect the underlying store")
+	}
+}
+
+func TestMemoryStore_PaymentLocks(t *testing.T) {
+	store := NewMemoryStore()
+	var _ PaymentLocker = store
+
+	// Same ID: the second holder must wait for the first to release
+	store.Lock("P001")
+	acquired := make(chan struct{})
+	go func() {
+		store.WithLock("P001", func() error {
+			close(acquired)
+			return nil
+		})
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second Lock(P001) acquired while the first was held")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Different ID: proceeds while P001 is held
+	done := make(chan struct{})
+	go func() {
+		store.WithLock("P002", func() error {
+			close(done)
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lock(P002) blocked behind Lock(P001)")
+	}
+
+	store.Unlock("P001")
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Lock(P001) not acquired after Unlock")
+	}
+}
+
+func TestMemoryStore_WithLockSerializes(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(domain.NewPayment("P001", big.NewRat(1, 1), "USD", "M001"))
+
+	// Read-modify-write under the payment lock must not lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.WithLock("P001", func() error {
+				payment, _ := store.Get("P001")
+				updated := payment.Clone()
+				updated.Amount = new(big.Rat).Add(payment.Amount, big.NewRat(1, 1))
+				return store.Save(updated)
+			})
+		}()
+	}
+	wg.Wait()
+
+	payment, _ := store.Get("P001")
+	if payment.Amount.Cmp(big.NewRat(51, 1)) != 0 {
+		t.Errorf("Amount = %v, want 51", payment.Amount.RatString())
+	}
+	if len(store.paymentLocks.locks) != 0 {
+		t.Errorf("lock entries leaked: %d", len(store.paymentLocks.locks))
+	}
+}
+
+func TestFileStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	payment := domain.NewPayment("P001", big.NewRat(201, 2), "USD", "M001")
+	payment.TransitionTo(domain.StateAuthorized, "AUTHORIZE", "Payment authorized")
+	payment.TransitionTo(domain.StateCaptured, "CAPTURE", "Payment captured")
+	payment.RecordCapture(big.NewRat(1, 3), "USD")
+	payment.BatchID = "BATCH1"
+	if err := fs.Save(payment); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := fs.RecordBatchID("BATCH1"); err != nil {
+		t.Fatalf("RecordBatchID() error = %v", err)
+	}
+	if err := fs.RecordIdempotencyKey("K1", "P001"); err != nil {
+		t.Fatalf("RecordIdempotencyKey() error = %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() reopen error = %v", err)
+	}
+	got, err := reopened.Get("P001")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Amount.Cmp(big.NewRat(201, 2)) != 0 || got.CapturedAmount.Cmp(big.NewRat(1, 3)) != 0 {
+		t.Errorf("amounts = %v/%v, want exact 201/2 and 1/3", got.Amount, got.CapturedAmount)
+	}
+	if got.State != domain.StateCaptured || got.BatchID != "BATCH1" {
+		t.Errorf("payment = %s in %s, want CAPTURED in BATCH1", got.State, got.BatchID)
+	}
+	if len(got.History) != len(payment.History) {
+		t.Fatalf("history length = %d, want %d", len(got.History), len(payment.History))
+	}
+	for i, entry := range got.History {
+		want := payment.History[i]
+		if entry.Action != want.Action || entry.ToState != want.ToState || !entry.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("history[%d] = %+v, want %+v", i, entry, want)
+		}
+	}
+	if !reopened.BatchIDExists("BATCH1") {
+		t.Error("batch ID BATCH1 was not persisted")
+	}
+	if id, ok := reopened.LookupIdempotencyKey("K1"); !ok || id != "P001" {
+		t.Errorf("idempotency key K1 = %q, %v, want P001 persisted", id, ok)
+	}
+}
+
+func TestFileStore_MissingAndCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v, want an empty store", err)
+	}
+	if payments, _ := fs.List(); len(payments) != 0 {
+		t.Errorf("List() = %d payments, want 0", len(payments))
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte("{not json"), 0o644)
+	if _, err := NewFileStore(corrupt); err == nil {
+		t.Error("expected an error for a corrupt store file")
+	}
+}
@@ -37,8 +37,14 @@ func (m *MockRepository) Exists(id string) bool {
 	return args.Bool(0)
 }
 
-func (m *MockRepository) RecordBatchID(batchID string) {
-	m.Called(batchID)
+func (m *MockRepository) Delete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RecordBatchID(batchID string) error {
+	args := m.Called(batchID)
+	return args.Error(0)
 }
 
 func (m *MockRepository) GetBatchIDs() []string {
@@ -50,3 +56,18 @@ func (m *MockRepository) BatchIDExists(batchID string) bool {
 	args := m.Called(batchID)
 	return args.Bool(0)
 }
+
+func (m *MockRepository) RenameBatch(oldID, newID string) error {
+	args := m.Called(oldID, newID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RecordIdempotencyKey(key, paymentID string) error {
+	args := m.Called(key, paymentID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) LookupIdempotencyKey(key string) (string, bool) {
+	args := m.Called(key)
+	return args.String(0), args.Bool(1)
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"errors"
+
+	"payment-sim/internal/domain"
+)
+
+// ErrReadOnly is returned by the mutating methods of a ReadOnlyStore.
+var ErrReadOnly = errors.New("store is read-only")
+
+// ReadOnlyStore is a Repository view that rejects all mutations.
+// Get and List return deep copies, so callers cannot mutate the underlying
+// payments through the returned pointers either.
+type ReadOnlyStore struct {
+	repo Repository
+}
+
+// NewReadOnlyStore wraps repo in a read-only view.
+func NewReadOnlyStore(repo Repository) *ReadOnlyStore {
+	return &ReadOnlyStore{repo: repo}
+}
+
+// Save always fails with ErrReadOnly.
+func (s *ReadOnlyStore) Save(payment *domain.Payment) error {
+	return ErrReadOnly
+}
+
+// Get returns a copy of the payment with the given ID.
+func (s *ReadOnlyStore) Get(id string) (*domain.Payment, error) {
+	payment, err := s.repo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return payment.Clone(), nil
+}
+
+// List returns copies of all payments sorted by ID.
+func (s *ReadOnlyStore) List() ([]*domain.Payment, error) {
+	payments, err := s.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*domain.Payment, len(payments))
+	for i, payment := range payments {
+		result[i] = payment.Clone()
+	}
+	return result, nil
+}
+
+// Exists checks if a payment exists.
+func (s *ReadOnlyStore) Exists(id string) bool {
+	return s.repo.Exists(id)
+}
+
+// Delete always fails with ErrReadOnly.
+func (s *ReadOnlyStore) Delete(id string) error {
+	return ErrReadOnly
+}
+
+// RecordBatchID always fails with ErrReadOnly.
+func (s *ReadOnlyStore) RecordBatchID(batchID string) error {
+	return ErrReadOnly
+}
+
+// RenameBatch always fails with ErrReadOnly.
+func (s *ReadOnlyStore) RenameBatch(oldID, newID string) error {
+	return ErrReadOnly
+}
+
+// RecordIdempotencyKey always fails with ErrReadOnly.
+func (s *ReadOnlyStore) RecordIdempotencyKey(key, paymentID string) error {
+	return ErrReadOnly
+}
+
+// LookupIdempotencyKey returns the payment ID recorded for an idempotency key.
+func (s *ReadOnlyStore) LookupIdempotencyKey(key string) (string, bool) {
+	return s.repo.LookupIdempotencyKey(key)
+}
+
+// GetBatchIDs returns all recorded batch IDs sorted.
+func (s *ReadOnlyStore) GetBatchIDs() []string {
+	return s.repo.GetBatchIDs()
+}
+
+// BatchIDExists checks if a batch ID has been recorded.
+func (s *ReadOnlyStore) BatchIDExists(batchID string) bool {
+	return s.repo.BatchIDExists(batchID)
+}
@@ -0,0 +1,68 @@
+package store
+
+import "payment-sim/internal/domain"
+
+// Snapshot is a point-in-time deep copy of a store's contents.
+type Snapshot struct {
+	payments        map[string]*domain.Payment
+	batchIDs        map[string]bool
+	idempotencyKeys map[string]string
+}
+
+// Snapshotter is implemented by stores that can checkpoint and roll back
+// their contents.
+type Snapshotter interface {
+	Snapshot() (Snapshot, error)
+	Restore(snapshot Snapshot) error
+}
+
+// copyPayments deep-copies a payment map.
+func copyPayments(src map[string]*domain.Payment) map[string]*domain.Payment {
+	dst := make(map[string]*domain.Payment, len(src))
+	for id, payment := range src {
+		dst[id] = payment.Clone()
+	}
+	return dst
+}
+
+// copyBatchIDs copies a batch ID set.
+func copyBatchIDs(src map[string]bool) map[string]bool {
+	dst := make(map[string]bool, len(src))
+	for id, v := range src {
+		dst[id] = v
+	}
+	return dst
+}
+
+// copyIdempotencyKeys copies an idempotency key map.
+func copyIdempotencyKeys(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for key, paymentID := range src {
+		dst[key] = paymentID
+	}
+	return dst
+}
+
+// Snapshot returns a deep copy of the store's payments, batch IDs and
+// idempotency keys.
+// Later mutations of the store never alter the snapshot.
+func (s *MemoryStore) Snapshot() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Snapshot{
+		payments:        copyPayments(s.payments),
+		batchIDs:        copyBatchIDs(s.batchIDs),
+		idempotencyKeys: copyIdempotencyKeys(s.idempotencyKeys),
+	}, nil
+}
+
+// Restore replaces the store's contents with a copy of the snapshot, so the
+// same snapshot can be restored more than once.
+func (s *MemoryStore) Restore(snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments = copyPayments(snapshot.payments)
+	s.batchIDs = copyBatchIDs(snapshot.batchIDs)
+	s.idempotencyKeys = copyIdempotencyKeys(snapshot.idempotencyKeys)
+	return nil
+}